@@ -0,0 +1,483 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Aggregation aggregation pipeline builder of a model, it is immutable like Query.
+// Field names used by stages are checked against the bson names of the model and the fields output by previous stages,
+// the first invalid stage makes All, One, Out and Merge return an error
+type Aggregation struct {
+	model  *Model
+	stages mongo.Pipeline
+	// fields output by the last stage, nil after Stage because the output is unknown
+	fields   map[string]bool
+	unscoped bool
+	err      error
+}
+
+// MergeOptions options of the $merge stage, see Aggregation.Merge
+type MergeOptions struct {
+	Into string
+	// On fields identifying documents in Into, default is _id
+	On []string
+	// WhenMatched merge (default), replace, keepExisting or fail
+	WhenMatched string
+	// WhenNotMatched insert (default), discard or fail
+	WhenNotMatched string
+}
+
+// Aggregate start an aggregation pipeline of the model, default scopes and query scopes are added as the first $match
+func (model *Model) Aggregate() *Aggregation {
+	fields := map[string]bool{"_id": true, model.primaryKey: true}
+	for _, field := range model.fields {
+		fields[field.BsonName] = true
+	}
+	return &Aggregation{model: model, fields: fields}
+}
+
+// clone copy aggregation so the returned aggregation can be changed without affecting a
+func (a *Aggregation) clone() *Aggregation {
+	cloned := *a
+	cloned.stages = append(mongo.Pipeline(nil), a.stages...)
+	return &cloned
+}
+
+// add append a stage which outputs fields, fields is nil if they are the same as before
+func (a *Aggregation) add(stage bson.D, fields map[string]bool, err error) *Aggregation {
+	if a.err != nil {
+		return a
+	}
+	cloned := a.clone()
+	if err != nil {
+		cloned.err = fmt.Errorf("goose: aggregate %s stage %d %s: %w", a.model.collectionName, len(a.stages)+1, stage[0].Key, err)
+		return cloned
+	}
+	cloned.stages = append(cloned.stages, stage)
+	if fields != nil {
+		cloned.fields = fields
+	}
+	return cloned
+}
+
+// checkField check the top level name of a dotted field path
+func (a *Aggregation) checkField(path string) error {
+	if a.fields == nil {
+		return nil
+	}
+	if root := strings.SplitN(path, ".", 2)[0]; !a.fields[root] {
+		return fmt.Errorf("unknown field %q", path)
+	}
+	return nil
+}
+
+// checkFilter check the fields of a query filter
+func (a *Aggregation) checkFilter(filter interface{}) error {
+	doc, err := toDocument(filter)
+	if err != nil {
+		return err
+	}
+	for _, e := range doc {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			conditions, _ := e.Value.(bson.A)
+			for _, condition := range conditions {
+				if err := a.checkFilter(condition); err != nil {
+					return err
+				}
+			}
+		case "$expr":
+			if err := a.checkExpression(e.Value); err != nil {
+				return err
+			}
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				continue
+			}
+			if err := a.checkField(e.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkExpression check field paths like "$amount" in an expression, variables like "$$ROOT" are not checked
+func (a *Aggregation) checkExpression(expr interface{}) error {
+	switch value := expr.(type) {
+	case string:
+		if strings.HasPrefix(value, "$") && !strings.HasPrefix(value, "$$") {
+			return a.checkField(value[1:])
+		}
+	case bson.D:
+		for _, e := range value {
+			if err := a.checkExpression(e.Value); err != nil {
+				return err
+			}
+		}
+	case bson.A:
+		for _, item := range value {
+			if err := a.checkExpression(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expression normalize an expression to bson.D, bson.A and values, as they are decoded from bson
+func expression(v interface{}) (interface{}, error) {
+	doc, err := toDocument(bson.D{{Key: "v", Value: v}})
+	if err != nil {
+		return nil, err
+	}
+	return doc[0].Value, nil
+}
+
+// sortedKeys keys of m in order, so built stages are stable
+func sortedKeys(m bson.M) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Match add a $match stage
+func (a *Aggregation) Match(filter bson.M) *Aggregation {
+	return a.add(bson.D{{Key: "$match", Value: filter}}, nil, a.checkFilter(filter))
+}
+
+// Group add a $group stage grouping by id, an expression such as "$customer" or bson.M{"year": "$year"},
+// fields are accumulators such as bson.M{"total": bson.M{"$sum": "$amount"}}. The output fields are _id and fields
+func (a *Aggregation) Group(id interface{}, fields bson.M) *Aggregation {
+	group := bson.D{{Key: "_id", Value: id}}
+	output := map[string]bool{"_id": true}
+	var err error
+	check := func(v interface{}) {
+		if err != nil {
+			return
+		}
+		var expr interface{}
+		if expr, err = expression(v); err == nil {
+			err = a.checkExpression(expr)
+		}
+	}
+	check(id)
+	for _, key := range sortedKeys(fields) {
+		check(fields[key])
+		group = append(group, bson.E{Key: key, Value: fields[key]})
+		output[key] = true
+	}
+	return a.add(bson.D{{Key: "$group", Value: group}}, output, err)
+}
+
+// Lookup add a $lookup stage joining documents of another collection as an array field
+func (a *Aggregation) Lookup(from string, localField string, foreignField string, as string) *Aggregation {
+	err := a.checkField(localField)
+	if related, ok := registeredCollectionModel(from); ok && err == nil {
+		err = related.Aggregate().checkField(foreignField)
+	}
+	var output map[string]bool
+	if a.fields != nil {
+		output = copyFields(a.fields)
+		output[strings.SplitN(as, ".", 2)[0]] = true
+	}
+	return a.add(bson.D{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: from},
+		{Key: "localField", Value: localField},
+		{Key: "foreignField", Value: foreignField},
+		{Key: "as", Value: as},
+	}}}, output, err)
+}
+
+// Unwind add a $unwind stage outputting a document for each element of an array field,
+// documents without elements are dropped
+func (a *Aggregation) Unwind(field string) *Aggregation {
+	return a.add(bson.D{{Key: "$unwind", Value: "$" + field}}, nil, a.checkField(field))
+}
+
+// UnwindPreserveEmpty add a $unwind stage like Unwind, documents without elements are kept
+func (a *Aggregation) UnwindPreserveEmpty(field string) *Aggregation {
+	return a.add(bson.D{{Key: "$unwind", Value: bson.D{
+		{Key: "path", Value: "$" + field},
+		{Key: "preserveNullAndEmptyArrays", Value: true},
+	}}}, nil, a.checkField(field))
+}
+
+// Project add a $project stage, such as bson.M{"name": 1, "total": "$amount"} or bson.M{"secret": 0}
+func (a *Aggregation) Project(projection bson.M) *Aggregation {
+	project := bson.D{}
+	output := map[string]bool{"_id": true}
+	excluded := map[string]bool{}
+	exclusion := false
+	var err error
+	for _, key := range sortedKeys(projection) {
+		project = append(project, bson.E{Key: key, Value: projection[key]})
+		value, exprErr := expression(projection[key])
+		if exprErr != nil {
+			err = exprErr
+			break
+		}
+		switch {
+		case isProjectionFlag(value) && !truthy(value):
+			if key == "_id" {
+				delete(output, "_id")
+			} else {
+				exclusion = true
+			}
+			excluded[key] = true
+			if err == nil {
+				err = a.checkField(key)
+			}
+		case isProjectionFlag(value):
+			output[strings.SplitN(key, ".", 2)[0]] = true
+			if err == nil {
+				err = a.checkField(key)
+			}
+		default:
+			output[strings.SplitN(key, ".", 2)[0]] = true
+			if err == nil {
+				err = a.checkExpression(value)
+			}
+		}
+	}
+	if exclusion && a.fields != nil {
+		output = copyFields(a.fields)
+		for key := range excluded {
+			delete(output, key)
+		}
+	} else if exclusion {
+		output = nil
+	}
+	return a.add(bson.D{{Key: "$project", Value: project}}, output, err)
+}
+
+func isProjectionFlag(value interface{}) bool {
+	if _, ok := value.(bool); ok {
+		return true
+	}
+	_, ok := numberToFloat(value)
+	return ok
+}
+
+// Sort add a $sort stage
+func (a *Aggregation) Sort(sort bson.D) *Aggregation {
+	var err error
+	for _, e := range sort {
+		if err = a.checkField(e.Key); err != nil {
+			break
+		}
+	}
+	return a.add(bson.D{{Key: "$sort", Value: sort}}, nil, err)
+}
+
+// Skip add a $skip stage
+func (a *Aggregation) Skip(n int64) *Aggregation {
+	return a.add(bson.D{{Key: "$skip", Value: n}}, nil, nil)
+}
+
+// Limit add a $limit stage
+func (a *Aggregation) Limit(n int64) *Aggregation {
+	return a.add(bson.D{{Key: "$limit", Value: n}}, nil, nil)
+}
+
+// Count add a $count stage outputting the number of documents as field
+func (a *Aggregation) Count(field string) *Aggregation {
+	return a.add(bson.D{{Key: "$count", Value: field}}, map[string]bool{field: true}, nil)
+}
+
+// Facet add a $facet stage, each facet builds a sub-pipeline from an aggregation with the current fields.
+// The output is one document with an array field for each facet
+func (a *Aggregation) Facet(facets map[string]func(*Aggregation) *Aggregation) *Aggregation {
+	names := make([]string, 0, len(facets))
+	for name := range facets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	facet := bson.D{}
+	output := map[string]bool{}
+	var err error
+	for _, name := range names {
+		sub := facets[name](&Aggregation{model: a.model, fields: a.fields})
+		if sub.err != nil {
+			err = sub.err
+			break
+		}
+		facet = append(facet, bson.E{Key: name, Value: sub.stages})
+		output[name] = true
+	}
+	return a.add(bson.D{{Key: "$facet", Value: facet}}, output, err)
+}
+
+// Stage add a raw stage, fields are not checked after it
+func (a *Aggregation) Stage(stage bson.D) *Aggregation {
+	if a.err != nil {
+		return a
+	}
+	cloned := a.clone()
+	cloned.stages = append(cloned.stages, stage)
+	cloned.fields = nil
+	return cloned
+}
+
+// Unscoped do not add default scopes, query scopes added by plugins are still added
+func (a *Aggregation) Unscoped() *Aggregation {
+	cloned := a.clone()
+	cloned.unscoped = true
+	return cloned
+}
+
+// Pipeline the built stages without scopes
+func (a *Aggregation) Pipeline() (mongo.Pipeline, error) {
+	return append(mongo.Pipeline(nil), a.stages...), a.err
+}
+
+func copyFields(fields map[string]bool) map[string]bool {
+	copied := make(map[string]bool, len(fields))
+	for key := range fields {
+		copied[key] = true
+	}
+	return copied
+}
+
+// run the pipeline with scopes and extra final stages
+func (a *Aggregation) run(ctx context.Context, final ...bson.D) (cursor, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	pipeline := append(mongo.Pipeline(nil), a.stages...)
+	pipeline = append(pipeline, final...)
+	query := a.model.query()
+	if a.unscoped {
+		query = query.Unscoped()
+	}
+	filter, err := query.scopeFilter(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !isNilFilter(filter) {
+		pipeline = append(mongo.Pipeline{{{Key: "$match", Value: filter}}}, pipeline...)
+	}
+	if pipeline, err = a.model.scopeLookups(ctx, pipeline); err != nil {
+		return nil, err
+	}
+	cur, err := a.model.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, a.model.translateError(err)
+	}
+	return cur, nil
+}
+
+// checkResult check the bson fields of the struct elements of results are output by the pipeline
+func (a *Aggregation) checkResult(results interface{}) error {
+	t := reflect.TypeOf(results)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || a.fields == nil {
+		return nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tags, err := bsoncodec.DefaultStructTagParser(field)
+		if err != nil || tags.Skip || tags.Inline {
+			continue
+		}
+		if !a.fields[tags.Name] {
+			return fmt.Errorf("goose: aggregate %s result field %s.%s (%s) is not output by the pipeline", a.model.collectionName, t.Name(), field.Name, tags.Name)
+		}
+	}
+	return nil
+}
+
+// All run the pipeline and decode the results into results, a pointer to a slice such as *[]OrderTotal.
+// Struct fields of the slice element must be output by the pipeline
+func (a *Aggregation) All(ctx context.Context, results interface{}) (err error) {
+	ctx, finish := a.model.startOperation(ctx, "Aggregate")
+	defer func() { finish(err) }()
+	if err := a.checkResult(results); err != nil {
+		return err
+	}
+	cur, err := a.run(ctx)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	return a.model.translateError(cur.All(ctx, results))
+}
+
+// One run the pipeline and decode the first result into result, return ErrNotFound if there is no result
+func (a *Aggregation) One(ctx context.Context, result interface{}) (err error) {
+	ctx, finish := a.model.startOperation(ctx, "Aggregate")
+	defer func() { finish(err) }()
+	if err := a.checkResult(result); err != nil {
+		return err
+	}
+	cur, err := a.Limit(1).run(ctx)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		if err := cur.Err(); err != nil {
+			return a.model.translateError(err)
+		}
+		return ErrNotFound
+	}
+	return cur.Decode(result)
+}
+
+// Out run the pipeline with a $out stage, replacing the documents of collectionName by the results
+func (a *Aggregation) Out(ctx context.Context, collectionName string) (err error) {
+	ctx, finish := a.model.startOperation(ctx, "Aggregate")
+	defer func() { finish(err) }()
+	cur, err := a.run(ctx, bson.D{{Key: "$out", Value: collectionName}})
+	if err != nil {
+		return err
+	}
+	return cur.Close(ctx)
+}
+
+// Merge run the pipeline with a $merge stage, writing the results into options.Into
+func (a *Aggregation) Merge(ctx context.Context, options MergeOptions) (err error) {
+	ctx, finish := a.model.startOperation(ctx, "Aggregate")
+	defer func() { finish(err) }()
+	if options.Into == "" {
+		return fmt.Errorf("goose: aggregate %s: $merge needs a collection", a.model.collectionName)
+	}
+	merge := bson.D{{Key: "into", Value: options.Into}}
+	if len(options.On) > 0 {
+		on := bson.A{}
+		for _, field := range options.On {
+			if err := a.checkField(field); err != nil {
+				return fmt.Errorf("goose: aggregate %s $merge: %w", a.model.collectionName, err)
+			}
+			on = append(on, field)
+		}
+		merge = append(merge, bson.E{Key: "on", Value: on})
+	}
+	if options.WhenMatched != "" {
+		merge = append(merge, bson.E{Key: "whenMatched", Value: options.WhenMatched})
+	}
+	if options.WhenNotMatched != "" {
+		merge = append(merge, bson.E{Key: "whenNotMatched", Value: options.WhenNotMatched})
+	}
+	cur, err := a.run(ctx, bson.D{{Key: "$merge", Value: merge}})
+	if err != nil {
+		return err
+	}
+	return cur.Close(ctx)
+}
@@ -0,0 +1,152 @@
+package goose
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAggregation(t *testing.T) {
+	type Customer struct {
+		ID   int64  `goose:"primary" bson:"_id"`
+		Name string `bson:"name"`
+	}
+	type Order struct {
+		ID         int64    `goose:"primary" bson:"_id"`
+		CustomerID int64    `bson:"customerId"`
+		Amount     int      `bson:"amount"`
+		Status     string   `bson:"status"`
+		Tags       []string `bson:"tags"`
+	}
+	NewMemoryDatabase()
+	customers, err := NewModel("aggregateCustomers", &Customer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := NewModel("aggregateOrders", &Order{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders.DefaultScope("valid", bson.M{"status": bson.M{"$ne": "cancelled"}})
+	ctx := context.Background()
+	for _, customer := range []*Customer{{ID: 1, Name: "ann"}, {ID: 2, Name: "bob"}} {
+		if _, err := customers.InsertOne(ctx, customer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, order := range []*Order{
+		{ID: 1, CustomerID: 1, Amount: 10, Status: "paid", Tags: []string{"a", "b"}},
+		{ID: 2, CustomerID: 1, Amount: 20, Status: "paid", Tags: []string{"b"}},
+		{ID: 3, CustomerID: 2, Amount: 5, Status: "open"},
+		{ID: 4, CustomerID: 2, Amount: 100, Status: "cancelled"},
+	} {
+		if _, err := orders.InsertOne(ctx, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type CustomerTotal struct {
+		CustomerID int64 `bson:"_id"`
+		Total      int   `bson:"total"`
+		Count      int   `bson:"count"`
+	}
+	var totals []CustomerTotal
+	err = orders.Aggregate().
+		Group("$customerId", bson.M{"total": bson.M{"$sum": "$amount"}, "count": bson.M{"$sum": 1}}).
+		Sort(bson.D{{Key: "_id", Value: 1}}).
+		All(ctx, &totals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 2 || totals[0] != (CustomerTotal{1, 30, 2}) || totals[1] != (CustomerTotal{2, 5, 1}) {
+		t.Errorf("unexpected totals without cancelled order %+v", totals)
+	}
+	var sum struct {
+		Total int `bson:"total"`
+	}
+	if err := orders.Aggregate().Unscoped().Group(nil, bson.M{"total": bson.M{"$sum": "$amount"}}).One(ctx, &sum); err != nil || sum.Total != 135 {
+		t.Errorf("expected unscoped total 135, got %+v %v", sum, err)
+	}
+
+	type OrderCustomer struct {
+		ID       int64      `bson:"_id"`
+		Customer []Customer `bson:"customer"`
+	}
+	var joined OrderCustomer
+	err = orders.Aggregate().
+		Match(bson.M{"amount": bson.M{"$gte": 20}}).
+		Lookup("aggregateCustomers", "customerId", "_id", "customer").
+		Project(bson.M{"customer": 1}).
+		One(ctx, &joined)
+	if err != nil || joined.ID != 2 || len(joined.Customer) != 1 || joined.Customer[0].Name != "ann" {
+		t.Errorf("unexpected joined order %+v %v", joined, err)
+	}
+
+	type TagCount struct {
+		Tag   string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	var tagCounts []TagCount
+	if err := orders.Aggregate().Unwind("tags").Group("$tags", bson.M{"count": bson.M{"$sum": 1}}).Sort(bson.D{{Key: "_id", Value: 1}}).All(ctx, &tagCounts); err != nil {
+		t.Fatal(err)
+	}
+	if len(tagCounts) != 2 || tagCounts[1] != (TagCount{"b", 2}) {
+		t.Errorf("unexpected tag counts %+v", tagCounts)
+	}
+
+	var facets struct {
+		Paid  []Order `bson:"paid"`
+		Total []struct {
+			N int `bson:"n"`
+		} `bson:"total"`
+	}
+	err = orders.Aggregate().Facet(map[string]func(*Aggregation) *Aggregation{
+		"paid":  func(a *Aggregation) *Aggregation { return a.Match(bson.M{"status": "paid"}) },
+		"total": func(a *Aggregation) *Aggregation { return a.Count("n") },
+	}).One(ctx, &facets)
+	if err != nil || len(facets.Paid) != 2 || len(facets.Total) != 1 || facets.Total[0].N != 3 {
+		t.Errorf("unexpected facets %+v %v", facets, err)
+	}
+
+	for name, aggregation := range map[string]*Aggregation{
+		"match":      orders.Aggregate().Match(bson.M{"amout": 1}),
+		"group":      orders.Aggregate().Group("$customer", nil),
+		"sort":       orders.Aggregate().Group("$customerId", nil).Sort(bson.D{{Key: "amount", Value: 1}}),
+		"lookup":     orders.Aggregate().Lookup("aggregateCustomers", "customerId", "id", "customer"),
+		"facet":      orders.Aggregate().Facet(map[string]func(*Aggregation) *Aggregation{"x": func(a *Aggregation) *Aggregation { return a.Unwind("tag") }}),
+		"projection": orders.Aggregate().Project(bson.M{"amount": 1}).Match(bson.M{"status": "paid"}),
+	} {
+		if err := aggregation.All(ctx, &[]bson.M{}); err == nil || !strings.Contains(err.Error(), "unknown field") {
+			t.Errorf("%s: expected unknown field error, got %v", name, err)
+		}
+	}
+	if err := orders.Aggregate().Stage(bson.D{{Key: "$match", Value: bson.M{}}}).Match(bson.M{"anything": bson.M{"$exists": false}}).All(ctx, &[]bson.M{}); err != nil {
+		t.Errorf("expected no check after a raw stage, got %v", err)
+	}
+	if err := orders.Aggregate().Count("n").All(ctx, &totals); err == nil {
+		t.Error("expected result field error")
+	}
+
+	if err := orders.Aggregate().Group("$customerId", bson.M{"total": bson.M{"$sum": "$amount"}}).Out(ctx, "aggregateTotals"); err != nil {
+		t.Fatal(err)
+	}
+	err = orders.Aggregate().Unscoped().Match(bson.M{"status": "cancelled"}).
+		Group("$customerId", bson.M{"cancelled": bson.M{"$sum": "$amount"}}).
+		Merge(ctx, MergeOptions{Into: "aggregateTotals"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := defaultDatabase.collection("aggregateTotals").Find(ctx, bson.M{"_id": int64(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged []bson.M
+	if err := cur.All(ctx, &merged); err != nil || len(merged) != 1 || merged[0]["total"] != int32(5) || merged[0]["cancelled"] != int32(100) {
+		t.Errorf("unexpected merged totals %v %v", merged, err)
+	}
+	if err := orders.Aggregate().Merge(ctx, MergeOptions{Into: "aggregateTotals", On: []string{"missing"}}); err == nil {
+		t.Error("expected unknown $merge field error")
+	}
+}
@@ -0,0 +1,412 @@
+package goose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditProblemKind kind of a schema drift problem
+type AuditProblemKind string
+
+// audit problem kinds
+const (
+	// AuditMissingField a required field is missing, fields are required by `validate:"required"` and the primary key
+	AuditMissingField AuditProblemKind = "missing_field"
+	// AuditTypeMismatch the BSON type of a field is not the type the struct field is encoded to
+	AuditTypeMismatch AuditProblemKind = "type_mismatch"
+	// AuditUnknownField the field is not in the struct, decoding the document into the struct ignores it
+	AuditUnknownField AuditProblemKind = "unknown_field"
+	// AuditBrokenReference a populate field references a document which does not exist
+	AuditBrokenReference AuditProblemKind = "broken_reference"
+)
+
+// auditExamples number of example document ids of a problem
+const auditExamples = 3
+
+// auditReferenceBatch number of references checked by one query
+const auditReferenceBatch = 1000
+
+// AuditProblem a problem found in Count documents, paths of array items end with []
+type AuditProblem struct {
+	Kind     AuditProblemKind `json:"kind"`
+	Path     string           `json:"path"`
+	Expected string           `json:"expected,omitempty"`
+	Actual   string           `json:"actual,omitempty"`
+	Count    int64            `json:"count"`
+	// Examples relaxed Extended JSON of the primary keys of some documents with the problem
+	Examples []string `json:"examples,omitempty"`
+}
+
+// AuditReport schema drift of a collection, problems are sorted by count
+type AuditReport struct {
+	Collection string          `json:"collection"`
+	Scanned    int64           `json:"scanned"`
+	Problems   []*AuditProblem `json:"problems"`
+}
+
+// Audit scan sampleSize random documents of the collection, 0 for all, and check them against the model struct:
+// missing required fields, BSON types, fields unknown to the struct and broken populate references
+func (model *Model) Audit(ctx context.Context, sampleSize int64) (report *AuditReport, err error) {
+	ctx, finish := model.startOperation(ctx, "Audit")
+	defer func() { finish(err) }()
+	return audit(ctx, model.schema, model.collection, sampleSize)
+}
+
+// Audit check documents of a collection against value, a pointer to the model struct, like Model.Audit
+// without creating the model and its indexes
+func (d *Database) Audit(ctx context.Context, collectionName string, value interface{}, sampleSize int64) (*AuditReport, error) {
+	t := reflect.TypeOf(value)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("goose: model value must be a pointer to struct, got %T", value)
+	}
+	s, err := getSchema(t.Elem())
+	if err != nil {
+		return nil, err
+	}
+	return audit(ctx, s, d.collection(collectionName), sampleSize)
+}
+
+type auditor struct {
+	report   *AuditReport
+	problems map[string]*AuditProblem
+	// populate references by relation, value and the ids of documents referencing it
+	references []map[string]*auditReference
+	id         string
+}
+
+type auditReference struct {
+	value bson.RawValue
+	ids   []string
+}
+
+func audit(ctx context.Context, s *schema, coll collection, sampleSize int64) (*AuditReport, error) {
+	jsonSchema := s.jsonSchema()
+	a := &auditor{
+		report:     &AuditReport{Collection: coll.Name()},
+		problems:   map[string]*AuditProblem{},
+		references: make([]map[string]*auditReference, len(s.relationship)),
+	}
+	for i := range a.references {
+		a.references[i] = map[string]*auditReference{}
+	}
+
+	var cur cursor
+	var err error
+	if sampleSize > 0 {
+		// $sample picks random documents instead of the oldest ones of the natural order
+		cur, err = coll.Aggregate(ctx, bson.A{bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: sampleSize}}}}})
+	} else {
+		cur, err = coll.Find(ctx, bson.D{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		doc := cur.Raw()
+		a.report.Scanned++
+		a.id = ""
+		if id, err := doc.LookupErr(s.primaryKey); err == nil {
+			a.id = extJSONValue(id)
+		}
+		a.checkDocument("", doc, jsonSchema)
+		for i, relation := range s.relationship {
+			a.collectReferences(i, doc, relation.localField)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	for i, relation := range s.relationship {
+		if err := a.checkReferences(ctx, coll.Database().Collection(relation.from), relation, a.references[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, problem := range a.problems {
+		a.report.Problems = append(a.report.Problems, problem)
+	}
+	sort.Slice(a.report.Problems, func(i, j int) bool {
+		x, y := a.report.Problems[i], a.report.Problems[j]
+		if x.Count != y.Count {
+			return x.Count > y.Count
+		}
+		if x.Path != y.Path {
+			return x.Path < y.Path
+		}
+		return x.Kind < y.Kind
+	})
+	return a.report, nil
+}
+
+// addProblem count a problem of the current document
+func (a *auditor) addProblem(kind AuditProblemKind, path string, expected string, actual string) {
+	a.addProblemOf(kind, path, expected, actual, []string{a.id})
+}
+
+func (a *auditor) addProblemOf(kind AuditProblemKind, path string, expected string, actual string, ids []string) {
+	key := strings.Join([]string{string(kind), path, expected, actual}, "\x00")
+	problem, ok := a.problems[key]
+	if !ok {
+		problem = &AuditProblem{Kind: kind, Path: path, Expected: expected, Actual: actual}
+		a.problems[key] = problem
+	}
+	problem.Count += int64(len(ids))
+	for _, id := range ids {
+		if len(problem.Examples) < auditExamples && id != "" {
+			problem.Examples = append(problem.Examples, id)
+		}
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (a *auditor) checkDocument(path string, doc bson.Raw, schema bson.D) {
+	elements, err := doc.Elements()
+	if err != nil {
+		return
+	}
+	present := map[string]bool{}
+	for _, element := range elements {
+		present[element.Key()] = true
+	}
+	if required, ok := schemaKeyword(schema, "required").(bson.A); ok {
+		for _, name := range required {
+			if !present[name.(string)] {
+				a.addProblem(AuditMissingField, joinPath(path, name.(string)), "", "")
+			}
+		}
+	}
+	properties, hasProperties := schemaKeyword(schema, "properties").(bson.D)
+	additional, hasAdditional := schemaKeyword(schema, "additionalProperties").(bson.D)
+	for _, element := range elements {
+		elementPath := joinPath(path, element.Key())
+		switch {
+		case hasProperties:
+			if property, ok := schemaKeyword(properties, element.Key()).(bson.D); ok {
+				a.checkValue(elementPath, element.Value(), property)
+			} else {
+				a.addProblem(AuditUnknownField, elementPath, "", bsonTypeAlias(element.Value().Type))
+			}
+		case hasAdditional:
+			a.checkValue(elementPath, element.Value(), additional)
+		}
+	}
+}
+
+func (a *auditor) checkValue(path string, value bson.RawValue, schema bson.D) {
+	actual := bsonTypeAlias(value.Type)
+	if expected := schemaTypes(schema); len(expected) > 0 {
+		matched := false
+		for _, t := range expected {
+			matched = matched || t == actual
+		}
+		if !matched {
+			a.addProblem(AuditTypeMismatch, path, strings.Join(expected, "|"), actual)
+			return
+		}
+	}
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		a.checkDocument(path, value.Document(), schema)
+	case bsontype.Array:
+		items, ok := schemaKeyword(schema, "items").(bson.D)
+		if !ok {
+			return
+		}
+		values, err := value.Array().Values()
+		if err != nil {
+			return
+		}
+		for _, item := range values {
+			a.checkValue(path+"[]", item, items)
+		}
+	}
+}
+
+// collectReferences remember the values of a populate field, array items reference documents one by one
+func (a *auditor) collectReferences(relation int, doc bson.Raw, localField string) {
+	value, err := doc.LookupErr(strings.Split(localField, ".")...)
+	if err != nil || value.Type == bsontype.Null {
+		return
+	}
+	values := []bson.RawValue{value}
+	if value.Type == bsontype.Array {
+		values, _ = value.Array().Values()
+	}
+	for _, v := range values {
+		key := v.String()
+		reference, ok := a.references[relation][key]
+		if !ok {
+			reference = &auditReference{value: v}
+			a.references[relation][key] = reference
+		}
+		reference.ids = append(reference.ids, a.id)
+	}
+}
+
+// checkReferences find referenced documents in batches, references not found are broken
+func (a *auditor) checkReferences(ctx context.Context, from collection, relation Relation, references map[string]*auditReference) error {
+	keys := make([]string, 0, len(references))
+	for key := range references {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for start := 0; start < len(keys); start += auditReferenceBatch {
+		end := start + auditReferenceBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		values := make(bson.A, 0, end-start)
+		for _, key := range keys[start:end] {
+			values = append(values, references[key].value)
+		}
+		cur, err := from.Find(ctx,
+			bson.D{{Key: relation.foreignField, Value: bson.D{{Key: "$in", Value: values}}}},
+			options.Find().SetProjection(bson.D{{Key: relation.foreignField, Value: 1}}))
+		if err != nil {
+			return err
+		}
+		found := map[string]bool{}
+		for cur.Next(ctx) {
+			if value, err := cur.Raw().LookupErr(strings.Split(relation.foreignField, ".")...); err == nil {
+				found[value.String()] = true
+			}
+		}
+		if err := cur.Err(); err != nil {
+			cur.Close(ctx)
+			return err
+		}
+		cur.Close(ctx)
+		for _, key := range keys[start:end] {
+			if !found[key] {
+				a.addProblemOf(AuditBrokenReference, relation.localField, relation.from+"."+relation.foreignField, extJSONValue(references[key].value), references[key].ids)
+			}
+		}
+	}
+	return nil
+}
+
+func schemaKeyword(schema bson.D, key string) interface{} {
+	for _, e := range schema {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+func schemaTypes(schema bson.D) []string {
+	switch types := schemaKeyword(schema, "bsonType").(type) {
+	case string:
+		return []string{types}
+	case bson.A:
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = t.(string)
+		}
+		return names
+	}
+	return nil
+}
+
+// bsonTypeAlias the $jsonSchema bsonType alias of a BSON type
+func bsonTypeAlias(t bsontype.Type) string {
+	switch t {
+	case bsontype.Double:
+		return "double"
+	case bsontype.String:
+		return "string"
+	case bsontype.EmbeddedDocument:
+		return "object"
+	case bsontype.Array:
+		return "array"
+	case bsontype.Binary:
+		return "binData"
+	case bsontype.Undefined:
+		return "undefined"
+	case bsontype.ObjectID:
+		return "objectId"
+	case bsontype.Boolean:
+		return "bool"
+	case bsontype.DateTime:
+		return "date"
+	case bsontype.Null:
+		return "null"
+	case bsontype.Regex:
+		return "regex"
+	case bsontype.DBPointer:
+		return "dbPointer"
+	case bsontype.JavaScript:
+		return "javascript"
+	case bsontype.Symbol:
+		return "symbol"
+	case bsontype.CodeWithScope:
+		return "javascriptWithScope"
+	case bsontype.Int32:
+		return "int"
+	case bsontype.Timestamp:
+		return "timestamp"
+	case bsontype.Int64:
+		return "long"
+	case bsontype.Decimal128:
+		return "decimal"
+	case bsontype.MinKey:
+		return "minKey"
+	case bsontype.MaxKey:
+		return "maxKey"
+	}
+	return t.String()
+}
+
+// WriteText write the report as a table with the percentage of scanned documents of each problem
+func (report *AuditReport) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%s: scanned %d documents, %d problems\n", report.Collection, report.Scanned, len(report.Problems))
+	if len(report.Problems) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  COUNT\tPERCENT\tKIND\tPATH\tDETAIL\tEXAMPLES")
+	for _, problem := range report.Problems {
+		percent := 0.0
+		if report.Scanned > 0 {
+			percent = float64(problem.Count) * 100 / float64(report.Scanned)
+		}
+		fmt.Fprintf(tw, "  %d\t%.1f%%\t%s\t%s\t%s\t%s\n", problem.Count, percent, problem.Kind, problem.Path, problem.detail(), strings.Join(problem.Examples, " "))
+	}
+	return tw.Flush()
+}
+
+func (problem *AuditProblem) detail() string {
+	switch problem.Kind {
+	case AuditTypeMismatch:
+		return "expected " + problem.Expected + ", got " + problem.Actual
+	case AuditUnknownField:
+		return problem.Actual
+	case AuditBrokenReference:
+		return problem.Actual + " not found in " + problem.Expected
+	}
+	return ""
+}
+
+// WriteJSON write the report as indented JSON
+func (report *AuditReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
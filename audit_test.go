@@ -0,0 +1,94 @@
+package goose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAudit(t *testing.T) {
+	type Profile struct {
+		City string `bson:"city"`
+	}
+	type Member struct {
+		ID      int64    `goose:"primary" bson:"_id"`
+		Email   string   `bson:"email" validate:"required"`
+		Age     int64    `bson:"age,omitempty"`
+		Tags    []string `bson:"tags,omitempty"`
+		Profile Profile  `bson:"profile"`
+		TeamID  int64    `goose:"populate=Team" bson:"teamId,omitempty" ref:"teams"`
+	}
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+	teams := getCollection("teams")
+	if _, err := teams.InsertOne(ctx, bson.M{"_id": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	members := getCollection("members")
+	for _, doc := range []bson.D{
+		{{Key: "_id", Value: int64(1)}, {Key: "email", Value: "a@example.com"}, {Key: "teamId", Value: int64(1)}},
+		{{Key: "_id", Value: int64(2)}, {Key: "age", Value: "12"}, {Key: "teamId", Value: int64(2)}},
+		{{Key: "_id", Value: int64(3)}, {Key: "email", Value: "c@example.com"}, {Key: "tags", Value: bson.A{"x", 1}}, {Key: "nickname", Value: "c"}},
+		{{Key: "_id", Value: int64(4)}, {Key: "profile", Value: bson.D{{Key: "city", Value: int32(7)}}}, {Key: "teamId", Value: int64(2)}},
+	} {
+		if _, err := members.InsertOne(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := db.Audit(ctx, "members", &Member{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Scanned != 4 {
+		t.Errorf("expected 4 scanned, got %d", report.Scanned)
+	}
+	var got []string
+	for _, problem := range report.Problems {
+		got = append(got, strings.Join([]string{string(problem.Kind), problem.Path, problem.detail(), strings.Join(problem.Examples, " ")}, "|"))
+	}
+	want := []string{
+		`missing_field|email||2 4`,
+		`broken_reference|teamId|2 not found in teams._id|2 4`,
+		`type_mismatch|age|expected long, got string|2`,
+		`unknown_field|nickname|string|3`,
+		`type_mismatch|profile.city|expected string, got int|4`,
+		`type_mismatch|tags[]|expected string, got int|3`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var text, data bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "members: scanned 4 documents, 6 problems") || !strings.Contains(text.String(), "50.0%") {
+		t.Errorf("unexpected text report\n%s", text.String())
+	}
+	if err := report.WriteJSON(&data); err != nil {
+		t.Fatal(err)
+	}
+	var decoded AuditReport
+	if err := json.Unmarshal(data.Bytes(), &decoded); err != nil || len(decoded.Problems) != 6 {
+		t.Errorf("unexpected json report %s: %v", data.String(), err)
+	}
+
+	// documents are sampled at random, up to the collection size
+	for sampleSize, scanned := range map[int64]int64{2: 2, 10: 4} {
+		sampled, err := db.Audit(ctx, "members", &Member{}, sampleSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sampled.Scanned != scanned {
+			t.Errorf("expected %d scanned documents, got %+v", scanned, sampled)
+		}
+	}
+	if _, err := members.Aggregate(ctx, bson.A{bson.M{"$sample": bson.M{"size": -1}}}); err == nil {
+		t.Error("expected invalid $sample size error")
+	}
+}
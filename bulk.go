@@ -0,0 +1,532 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultBulkBatchSize number of operations sent by one BulkWrite of Bulk.Exec
+const defaultBulkBatchSize = 1000
+
+// Bulk bulk write builder of a model. Unlike Query it is not immutable, operations are appended to it,
+// so it must not be shared by goroutines. Hooks, defaults and validation run by Exec, query scopes are added
+// to filters, and the written documents are recorded by model history like BulkWrite
+type Bulk struct {
+	model      *Model
+	operations []bulkOperation
+	unordered  bool
+	batchSize  int
+}
+
+// bulkOperation prepare the write model of an operation, and the primary key of an inserted document
+type bulkOperation func(ctx context.Context) (mongo.WriteModel, interface{}, error)
+
+// BulkResult counts of the operations written by Bulk.Exec
+type BulkResult struct {
+	InsertedCount int64
+	MatchedCount  int64
+	ModifiedCount int64
+	DeletedCount  int64
+	UpsertedCount int64
+	// InsertedIDs primary keys of inserted documents by operation index
+	InsertedIDs map[int]interface{}
+	// UpsertedIDs _id of upserted documents by operation index
+	UpsertedIDs map[int]interface{}
+}
+
+// BulkError error of an operation of Bulk.Exec, Index is the index of the operation in the order it was added
+type BulkError struct {
+	Index int
+	Err   error
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("goose: bulk operation %d: %v", e.Index, e.Err)
+}
+
+// Unwrap return the error of the operation, such as a DuplicateKeyError or a ValidationError
+func (e *BulkError) Unwrap() error {
+	return e.Err
+}
+
+// BulkErrors errors of the failed operations of Bulk.Exec ordered by index, errors.Is and errors.As match any of them
+type BulkErrors []*BulkError
+
+func (e BulkErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("goose: %d bulk operations failed, first %v", len(e), e[0])
+}
+
+// Unwrap return the error of each operation
+func (e BulkErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Bulk start a bulk write of the model, operations run in order and stop at the first error unless Unordered is used
+func (model *Model) Bulk() *Bulk {
+	return &Bulk{model: model, batchSize: defaultBulkBatchSize}
+}
+
+// Ordered run operations in order and stop at the first error, it is the default
+func (b *Bulk) Ordered() *Bulk {
+	b.unordered = false
+	return b
+}
+
+// Unordered run all operations whatever errors, the server may run them in any order
+func (b *Bulk) Unordered() *Bulk {
+	b.unordered = true
+	return b
+}
+
+// BatchSize split operations into BulkWrite calls of at most size operations, default is 1000
+func (b *Bulk) BatchSize(size int) *Bulk {
+	if size <= 0 {
+		size = defaultBulkBatchSize
+	}
+	b.batchSize = size
+	return b
+}
+
+// Len number of operations added
+func (b *Bulk) Len() int {
+	return len(b.operations)
+}
+
+// Insert insert v like model.InsertOne, defaults, BeforeInsert hooks, sequences, the primary key and validation are applied to v
+func (b *Bulk) Insert(v interface{}) *Bulk {
+	b.operations = append(b.operations, func(ctx context.Context) (mongo.WriteModel, interface{}, error) {
+		data, err := b.model.prepareInsert(ctx, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		doc, err := toDocument(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the driver does not return the _id it generates for a bulk insert
+		doc = withID(doc)
+		id, _ := lookupValue(doc, "_id")
+		if primaryValue := b.model.primaryFieldValue(v); primaryValue.IsValid() {
+			id = primaryValue.Interface()
+		}
+		return mongo.NewInsertOneModel().SetDocument(doc), id, nil
+	})
+	return b
+}
+
+// UpdateOne update the first document matched by filter with an update document, BeforeUpdate hooks are run
+func (b *Bulk) UpdateOne(filter interface{}, updates interface{}) *Bulk {
+	b.operations = append(b.operations, func(ctx context.Context) (mongo.WriteModel, interface{}, error) {
+		filter, updates, err := b.model.prepareUpdate(ctx, BeforeUpdate, filter, updates)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(updates), nil, nil
+	})
+	return b
+}
+
+// UpdateMany update the documents matched by filter with an update document, BeforeUpdate hooks are run
+func (b *Bulk) UpdateMany(filter interface{}, updates interface{}) *Bulk {
+	b.operations = append(b.operations, func(ctx context.Context) (mongo.WriteModel, interface{}, error) {
+		filter, updates, err := b.model.prepareUpdate(ctx, BeforeUpdate, filter, updates)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mongo.NewUpdateManyModel().SetFilter(filter).SetUpdate(updates), nil, nil
+	})
+	return b
+}
+
+// Upsert update the first document matched by filter, or insert a document if none matched.
+// BeforeUpdate hooks are run, fields set by BeforeInsert hooks and defaults which are not updated are added to $setOnInsert
+func (b *Bulk) Upsert(filter interface{}, updates interface{}) *Bulk {
+	b.operations = append(b.operations, func(ctx context.Context) (mongo.WriteModel, interface{}, error) {
+		filter, updates, err := b.model.prepareUpsert(ctx, filter, updates)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(updates).SetUpsert(true), nil, nil
+	})
+	return b
+}
+
+// Replace replace the first document matched by filter with v, defaults, BeforeUpdate hooks and validation are applied to v
+func (b *Bulk) Replace(filter interface{}, v interface{}) *Bulk {
+	b.operations = append(b.operations, b.replace(filter, v, false))
+	return b
+}
+
+// Save replace the document with the primary key of v, or insert v if none matched. The fields of v are written
+// by $set, fields which are zero in v and set on insert by BeforeInsert hooks or the ID generator, such as createdAt,
+// are written by $setOnInsert. Zero sequence fields keep their stored value and no sequence value is taken for them,
+// so a document inserted by its primary key has them zero. v is inserted like Insert if its primary key is zero
+func (b *Bulk) Save(v interface{}) *Bulk {
+	id := b.model.primaryFieldValue(v)
+	if !id.IsValid() || id.IsZero() {
+		return b.Insert(v)
+	}
+	b.operations = append(b.operations, b.replace(bson.M{b.model.primaryKey: id.Interface()}, v, true))
+	return b
+}
+
+func (b *Bulk) replace(filter interface{}, v interface{}, upsert bool) bulkOperation {
+	return func(ctx context.Context) (mongo.WriteModel, interface{}, error) {
+		if upsert {
+			filter, updates, err := b.model.prepareSaveUpsert(ctx, filter, v)
+			if err != nil {
+				return nil, nil, err
+			}
+			return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(updates).SetUpsert(true), nil, nil
+		}
+		filter, replacement, err := b.model.prepareReplace(ctx, filter, v)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(replacement), nil, nil
+	}
+}
+
+// Delete delete the first document matched by filter, BeforeDelete hooks are run
+func (b *Bulk) Delete(filter interface{}) *Bulk {
+	b.operations = append(b.operations, func(ctx context.Context) (mongo.WriteModel, interface{}, error) {
+		filter, err := b.model.prepareDelete(ctx, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mongo.NewDeleteOneModel().SetFilter(filter), nil, nil
+	})
+	return b
+}
+
+// DeleteMany delete the documents matched by filter, BeforeDelete hooks are run
+func (b *Bulk) DeleteMany(filter interface{}) *Bulk {
+	b.operations = append(b.operations, func(ctx context.Context) (mongo.WriteModel, interface{}, error) {
+		filter, err := b.model.prepareDelete(ctx, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mongo.NewDeleteManyModel().SetFilter(filter), nil, nil
+	})
+	return b
+}
+
+// Exec prepare and write the operations batch by batch. Operations which fail to prepare, such as a validation error,
+// or to write, such as a duplicate key, are returned as BulkErrors with the result of the other operations.
+// Ordered bulks stop at the first failed operation, the operations before it are written
+func (b *Bulk) Exec(ctx context.Context) (result *BulkResult, err error) {
+	ctx, finish := b.model.startOperation(ctx, "Bulk")
+	defer func() { finish(err) }()
+	result = &BulkResult{InsertedIDs: map[int]interface{}{}, UpsertedIDs: map[int]interface{}{}}
+	var errs BulkErrors
+	var models []mongo.WriteModel
+	var indexes []int
+	insertedIDs := map[int]interface{}{}
+	// flush write the prepared batch, it returns false if an ordered bulk must stop
+	flush := func() (bool, error) {
+		if len(models) == 0 {
+			return true, nil
+		}
+		batchErrs, err := b.write(ctx, models, indexes, insertedIDs, result)
+		if err != nil {
+			return false, err
+		}
+		errs = append(errs, batchErrs...)
+		models, indexes, insertedIDs = nil, nil, map[int]interface{}{}
+		return len(batchErrs) == 0 || b.unordered, nil
+	}
+	for i, operation := range b.operations {
+		writeModel, id, err := operation(ctx)
+		if err != nil {
+			errs = append(errs, &BulkError{Index: i, Err: err})
+			if !b.unordered {
+				break
+			}
+			continue
+		}
+		models = append(models, writeModel)
+		indexes = append(indexes, i)
+		if id != nil {
+			insertedIDs[i] = id
+		}
+		if len(models) == b.batchSize {
+			next, err := flush()
+			if err != nil {
+				return result, err
+			}
+			if !next {
+				break
+			}
+		}
+	}
+	if _, err := flush(); err != nil {
+		return result, err
+	}
+
+	if len(errs) == 0 {
+		return result, nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+	if !b.unordered {
+		// a write error stops the operations after it, including an operation which failed to prepare
+		errs = errs[:1]
+	}
+	return result, errs
+}
+
+// write run a batch of write models, indexes are their operation indexes
+func (b *Bulk) write(ctx context.Context, models []mongo.WriteModel, indexes []int, insertedIDs map[int]interface{}, result *BulkResult) (BulkErrors, error) {
+	bulkResult, err := b.model.bulkWrite(ctx, models, options.BulkWrite().SetOrdered(!b.unordered))
+	var errs BulkErrors
+	failed := map[int]bool{}
+	if err != nil {
+		var bulkWriteException mongo.BulkWriteException
+		if !errors.As(err, &bulkWriteException) || len(bulkWriteException.WriteErrors) == 0 {
+			return nil, b.model.translateError(err)
+		}
+		for _, writeError := range bulkWriteException.WriteErrors {
+			failed[writeError.Index] = true
+			errs = append(errs, &BulkError{
+				Index: indexes[writeError.Index],
+				Err:   b.model.translateError(mongo.WriteException{WriteErrors: mongo.WriteErrors{writeError.WriteError}}),
+			})
+		}
+	}
+	if bulkResult != nil {
+		result.InsertedCount += bulkResult.InsertedCount
+		result.MatchedCount += bulkResult.MatchedCount
+		result.ModifiedCount += bulkResult.ModifiedCount
+		result.DeletedCount += bulkResult.DeletedCount
+		result.UpsertedCount += bulkResult.UpsertedCount
+		for i, id := range bulkResult.UpsertedIDs {
+			result.UpsertedIDs[indexes[i]] = id
+		}
+	}
+	for i, index := range indexes {
+		if failed[i] {
+			if !b.unordered {
+				break
+			}
+			continue
+		}
+		if id, ok := insertedIDs[index]; ok {
+			result.InsertedIDs[index] = id
+		}
+	}
+	return errs, nil
+}
+
+// prepareUpsert prepare an update like prepareUpdate, and add the fields set by BeforeInsert hooks, sequences,
+// the ID generator and defaults to $setOnInsert unless the update writes them. Hooks get a model struct pointer
+// built from the equality conditions of filter as Write.Value
+func (model *Model) prepareUpsert(ctx context.Context, filter interface{}, updates interface{}) (interface{}, interface{}, error) {
+	filter, updates, err := model.prepareUpdate(ctx, BeforeUpdate, filter, updates)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, pinned, err := model.upsertValue(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	upserted, err := toDocument(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, err := model.runHooks(ctx, BeforeInsert, v, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := model.ensureSequences(ctx, v); err != nil {
+		return nil, nil, err
+	}
+	if err := model.ensureID(v); err != nil {
+		return nil, nil, err
+	}
+	inserted, err := toDocument(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	var onInsert bson.D
+	for _, e := range inserted {
+		if value, ok := lookupValue(upserted, e.Key); !ok || !valuesEqual(value, e.Value) {
+			onInsert = append(onInsert, e)
+		}
+	}
+	onInsert = append(onInsert, w.fields()...)
+	// defaults do not override the values pinned by the filter or set by hooks, so the document matches its filter
+	value := model.structValue(v)
+	for _, field := range model.defaults {
+		if _, ok := lookupValue(pinned, field.BsonName); ok || !value.FieldByName(field.StructFieldName).IsZero() {
+			continue
+		}
+		onInsert = append(onInsert, bson.E{Key: field.BsonName, Value: field.DefaultValue})
+	}
+	doc, err := toDocument(updates)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fields bson.D
+	for _, field := range onInsert {
+		updated, err := updatesPath(doc, field.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !updated {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return filter, doc, nil
+	}
+	for i := range doc {
+		if doc[i].Key == "$setOnInsert" {
+			setOnInsert, err := toDocument(doc[i].Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			doc[i].Value = setFields(setOnInsert, fields)
+			return filter, doc, nil
+		}
+	}
+	return filter, append(doc, bson.E{Key: "$setOnInsert", Value: fields}), nil
+}
+
+// upsertValue a model struct pointer holding the equality conditions of filter, the document an upsert inserts,
+// and the equality conditions
+func (model *Model) upsertValue(filter interface{}) (interface{}, bson.D, error) {
+	conditions, err := toDocument(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	pinned := upsertDocument(conditions)
+	data, err := bson.Marshal(pinned)
+	if err != nil {
+		return nil, nil, err
+	}
+	v := reflect.New(model.typ).Interface()
+	if err := bson.Unmarshal(data, v); err != nil {
+		return nil, nil, fmt.Errorf("goose: decode upsert filter of %s: %w", model.collectionName, err)
+	}
+	return v, pinned, nil
+}
+
+// updatesPath check if an operator of an update document writes path, its parent or one of its children
+func updatesPath(update bson.D, path string) (bool, error) {
+	for _, operator := range update {
+		fields, err := toDocument(operator.Value)
+		if err != nil {
+			return false, err
+		}
+		for _, field := range fields {
+			if field.Key == path || strings.HasPrefix(field.Key, path+".") || strings.HasPrefix(path, field.Key+".") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// prepareReplace apply defaults and BeforeUpdate hooks to v, validate it and add query scopes to filter
+func (model *Model) prepareReplace(ctx context.Context, filter interface{}, v interface{}) (interface{}, bson.D, error) {
+	model.applyDefaults(v)
+	w, err := model.runHooks(ctx, BeforeUpdate, v, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateStruct(v); err != nil {
+		return nil, nil, err
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	replacement, err := toDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	replacement = setFields(replacement, w.fields())
+	if filter, err = model.scopeFilter(ctx, filter); err != nil {
+		return nil, nil, err
+	}
+	return filter, replacement, nil
+}
+
+// prepareSaveUpsert prepare the upsert of v like prepareReplace, as an update setting the fields of v. A copy of v
+// is prepared like an insert, the fields it sets which are zero in v are added to $setOnInsert. Sequence values are
+// only taken by inserts with a zero primary key, zero sequence fields are neither set nor set on insert
+func (model *Model) prepareSaveUpsert(ctx context.Context, filter interface{}, v interface{}) (interface{}, interface{}, error) {
+	value := model.structValue(v)
+	if !value.IsValid() {
+		return nil, nil, fmt.Errorf("goose: save needs a model struct pointer, got %T", v)
+	}
+	inserted := reflect.New(value.Type())
+	inserted.Elem().Set(value)
+	filter, replacement, err := model.prepareReplace(ctx, filter, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, err := model.runHooks(ctx, BeforeInsert, inserted.Interface(), filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := model.ensureID(inserted.Interface()); err != nil {
+		return nil, nil, err
+	}
+	insertDoc, err := toDocument(inserted.Interface())
+	if err != nil {
+		return nil, nil, err
+	}
+	insertDoc = setFields(insertDoc, w.fields())
+
+	var onInsert bson.D
+	written := map[string]bool{}
+	// the document usually exists, so sequence values are not taken for it, zero sequence fields keep their stored value
+	zeroSequences := map[string]bool{}
+	for _, seq := range model.sequences {
+		if field := value.FieldByName(seq.StructFieldName); field.IsValid() && field.IsZero() {
+			zeroSequences[seq.BsonName] = true
+		}
+	}
+	for _, e := range insertDoc {
+		field := value.FieldByName(model.structFieldName(e.Key))
+		current, ok := lookupValue(replacement, e.Key)
+		if e.Key == "_id" || !ok || zeroSequences[e.Key] || (field.IsValid() && field.IsZero() && !valuesEqual(current, e.Value)) {
+			onInsert = append(onInsert, e)
+			written[e.Key] = true
+		}
+	}
+	var set bson.D
+	for _, e := range replacement {
+		if !written[e.Key] && e.Key != "_id" {
+			set = append(set, e)
+		}
+	}
+	updates := bson.D{{Key: "$set", Value: set}}
+	if len(onInsert) > 0 {
+		updates = append(updates, bson.E{Key: "$setOnInsert", Value: onInsert})
+	}
+	return filter, updates, nil
+}
+
+// prepareDelete run the BeforeDelete hooks and add query scopes to filter
+func (model *Model) prepareDelete(ctx context.Context, filter interface{}) (interface{}, error) {
+	if _, err := model.runHooks(ctx, BeforeDelete, nil, filter); err != nil {
+		return nil, err
+	}
+	return model.scopeFilter(ctx, filter)
+}
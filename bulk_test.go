@@ -0,0 +1,197 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBulk(t *testing.T) {
+	type Product struct {
+		ID        int64     `goose:"primary" bson:"_id"`
+		SKU       string    `goose:"unique" bson:"sku" validate:"required"`
+		Stock     int       `bson:"stock"`
+		Status    string    `goose:"default=draft" bson:"status"`
+		Number    int64     `goose:"autoinc" bson:"number"`
+		CreatedAt time.Time `goose:"createdAt" bson:"createdAt"`
+		UpdatedAt time.Time `goose:"updatedAt" bson:"updatedAt"`
+	}
+	NewMemoryDatabase()
+	products, err := NewModel("bulkProducts", &Product{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var hooked []int64
+	products.AddHook(BeforeInsert, func(ctx context.Context, w *Write) error {
+		hooked = append(hooked, w.Value.(*Product).ID)
+		return nil
+	})
+
+	result, err := products.Bulk().BatchSize(2).
+		Insert(&Product{ID: 1, SKU: "a", Stock: 1}).
+		Insert(&Product{ID: 2, SKU: "b", Stock: 2}).
+		Insert(&Product{ID: 3, SKU: "c", Stock: 3}).
+		UpdateOne(bson.M{"_id": int64(1)}, bson.M{"$inc": bson.M{"stock": 10}}).
+		Upsert(bson.M{"_id": int64(4)}, bson.M{"$set": bson.M{"sku": "d", "stock": 4}}).
+		Delete(bson.M{"_id": int64(3)}).
+		Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InsertedCount != 3 || result.MatchedCount != 1 || result.UpsertedCount != 1 || result.DeletedCount != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result.InsertedIDs[2] != int64(3) || result.UpsertedIDs[4] != int64(4) {
+		t.Errorf("expected ids by operation index, got %v %v", result.InsertedIDs, result.UpsertedIDs)
+	}
+	doc, err := products.FindOneByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product := doc.Value().(*Product); product.Stock != 11 || product.Status != "draft" || product.CreatedAt.IsZero() || product.UpdatedAt.Before(product.CreatedAt) {
+		t.Errorf("expected defaults and timestamps, got %+v", product)
+	}
+	doc, err = products.FindOneByID(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product := doc.Value().(*Product); product.Status != "draft" || product.CreatedAt.IsZero() || product.UpdatedAt.IsZero() || product.Number == 0 {
+		t.Errorf("expected $setOnInsert of defaults, timestamps and sequences, got %+v", product)
+	}
+	// upserts pass the equality conditions of the filter to hooks
+	if len(hooked) != 4 || hooked[3] != 4 {
+		t.Errorf("expected hooks of 3 inserts and the upsert, got %v", hooked)
+	}
+
+	// unordered bulks write every valid operation
+	result, err = products.Bulk().Unordered().BatchSize(2).
+		Insert(&Product{ID: 5}).
+		Insert(&Product{ID: 6, SKU: "a"}).
+		Insert(&Product{ID: 7, SKU: "g"}).
+		Save(&Product{ID: 2, SKU: "b", Stock: 20}).
+		Exec(ctx)
+	var bulkErrs BulkErrors
+	if !errors.As(err, &bulkErrs) || len(bulkErrs) != 2 || bulkErrs[0].Index != 0 || bulkErrs[1].Index != 1 {
+		t.Fatalf("expected errors of operations 0 and 1, got %v", err)
+	}
+	if !errors.Is(bulkErrs[0], ErrValidation) || !errors.Is(bulkErrs[1], ErrDuplicateKey) || !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("unexpected errors %v", bulkErrs)
+	}
+	if result.InsertedCount != 1 || result.MatchedCount != 1 || len(result.InsertedIDs) != 1 || result.InsertedIDs[2] != int64(7) {
+		t.Errorf("unexpected unordered result %+v", result)
+	}
+	if doc, err := products.FindOneByID(ctx, 2); err != nil || doc.Value().(*Product).Stock != 20 {
+		t.Errorf("expected saved product, got %v", err)
+	}
+
+	// saving inserts with the insert side fields, and keeps them of existing documents
+	doc, err = products.FindOneByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := doc.Value().(*Product)
+	if _, err := products.Bulk().
+		Save(&Product{ID: 1, SKU: "a", Stock: 40}).
+		Save(&Product{ID: 11, SKU: "k"}).
+		Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	doc, err = products.FindOneByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product := doc.Value().(*Product); product.Stock != 40 || !product.CreatedAt.Equal(existing.CreatedAt) || product.Number != existing.Number {
+		t.Errorf("expected createdAt and number to be kept, got %+v", product)
+	}
+	doc, err = products.FindOneByID(ctx, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product := doc.Value().(*Product); product.Status != "draft" || product.CreatedAt.IsZero() || product.UpdatedAt.IsZero() || product.Number != 0 {
+		t.Errorf("expected defaults and timestamps of the saved product without a sequence number, got %+v", product)
+	}
+
+	// ordered bulks stop at the first error
+	result, err = products.Bulk().
+		Insert(&Product{ID: 8, SKU: "h"}).
+		Insert(&Product{ID: 9, SKU: "h"}).
+		Insert(&Product{ID: 10}).
+		Exec(ctx)
+	if !errors.As(err, &bulkErrs) || len(bulkErrs) != 1 || bulkErrs[0].Index != 1 || !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected duplicate key error of operation 1, got %v", err)
+	}
+	if result.InsertedCount != 1 {
+		t.Errorf("expected 1 inserted product, got %+v", result)
+	}
+	if n, err := products.Count(ctx, nil); err != nil || n != 6 {
+		t.Errorf("expected 6 products, got %d %v", n, err)
+	}
+
+	// defaults do not override the values pinned by the upsert filter
+	for i := 0; i < 2; i++ {
+		if _, err := products.Bulk().
+			Upsert(bson.M{"_id": int64(12), "status": "published"}, bson.M{"$set": bson.M{"sku": "l"}}).
+			Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := products.Count(ctx, bson.M{"status": "published"}); err != nil || n != 1 {
+		t.Errorf("expected 1 published product, got %d %v", n, err)
+	}
+	if n, err := products.Count(ctx, bson.M{"status": "draft", "_id": int64(12)}); err != nil || n != 0 {
+		t.Errorf("expected the default not to override the filter, got %d %v", n, err)
+	}
+}
+
+func TestBulkHistory(t *testing.T) {
+	type Part struct {
+		ID    int64  `goose:"primary" bson:"_id"`
+		Name  string `bson:"name"`
+		Stock int    `bson:"stock"`
+	}
+	NewMemoryDatabase()
+	parts, err := NewModel("bulkParts", &Part{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parts.EnableHistory(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := parts.Bulk().BatchSize(2).
+		Insert(&Part{ID: 1, Name: "bolt"}).
+		Insert(&Part{ID: 2, Name: "nut"}).
+		Insert(&Part{ID: 3, Name: "gear"}).
+		Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parts.Bulk().
+		UpdateOne(bson.M{"_id": int64(1)}, bson.M{"$inc": bson.M{"stock": 5}}).
+		Upsert(bson.M{"_id": int64(4)}, bson.M{"$set": bson.M{"name": "pin"}}).
+		Save(&Part{ID: 3, Name: "cog"}).
+		Delete(bson.M{"_id": int64(2)}).
+		Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for id, operations := range map[int64][]HistoryOperation{
+		1: {HistoryInsert, HistoryUpdate},
+		2: {HistoryInsert, HistoryDelete},
+		3: {HistoryInsert, HistoryUpdate},
+		4: {HistoryInsert},
+	} {
+		revisions, err := parts.Revisions(ctx, id)
+		if err != nil || len(revisions) != len(operations) {
+			t.Errorf("expected %d revisions of part %d, got %+v %v", len(operations), id, revisions, err)
+			continue
+		}
+		for i, operation := range operations {
+			if revisions[i].Operation != operation {
+				t.Errorf("expected revision %d of part %d to be %s, got %+v", i, id, operation, revisions[i])
+			}
+		}
+	}
+}
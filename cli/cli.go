@@ -0,0 +1,468 @@
+// Package cli implements the goose command.
+//
+// cmd/goose runs it without models and migrations of your app, build your own command to include them:
+//
+//	package main
+//
+//	import (
+//		"github.com/pascallin/goose/cli"
+//		_ "example.com/app/migrations" // goose.RegisterMigration in init
+//		"example.com/app/models"
+//	)
+//
+//	func main() {
+//		cli.Main(cli.Config{Models: map[string]interface{}{"users": &models.User{}}})
+//	}
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pascallin/goose"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const usage = `usage: goose [-url URL] [-database NAME] [-v] COMMAND [ARGS]
+
+connection options are read from MONGODB_* env and the .env file if -url is not set
+
+commands:
+  migrate up [-to VERSION] [-lock-wait DURATION]     apply pending migrations, or up to VERSION
+  migrate down [-steps N | -to VERSION]              roll back the last N migrations, default 1
+  migrate status                                     list migrations
+  indexes sync [-dry-run]                            create and drop indexes to match model tags
+  audit [-sample N] [-json] [COLLECTION...]          check documents against model structs
+  seed PATH...                                       insert fixture files or directories
+  export -c COLLECTION [-filter JSON] [-o FILE] [-format FORMAT]
+                                                     write documents of a model, default format is ndjson
+  import -c COLLECTION [-i FILE] [-format FORMAT] [-upsert] [-unordered] [-batch-size N]
+                                                     insert or upsert documents of a model with validation
+  ping                                               check the connection
+  stats                                              print database and collection stats
+`
+
+// ErrUsage the command line is invalid, usage is printed to Stderr
+var ErrUsage = errors.New("goose: invalid command line")
+
+// Config command config
+type Config struct {
+	// Models model structs by collection name, such as {"users": &User{}}, used by `indexes sync`, `audit`,
+	// `export` and `import`, and by `seed` so fixtures are inserted with model defaults and ids
+	Models map[string]interface{}
+	// Migrations migrations of `migrate`, registered migrations are used if empty
+	Migrations []goose.Migration
+	// Connect connect the database, default is goose.NewMongoDatabase
+	Connect func(options *goose.DatabaseOptions) (*goose.Database, error)
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Main run the command with os.Args and exit 1 on error
+func Main(config Config) {
+	if err := Run(context.Background(), os.Args[1:], config); err != nil {
+		if !errors.Is(err, ErrUsage) {
+			fmt.Fprintln(stderr(config), err)
+		}
+		os.Exit(1)
+	}
+}
+
+// Run run the command with args, args do not include the program name
+func Run(ctx context.Context, args []string, config Config) error {
+	if config.Stdin == nil {
+		config.Stdin = os.Stdin
+	}
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
+	}
+	config.Stderr = stderr(config)
+	if config.Connect == nil {
+		config.Connect = goose.NewMongoDatabase
+	}
+
+	flags := newFlagSet("goose", config)
+	url := flags.String("url", "", "connection URL, default is MONGODB_URL or assembled from MONGODB_* env")
+	database := flags.String("database", "", "database name, default is MONGODB_DATABASE")
+	verbose := flags.Bool("v", false, "log connection details")
+	if err := flags.Parse(args); err != nil {
+		return ErrUsage
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprint(config.Stderr, usage)
+		return ErrUsage
+	}
+
+	options := &goose.DatabaseOptions{
+		URL:          *url,
+		DatabaseName: *database,
+		UsingEnv:     *url == "",
+		Logger:       goose.NewNopLogger(),
+	}
+	if *verbose {
+		options.Logger = nil
+	}
+	db, err := config.Connect(options)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	command := &command{ctx: ctx, db: db, config: config}
+	name, args := flags.Arg(0), flags.Args()[1:]
+	switch name {
+	case "migrate":
+		return command.migrate(args)
+	case "indexes":
+		return command.indexes(args)
+	case "audit":
+		return command.audit(args)
+	case "seed":
+		return command.seed(args)
+	case "export":
+		return command.export(args)
+	case "import":
+		return command.importDocuments(args)
+	case "ping":
+		return command.ping()
+	case "stats":
+		return command.stats()
+	default:
+		return command.usageError("unknown command %q", name)
+	}
+}
+
+func stderr(config Config) io.Writer {
+	if config.Stderr == nil {
+		return os.Stderr
+	}
+	return config.Stderr
+}
+
+func newFlagSet(name string, config Config) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(config.Stderr)
+	flags.Usage = func() { fmt.Fprint(config.Stderr, usage) }
+	return flags
+}
+
+type command struct {
+	ctx    context.Context
+	db     *goose.Database
+	config Config
+}
+
+func (c *command) usageError(format string, args ...interface{}) error {
+	fmt.Fprintf(c.config.Stderr, "goose: "+format+"\n\n", args...)
+	fmt.Fprint(c.config.Stderr, usage)
+	return ErrUsage
+}
+
+func (c *command) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.config.Stdout, format, args...)
+}
+
+// mongoDB the mongo database, commands using the driver directly do not work with the memory database
+func (c *command) mongoDB(name string) (*mongo.Database, error) {
+	if c.db.DB == nil {
+		return nil, fmt.Errorf("goose: %s needs a mongo database", name)
+	}
+	return c.db.DB, nil
+}
+
+func (c *command) migrate(args []string) error {
+	if len(args) == 0 {
+		return c.usageError("migrate needs up, down or status")
+	}
+	flags := newFlagSet("migrate "+args[0], c.config)
+	to := flags.Int64("to", -1, "target version")
+	steps := flags.Int("steps", 1, "number of migrations to roll back")
+	lockWait := flags.Duration("lock-wait", 0, "time to wait for the migration lock held by others")
+	if err := flags.Parse(args[1:]); err != nil {
+		return ErrUsage
+	}
+	migrator, err := goose.NewMigrator(c.db, c.config.Migrations...)
+	if err != nil {
+		return err
+	}
+	migrator.LockWait = *lockWait
+
+	switch args[0] {
+	case "up":
+		if *to >= 0 {
+			err = migrator.To(c.ctx, *to)
+		} else {
+			err = migrator.Up(c.ctx)
+		}
+	case "down":
+		if *to >= 0 {
+			err = migrator.To(c.ctx, *to)
+		} else {
+			err = migrator.Down(c.ctx, *steps)
+		}
+	case "status":
+	default:
+		return c.usageError("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	return c.migrationStatus(migrator)
+}
+
+func (c *command) migrationStatus(migrator *goose.Migrator) error {
+	statuses, err := migrator.Status(c.ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.config.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED AT\tDESCRIPTION")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.Applied {
+			appliedAt = status.AppliedAt.Local().Format(time.RFC3339)
+		}
+		description := status.Description
+		if status.Unknown {
+			description += " (not registered)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, appliedAt, description)
+	}
+	return w.Flush()
+}
+
+func (c *command) indexes(args []string) error {
+	if len(args) == 0 || args[0] != "sync" {
+		return c.usageError("indexes needs sync")
+	}
+	flags := newFlagSet("indexes sync", c.config)
+	dryRun := flags.Bool("dry-run", false, "print changes without applying them")
+	if err := flags.Parse(args[1:]); err != nil {
+		return ErrUsage
+	}
+	names, err := c.modelNames()
+	if err != nil {
+		return err
+	}
+	changed := 0
+	for _, name := range names {
+		changes, err := c.db.SyncIndexes(c.ctx, name, c.config.Models[name], *dryRun)
+		for _, change := range changes {
+			c.printf("%s\n", change)
+		}
+		changed += len(changes)
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case changed == 0:
+		c.printf("indexes are in sync\n")
+	case *dryRun:
+		c.printf("dry run, %d changes not applied\n", changed)
+	}
+	return nil
+}
+
+// modelNames collection names of Config.Models in order
+func (c *command) modelNames() ([]string, error) {
+	if len(c.config.Models) == 0 {
+		return nil, errors.New("goose: no model, set Config.Models in your own command, see package cli")
+	}
+	names := make([]string, 0, len(c.config.Models))
+	for name := range c.config.Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *command) audit(args []string) error {
+	flags := newFlagSet("audit", c.config)
+	sample := flags.Int64("sample", 0, "number of documents to scan in each collection, 0 for all")
+	asJSON := flags.Bool("json", false, "print reports as JSON")
+	if err := flags.Parse(args); err != nil {
+		return ErrUsage
+	}
+	names := flags.Args()
+	if len(names) == 0 {
+		var err error
+		if names, err = c.modelNames(); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		value, ok := c.config.Models[name]
+		if !ok {
+			return fmt.Errorf("goose: no model of collection %s", name)
+		}
+		report, err := c.db.Audit(c.ctx, name, value, *sample)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			err = report.WriteJSON(c.config.Stdout)
+		} else {
+			err = report.WriteText(c.config.Stdout)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *command) seed(paths []string) error {
+	if len(paths) == 0 {
+		return c.usageError("seed needs fixture paths")
+	}
+	for name, value := range c.config.Models {
+		if _, err := goose.NewModel(name, value); err != nil {
+			return err
+		}
+	}
+	fixtures, err := goose.LoadFixtures(c.ctx, paths...)
+	if err != nil {
+		return err
+	}
+	c.printf("seeded %d documents\n", fixtures.Len())
+	return nil
+}
+
+func (c *command) export(args []string) error {
+	flags := newFlagSet("export", c.config)
+	collection := flags.String("c", "", "collection")
+	filter := flags.String("filter", "{}", "Extended JSON filter")
+	output := flags.String("o", "", "output file, default is stdout")
+	format := flags.String("format", string(goose.FormatNDJSON), "canonical, relaxed, ndjson or csv")
+	if err := flags.Parse(args); err != nil {
+		return ErrUsage
+	}
+	if *collection == "" {
+		return c.usageError("export needs -c COLLECTION")
+	}
+	model, err := c.model(*collection)
+	if err != nil {
+		return err
+	}
+	var query bson.D
+	if err := bson.UnmarshalExtJSON([]byte(*filter), false, &query); err != nil {
+		return fmt.Errorf("goose: invalid filter: %w", err)
+	}
+	w := c.config.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	if _, err := model.Export(c.ctx, buffered, goose.Format(*format), query); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+func (c *command) importDocuments(args []string) error {
+	flags := newFlagSet("import", c.config)
+	collection := flags.String("c", "", "collection")
+	input := flags.String("i", "", "input file, default is stdin")
+	format := flags.String("format", string(goose.FormatNDJSON), "canonical, relaxed, ndjson or csv")
+	upsert := flags.Bool("upsert", false, "replace documents by primary key instead of inserting them")
+	batchSize := flags.Int("batch-size", 1000, "documents written by one bulk write")
+	unordered := flags.Bool("unordered", false, "import every valid document whatever errors")
+	if err := flags.Parse(args); err != nil {
+		return ErrUsage
+	}
+	if *collection == "" {
+		return c.usageError("import needs -c COLLECTION")
+	}
+	model, err := c.model(*collection)
+	if err != nil {
+		return err
+	}
+	r := c.config.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	result, err := model.Import(c.ctx, r, goose.Format(*format), &goose.ImportOptions{
+		Upsert:    *upsert,
+		BatchSize: *batchSize,
+		Unordered: *unordered,
+	})
+	if result != nil {
+		c.printf("imported %d documents\n", result.InsertedCount+result.UpsertedCount+result.MatchedCount)
+	}
+	return err
+}
+
+// model the model of a collection of Config.Models
+func (c *command) model(name string) (*goose.Model, error) {
+	value, ok := c.config.Models[name]
+	if !ok {
+		return nil, fmt.Errorf("goose: no model of collection %s, set Config.Models in your own command, see package cli", name)
+	}
+	return goose.NewModel(name, value)
+}
+
+func (c *command) ping() error {
+	if c.db.Client == nil {
+		c.printf("ok\n")
+		return nil
+	}
+	start := time.Now()
+	if err := c.db.Client.Ping(c.ctx, readpref.Primary()); err != nil {
+		return err
+	}
+	c.printf("ok database=%s latency=%s\n", c.db.DB.Name(), time.Since(start).Round(time.Microsecond))
+	return nil
+}
+
+func (c *command) stats() error {
+	db, err := c.mongoDB("stats")
+	if err != nil {
+		return err
+	}
+	var stats bson.M
+	if err := db.RunCommand(c.ctx, bson.D{{Key: "dbStats", Value: 1}}).Decode(&stats); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.config.Stdout, 0, 4, 2, ' ', 0)
+	for _, key := range []string{"db", "collections", "objects", "dataSize", "storageSize", "indexes", "indexSize"} {
+		fmt.Fprintf(w, "%s\t%v\n", key, stats[key])
+	}
+	names, err := db.ListCollectionNames(c.ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "\nCOLLECTION\tDOCUMENTS")
+	for _, name := range names {
+		count, err := db.Collection(name).EstimatedDocumentCount(c.ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, strconv.FormatInt(count, 10))
+	}
+	return w.Flush()
+}
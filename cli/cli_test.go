@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pascallin/goose"
+	"github.com/pascallin/goose/goosetest"
+)
+
+type user struct {
+	ID    int64  `goose:"primary,autoinc" bson:"_id"`
+	Name  string `goose:"index" bson:"name"`
+	Email string `goose:"unique" bson:"email"`
+}
+
+func memoryConfig(stdout *bytes.Buffer) Config {
+	db := goose.NewMemoryDatabase()
+	return Config{
+		Models: map[string]interface{}{"users": &user{}},
+		Migrations: []goose.Migration{
+			{Version: 1, Description: "first", Up: func(ctx context.Context, db *goose.Database) error { return nil },
+				Down: func(ctx context.Context, db *goose.Database) error { return nil }},
+			{Version: 2, Description: "second", Up: func(ctx context.Context, db *goose.Database) error { return nil },
+				Down: func(ctx context.Context, db *goose.Database) error { return nil }},
+		},
+		Connect: func(*goose.DatabaseOptions) (*goose.Database, error) { return db, nil },
+		Stdout:  stdout,
+		Stderr:  ioutil.Discard,
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	var stdout bytes.Buffer
+	config := memoryConfig(&stdout)
+	run := func(args ...string) string {
+		t.Helper()
+		stdout.Reset()
+		if err := Run(ctx, args, config); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return stdout.String()
+	}
+
+	pending := func(out string) []string {
+		var versions []string
+		for _, line := range strings.Split(out, "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 && fields[1] == "pending" {
+				versions = append(versions, fields[0])
+			}
+		}
+		return versions
+	}
+	if out := run("migrate", "up", "-to", "1"); !reflect.DeepEqual(pending(out), []string{"2"}) {
+		t.Errorf("unexpected status\n%s", out)
+	}
+	if out := run("migrate", "up"); len(pending(out)) != 0 {
+		t.Errorf("unexpected status\n%s", out)
+	}
+	if out := run("migrate", "down", "-to", "0"); !reflect.DeepEqual(pending(out), []string{"1", "2"}) {
+		t.Errorf("unexpected status\n%s", out)
+	}
+
+	if out := run("indexes", "sync", "-dry-run"); !strings.Contains(out, "create users.email_1") || !strings.Contains(out, "2 changes not applied") {
+		t.Errorf("unexpected dry run\n%s", out)
+	}
+	run("indexes", "sync")
+	if out := run("indexes", "sync"); out != "indexes are in sync\n" {
+		t.Errorf("unexpected sync\n%s", out)
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "users.yaml"), []byte("users:\n  alice:\n    name: Alice\n    email: alice@example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := run("seed", dir); out != "seeded 1 documents\n" {
+		t.Errorf("unexpected seed\n%s", out)
+	}
+
+	if out := run("audit"); !strings.Contains(out, "users: scanned 1 documents, 0 problems") {
+		t.Errorf("unexpected audit\n%s", out)
+	}
+	if out := run("audit", "-json", "users"); !strings.Contains(out, `"scanned": 1`) {
+		t.Errorf("unexpected audit\n%s", out)
+	}
+
+	if out := run("export", "-c", "users", "-format", "csv"); out != "_id,name,email\n1,Alice,alice@example.com\n" {
+		t.Errorf("unexpected export\n%s", out)
+	}
+	config.Stdin = strings.NewReader(`{"name":"Bob","email":"bob@example.com"}` + "\n" + `{"name":"Eve","email":"alice@example.com"}` + "\n")
+	stdout.Reset()
+	var bulkErrs goose.BulkErrors
+	if err := Run(ctx, []string{"import", "-c", "users", "-unordered"}, config); !errors.As(err, &bulkErrs) || len(bulkErrs) != 1 || bulkErrs[0].Index != 1 {
+		t.Errorf("expected duplicate email error of document 1, got %v", err)
+	}
+	if out := stdout.String(); out != "imported 1 documents\n" {
+		t.Errorf("unexpected import\n%s", out)
+	}
+	if out := run("export", "-c", "users", "-filter", `{"name":"Bob"}`); out != `{"_id":2,"name":"Bob","email":"bob@example.com"}`+"\n" {
+		t.Errorf("unexpected export %q", out)
+	}
+	if err := Run(ctx, []string{"export", "-c", "items"}, config); err == nil {
+		t.Error("expected error of collection without model")
+	}
+
+	if err := Run(ctx, []string{"unknown"}, config); !errors.Is(err, ErrUsage) {
+		t.Errorf("expected usage error, got %v", err)
+	}
+	if err := Run(ctx, []string{"stats"}, config); err == nil {
+		t.Error("expected stats error on memory database")
+	}
+}
+
+func TestRunMongo(t *testing.T) {
+	server := goosetest.StartServer(t, nil)
+	db := server.NewDatabase(t)
+	ctx := context.Background()
+	var stdout bytes.Buffer
+	type item struct {
+		ID   int64  `bson:"_id"`
+		Name string `bson:"name"`
+	}
+	config := Config{
+		Models: map[string]interface{}{"items": &item{}},
+		Stdin:  strings.NewReader(`{"_id":1,"name":"a"}` + "\n" + `{"_id":2,"name":"b"}` + "\n"),
+		Stdout: &stdout,
+		Stderr: ioutil.Discard,
+	}
+	connection := []string{"-url", server.URL(), "-database", db.DB.Name()}
+	for _, args := range [][]string{{"ping"}, {"import", "-c", "items"}, {"stats"}} {
+		if err := Run(ctx, append(connection, args...), config); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+	stdout.Reset()
+	if err := Run(ctx, append(connection, "export", "-c", "items", "-filter", `{"name":"b"}`), config); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != `{"_id":2,"name":"b"}`+"\n" {
+		t.Errorf("unexpected export %q", got)
+	}
+}
@@ -0,0 +1,9 @@
+// Command goose migrates, seeds and inspects mongo databases, see package cli for building
+// a command with the models and migrations of your app.
+package main
+
+import "github.com/pascallin/goose/cli"
+
+func main() {
+	cli.Main(cli.Config{})
+}
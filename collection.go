@@ -1,7 +1,207 @@
 package goose
 
-import "go.mongodb.org/mongo-driver/mongo"
+import (
+	"context"
+	"errors"
 
-func getCollection(collectionName string) *mongo.Collection {
-	return DB.Collection(collectionName)
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// backend a database storing collections, it is a mongo database or the in-memory database
+type backend interface {
+	Name() string
+	Collection(name string) collection
+}
+
+// collection the collection operations used by models, errors are returned in mongo driver types
+// so model.translateError works for every backend
+type collection interface {
+	Name() string
+	Database() backend
+	InsertOne(ctx context.Context, document interface{}) (*mongo.InsertOneResult, error)
+	// FindOne return mongo.ErrNoDocuments if no document matched
+	FindOne(ctx context.Context, filter interface{}) (bson.Raw, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (cursor, error)
+	Aggregate(ctx context.Context, pipeline interface{}) (cursor, error)
+	CountDocuments(ctx context.Context, filter interface{}) (int64, error)
+	// EstimatedDocumentCount the number of documents from the collection metadata
+	EstimatedDocumentCount(ctx context.Context) (int64, error)
+	// Distinct the distinct values of field in documents matched by filter, elements of array fields are distinct values
+	Distinct(ctx context.Context, field string, filter interface{}) ([]interface{}, error)
+	// FindOneAndUpdate return mongo.ErrNoDocuments if no document matched
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) (bson.Raw, error)
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter interface{}) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}) (*mongo.DeleteResult, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+	CreateIndex(ctx context.Context, index mongo.IndexModel) (string, error)
+	ListIndexes(ctx context.Context) ([]Index, error)
+	DropIndex(ctx context.Context, name string) error
+	// SetValidator set the validator, validationLevel and validationAction, create the collection if it does not exist
+	SetValidator(ctx context.Context, validator bson.D, level string, action string) error
+}
+
+// cursor iterate documents returned by Find and Aggregate
+type cursor interface {
+	Next(ctx context.Context) bool
+	// Raw the current document, it is only valid until the next call of Next
+	Raw() bson.Raw
+	Decode(v interface{}) error
+	All(ctx context.Context, results interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// getCollection get collection from the database connected last, or from DB
+func getCollection(collectionName string) collection {
+	if defaultDatabase != nil && defaultDatabase.backend != nil {
+		return defaultDatabase.backend.Collection(collectionName)
+	}
+	return mongoCollection{DB.Collection(collectionName)}
+}
+
+// mongoBackend backend of a mongo database
+type mongoBackend struct {
+	db *mongo.Database
+}
+
+func (b mongoBackend) Name() string {
+	return b.db.Name()
+}
+
+func (b mongoBackend) Collection(name string) collection {
+	return mongoCollection{b.db.Collection(name)}
+}
+
+// mongoCollection collection of a mongo database
+type mongoCollection struct {
+	coll *mongo.Collection
+}
+
+func (c mongoCollection) Name() string {
+	return c.coll.Name()
+}
+
+func (c mongoCollection) Database() backend {
+	return mongoBackend{c.coll.Database()}
+}
+
+func (c mongoCollection) InsertOne(ctx context.Context, document interface{}) (*mongo.InsertOneResult, error) {
+	return c.coll.InsertOne(ctx, document)
+}
+
+func (c mongoCollection) FindOne(ctx context.Context, filter interface{}) (bson.Raw, error) {
+	return c.coll.FindOne(ctx, filter).DecodeBytes()
+}
+
+func (c mongoCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (cursor, error) {
+	cur, err := c.coll.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return mongoCursor{cur}, nil
+}
+
+func (c mongoCollection) Aggregate(ctx context.Context, pipeline interface{}) (cursor, error) {
+	cur, err := c.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return mongoCursor{cur}, nil
+}
+
+func (c mongoCollection) CountDocuments(ctx context.Context, filter interface{}) (int64, error) {
+	return c.coll.CountDocuments(ctx, filter)
+}
+
+func (c mongoCollection) EstimatedDocumentCount(ctx context.Context) (int64, error) {
+	return c.coll.EstimatedDocumentCount(ctx)
+}
+
+func (c mongoCollection) Distinct(ctx context.Context, field string, filter interface{}) ([]interface{}, error) {
+	return c.coll.Distinct(ctx, field, filter)
+}
+
+func (c mongoCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) (bson.Raw, error) {
+	return c.coll.FindOneAndUpdate(ctx, filter, update, opts...).DecodeBytes()
+}
+
+func (c mongoCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (c mongoCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateMany(ctx, filter, update, opts...)
+}
+
+func (c mongoCollection) DeleteOne(ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteOne(ctx, filter)
+}
+
+func (c mongoCollection) DeleteMany(ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteMany(ctx, filter)
+}
+
+func (c mongoCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	return c.coll.BulkWrite(ctx, models, opts...)
+}
+
+func (c mongoCollection) CreateIndex(ctx context.Context, index mongo.IndexModel) (string, error) {
+	return c.coll.Indexes().CreateOne(ctx, index)
+}
+
+// ListIndexes list indexes of a mongo collection
+func (c mongoCollection) ListIndexes(ctx context.Context) ([]Index, error) {
+	cur, err := c.coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var indexes []Index
+	for cur.Next(ctx) {
+		var spec struct {
+			Name   string `bson:"name"`
+			Key    bson.D `bson:"key"`
+			Unique bool   `bson:"unique"`
+		}
+		if err := cur.Decode(&spec); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, Index{Name: spec.Name, Keys: spec.Key, Unique: spec.Unique})
+	}
+	return indexes, cur.Err()
+}
+
+// DropIndex drop an index of a mongo collection by name
+func (c mongoCollection) DropIndex(ctx context.Context, name string) error {
+	_, err := c.coll.Indexes().DropOne(ctx, name)
+	return err
+}
+
+// SetValidator set the validator of a mongo collection by collMod, or create the collection with it
+func (c mongoCollection) SetValidator(ctx context.Context, validator bson.D, level string, action string) error {
+	db := c.coll.Database()
+	validation := bson.D{
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: level},
+		{Key: "validationAction", Value: action},
+	}
+	err := db.RunCommand(ctx, append(bson.D{{Key: "collMod", Value: c.coll.Name()}}, validation...)).Err()
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Code == namespaceNotFoundCode {
+		return db.RunCommand(ctx, append(bson.D{{Key: "create", Value: c.coll.Name()}}, validation...)).Err()
+	}
+	return err
+}
+
+// mongoCursor cursor of a mongo query
+type mongoCursor struct {
+	*mongo.Cursor
+}
+
+func (c mongoCursor) Raw() bson.Raw {
+	return c.Current
 }
@@ -2,17 +2,24 @@ package goose
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
-	log "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 // Database is a base struct for goose mongo
@@ -20,85 +27,360 @@ type Database struct {
 	DB      *mongo.Database
 	Client  *mongo.Client
 	Context context.Context
+	logger  Logger
+	metrics *Metrics
+	tracer  Tracer
+	backend backend
 }
 
-type databaseEnvSetting struct {
-	username string
-	password string
-	endpoint string
-	port     string
-}
+// connection schemes
+const (
+	SchemeMongoDB    = "mongodb"
+	SchemeMongoDBSRV = "mongodb+srv"
+)
+
+const redacted = "xxxxx"
+
+const defaultConnectTimeout = 5
 
 // DatabaseOptions init database options
 type DatabaseOptions struct {
-	ConnectTimeout time.Duration `validate:"isdefault=5"`               // Timeout operations after N seconds
-	URL            string        `validate:"required_without=UsingEnv"` // connection URL string
-	UsingEnv       bool          `validate:"required_without=URL"`      // using env
-	DatabaseName   string        `validate:"required_without=UsingEnv"` // databaseName
-	databaseEnvSetting
+	ConnectTimeout time.Duration // Timeout connecting after N seconds, default is 5
+	URL            string        `validate:"required_without_all=UsingEnv Hosts"` // connection URL string
+	UsingEnv       bool          `validate:"required_without_all=URL Hosts"`      // using MONGODB_* env
+	DatabaseName   string        `validate:"required_without=UsingEnv"`           // databaseName
+
+	// connection string parts, used if URL is empty
+	Scheme        string   // mongodb or mongodb+srv, default is mongodb
+	Hosts         []string // host:port list, or the DNS name for mongodb+srv
+	Username      string
+	Password      string
+	AuthSource    string
+	AuthMechanism string // SCRAM-SHA-1, SCRAM-SHA-256, MONGODB-X509, MONGODB-AWS, PLAIN or GSSAPI
+
+	// client options, override the options in connection URL
+	AppName         string
+	ReplicaSet      string
+	MaxPoolSize     uint64
+	MinPoolSize     uint64
+	MaxConnIdleTime time.Duration
+	ReadPreference  string // primary, primaryPreferred, secondary, secondaryPreferred or nearest
+	ReadConcern     string // local, available, majority, linearizable or snapshot
+	WriteConcern    *WriteConcern
+	RetryWrites     *bool
+	RetryReads      *bool
+	TLS             *TLSOptions
+	Compressors     []string // snappy, zlib or zstd
+
+	Logger        Logger         // logger of the database, default is the global logger set by SetLogger
+	QueryObserver *QueryObserver `string:"-"` // observe commands for query logging, slow query detection and tracing
+	Metrics       *Metrics       `string:"-"` // record model operation metrics and connection pool gauges
+	Tracer        Tracer         // start spans for model operations
+}
+
+// WriteConcern write concern options
+type WriteConcern struct {
+	W        interface{} // number of nodes, "majority" or a tag set name
+	Journal  *bool
+	WTimeout time.Duration
+}
+
+// TLSOptions TLS options, TLS is enabled if DatabaseOptions.TLS is not nil
+type TLSOptions struct {
+	CAFile             string // PEM file of certificate authorities
+	CertificateKeyFile string // PEM file with client certificate and private key
+	Insecure           bool   // skip server certificate verification
 }
 
 // DB a mongo database instance after init
 var DB *mongo.Database
 
-func getMongoConnURLFromEnv(ops *DatabaseOptions) (string, error) {
-	username := os.Getenv("MONGODB_USERNAME")
-	password := os.Getenv("MONGODB_PASSWORD")
+// defaultDatabase the database connected last, models created after it use its instrumentation
+var defaultDatabase *Database
+
+// loadEnv fill empty options from MONGODB_* env
+func (ops *DatabaseOptions) loadEnv() error {
+	setIfEmpty := func(field *string, env string) {
+		if *field == "" {
+			*field = os.Getenv(env)
+		}
+	}
+	setIfEmpty(&ops.URL, "MONGODB_URL")
+	setIfEmpty(&ops.DatabaseName, "MONGODB_DATABASE")
+	setIfEmpty(&ops.Scheme, "MONGODB_SCHEME")
+	setIfEmpty(&ops.Username, "MONGODB_USERNAME")
+	setIfEmpty(&ops.Password, "MONGODB_PASSWORD")
+	setIfEmpty(&ops.AuthSource, "MONGODB_AUTH_SOURCE")
+	setIfEmpty(&ops.ReplicaSet, "MONGODB_REPLICA_SET")
+	setIfEmpty(&ops.AppName, "MONGODB_APP_NAME")
+	if ops.TLS == nil && os.Getenv("MONGODB_TLS") == "true" {
+		ops.TLS = &TLSOptions{}
+	}
+	if ops.URL != "" || len(ops.Hosts) > 0 {
+		return nil
+	}
+
 	clusterEndpoint := os.Getenv("MONGODB_ENDPOINT")
 	port := os.Getenv("MONGODB_PORT")
-	if username == "" || password == "" || clusterEndpoint == "" || port == "" {
-		return "", errors.New("missing env")
-	}
-	log.WithFields(log.Fields{
-		"username":        username,
-		"password":        password,
-		"clusterEndpoint": clusterEndpoint,
-	})
-	mongoConnStringTemplate := "mongodb://%s:%s@%s:%s"
-	connectionURI := fmt.Sprintf(mongoConnStringTemplate, username, password, clusterEndpoint, port)
-	return connectionURI, nil
+	if clusterEndpoint == "" {
+		return errors.New("missing env MONGODB_ENDPOINT or MONGODB_URL")
+	}
+	for _, host := range strings.Split(clusterEndpoint, ",") {
+		if port != "" && ops.Scheme != SchemeMongoDBSRV && !strings.Contains(host, ":") {
+			host = host + ":" + port
+		}
+		ops.Hosts = append(ops.Hosts, host)
+	}
+	return nil
+}
+
+// connectionURL return URL option or assemble it from connection string parts with escaped credentials
+func (ops *DatabaseOptions) connectionURL() (string, error) {
+	if ops.URL != "" {
+		return ops.URL, nil
+	}
+	if len(ops.Hosts) == 0 {
+		return "", errors.New("goose: missing connection hosts")
+	}
+	scheme := ops.Scheme
+	if scheme == "" {
+		scheme = SchemeMongoDB
+	}
+	if scheme != SchemeMongoDB && scheme != SchemeMongoDBSRV {
+		return "", fmt.Errorf("goose: unknown connection scheme %q", scheme)
+	}
+	if scheme == SchemeMongoDBSRV && len(ops.Hosts) != 1 {
+		return "", errors.New("goose: mongodb+srv needs exactly one host")
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   strings.Join(ops.Hosts, ","),
+		Path:   "/",
+	}
+	if ops.Username != "" {
+		u.User = url.UserPassword(ops.Username, ops.Password)
+	}
+	query := url.Values{}
+	if ops.AuthSource != "" {
+		query.Set("authSource", ops.AuthSource)
+	}
+	if ops.AuthMechanism != "" {
+		query.Set("authMechanism", ops.AuthMechanism)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// clientOptions build mongo client options
+func (ops *DatabaseOptions) clientOptions() (*options.ClientOptions, error) {
+	connectionURL, err := ops.connectionURL()
+	if err != nil {
+		return nil, err
+	}
+	clientOptions := options.Client().ApplyURI(connectionURL)
+	clientOptions.SetConnectTimeout(ops.ConnectTimeout * time.Second)
+	if ops.AppName != "" {
+		clientOptions.SetAppName(ops.AppName)
+	}
+	if ops.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(ops.ReplicaSet)
+	}
+	if ops.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(ops.MaxPoolSize)
+	}
+	if ops.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(ops.MinPoolSize)
+	}
+	if ops.MaxConnIdleTime > 0 {
+		clientOptions.SetMaxConnIdleTime(ops.MaxConnIdleTime)
+	}
+	if ops.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(ops.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		readPreference, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetReadPreference(readPreference)
+	}
+	if ops.ReadConcern != "" {
+		clientOptions.SetReadConcern(readconcern.New(readconcern.Level(ops.ReadConcern)))
+	}
+	if ops.WriteConcern != nil {
+		writeConcern, err := ops.WriteConcern.writeConcern()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetWriteConcern(writeConcern)
+	}
+	if ops.RetryWrites != nil {
+		clientOptions.SetRetryWrites(*ops.RetryWrites)
+	}
+	if ops.RetryReads != nil {
+		clientOptions.SetRetryReads(*ops.RetryReads)
+	}
+	if ops.TLS != nil {
+		tlsConfig, err := ops.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	if len(ops.Compressors) > 0 {
+		clientOptions.SetCompressors(ops.Compressors)
+	}
+	return clientOptions, clientOptions.Validate()
+}
+
+func (wc *WriteConcern) writeConcern() (*writeconcern.WriteConcern, error) {
+	var writeConcernOptions []writeconcern.Option
+	switch w := wc.W.(type) {
+	case nil:
+	case int:
+		writeConcernOptions = append(writeConcernOptions, writeconcern.W(w))
+	case string:
+		if w == "majority" {
+			writeConcernOptions = append(writeConcernOptions, writeconcern.WMajority())
+		} else {
+			writeConcernOptions = append(writeConcernOptions, writeconcern.WTagSet(w))
+		}
+	default:
+		return nil, fmt.Errorf("goose: write concern w must be int or string, got %T", wc.W)
+	}
+	if wc.Journal != nil {
+		writeConcernOptions = append(writeConcernOptions, writeconcern.J(*wc.Journal))
+	}
+	if wc.WTimeout > 0 {
+		writeConcernOptions = append(writeConcernOptions, writeconcern.WTimeout(wc.WTimeout))
+	}
+	return writeconcern.New(writeConcernOptions...), nil
+}
+
+func (t *TLSOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: t.Insecure}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("goose: no certificate found in %s", t.CAFile)
+		}
+	}
+	if t.CertificateKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(t.CertificateKeyFile, t.CertificateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// String print options with credentials redacted
+func (ops DatabaseOptions) String() string {
+	if ops.Password != "" {
+		ops.Password = redacted
+	}
+	ops.URL = redactURL(ops.URL)
+
+	var fields []string
+	value := reflect.ValueOf(ops)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.IsZero() || field.Kind() == reflect.Interface || value.Type().Field(i).Tag.Get("string") == "-" {
+			continue
+		}
+		if field.Kind() == reflect.Ptr {
+			field = field.Elem()
+		}
+		fields = append(fields, fmt.Sprintf("%s=%+v", value.Type().Field(i).Name, field.Interface()))
+	}
+	return "{" + strings.Join(fields, " ") + "}"
+}
+
+// redactURL replace password in connection URL
+func redactURL(connectionURL string) string {
+	if connectionURL == "" {
+		return ""
+	}
+	u, err := url.Parse(connectionURL)
+	if err != nil {
+		return "<invalid connection URL>"
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+	query := u.Query()
+	for key := range query {
+		if strings.Contains(strings.ToLower(key), "password") {
+			query.Set(key, redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
 }
 
-func validateOptions(ops *DatabaseOptions) error {
+func validateOptions(ops *DatabaseOptions, logger Logger) error {
 	var validate = validator.New()
 	err := validate.Struct(ops)
 	if err != nil {
 		return err
 	}
-	log.WithField("ops", ops).Info("mongo database options.")
+	logger.Info("mongo database options.", "ops", ops.String())
 	return nil
 }
 
 // NewMongoDatabase new a goose mongo database
 func NewMongoDatabase(ops *DatabaseOptions) (*Database, error) {
-	err := validateOptions(ops)
+	logger := ops.Logger
+	if logger == nil {
+		logger = GetLogger()
+	}
+	err := validateOptions(ops, logger)
 	if err != nil {
-		log.Error("database options not valid.")
+		logger.Error("database options not valid.", "error", err)
 		return nil, err
 	}
-	var connectionURL string
-	var databaseName string
-	if ops.UsingEnv {
-		err := godotenv.Load()
-		if err != nil {
+	// do not change the options of caller
+	connectOptions := *ops
+	if connectOptions.ConnectTimeout == 0 {
+		connectOptions.ConnectTimeout = defaultConnectTimeout
+	}
+	if connectOptions.UsingEnv {
+		// .env is optional, MONGODB_* can be set in the environment
+		if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
 			return nil, err
 		}
-		log.Info("using env URL.")
-		connectionURL, err = getMongoConnURLFromEnv(ops)
-		if err != nil {
+		logger.Info("using env URL.")
+		if err := connectOptions.loadEnv(); err != nil {
 			return nil, err
 		}
-		databaseName = os.Getenv("MONGODB_DATABASE")
 	} else {
-		log.Info("using options URL.")
-		connectionURL = ops.URL
-		databaseName = ops.DatabaseName
+		logger.Info("using options URL.")
+	}
+	clientOptions, err := connectOptions.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	if connectOptions.QueryObserver != nil {
+		// without DatabaseOptions.Logger the observer reads the global logger when it logs, so SetLogger applies
+		clientOptions.SetMonitor(connectOptions.QueryObserver.commandMonitor(ops.Logger))
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), ops.ConnectTimeout*time.Second)
+	if connectOptions.Metrics != nil {
+		clientOptions.SetPoolMonitor(connectOptions.Metrics.PoolMonitor())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectOptions.ConnectTimeout*time.Second)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connectionURL))
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.WithField("URL", connectionURL).Error("could not parse connection URL.")
+		connectionURL, _ := connectOptions.connectionURL()
+		logger.Error("could not parse connection URL.", "URL", redactURL(connectionURL))
 		return nil, err
 	}
 	ctxPING, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -107,19 +389,39 @@ func NewMongoDatabase(ops *DatabaseOptions) (*Database, error) {
 	if err != nil {
 		return nil, err
 	}
-	db := client.Database(databaseName)
-	mongoClient := &Database{DB: db, Client: client, Context: ctx}
-	log.Info("mongodb has been connected")
+	db := client.Database(connectOptions.DatabaseName)
+	mongoClient := &Database{
+		DB:      db,
+		Client:  client,
+		Context: ctx,
+		logger:  logger,
+		metrics: connectOptions.Metrics,
+		tracer:  connectOptions.Tracer,
+		backend: mongoBackend{db},
+	}
+	logger.Info("mongodb has been connected")
 	DB = mongoClient.DB
+	defaultDatabase = mongoClient
 	return mongoClient, nil
 }
 
 // Close close database connection
 func (d *Database) Close() error {
+	if d.Client == nil {
+		return nil
+	}
 	err := d.Client.Disconnect(d.Context)
 	if err != nil {
-		log.WithField("message", err.Error()).Error("mongo database close error")
+		d.Logger().Error("mongo database close error", "message", err.Error())
 		return err
 	}
 	return nil
 }
+
+// Logger return the logger of database
+func (d *Database) Logger() Logger {
+	if d.logger == nil {
+		return GetLogger()
+	}
+	return d.logger
+}
@@ -0,0 +1,198 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Document a record bound to its model, returned by finds
+type Document struct {
+	model *Model
+	value interface{}
+	// raw the document loaded from database, including populated fields
+	raw       bson.Raw
+	populated map[string][]bson.Raw
+}
+
+// NewDocument bind v to the model, v should be a pointer to the model struct
+func (model *Model) NewDocument(v interface{}) *Document {
+	return &Document{model: model, value: v}
+}
+
+// decodeDocument decode a raw record into a new model struct value, old documents are upgraded to the schemaVersion
+func (model *Model) decodeDocument(ctx context.Context, raw bson.Raw) (*Document, error) {
+	return model.decodeUpgraded(ctx, raw, model.writeBackUpgrades)
+}
+
+// decodeUpgraded decode raw like decodeDocument, upgraded documents are saved only if writeBack is true,
+// documents which are not stored, such as imported ones, must not be written back
+func (model *Model) decodeUpgraded(ctx context.Context, raw bson.Raw, writeBack bool) (*Document, error) {
+	raw, err := model.upgradeDocument(ctx, raw, writeBack)
+	if err != nil {
+		return nil, err
+	}
+	value := model.newValue()
+	if err := bson.Unmarshal(raw, value); err != nil {
+		return nil, err
+	}
+	return &Document{model: model, value: value, raw: raw}, nil
+}
+
+// Value return the pointer to the model struct
+func (doc *Document) Value() interface{} {
+	return doc.value
+}
+
+// Raw return the raw document last loaded from database, it is nil if the document is not loaded
+func (doc *Document) Raw() bson.Raw {
+	return doc.raw
+}
+
+// ID return the primary key value of the document
+func (doc *Document) ID() interface{} {
+	if id := doc.model.primaryFieldValue(doc.value); id.IsValid() {
+		return id.Interface()
+	}
+	if doc.raw != nil {
+		if id, err := doc.raw.LookupErr(doc.model.primaryKey); err == nil {
+			target := reflect.New(doc.model.primaryType)
+			if err := id.Unmarshal(target.Interface()); err == nil {
+				return target.Elem().Interface()
+			}
+		}
+	}
+	return nil
+}
+
+// Save insert or update the document
+func (doc *Document) Save(ctx context.Context) error {
+	return doc.model.save(ctx, doc.value)
+}
+
+// Delete delete the document by primary key
+func (doc *Document) Delete(ctx context.Context) error {
+	_, err := doc.model.DeleteOneByID(ctx, doc.ID())
+	return err
+}
+
+// Reload reload the document from database, unsaved changes are discarded
+func (doc *Document) Reload(ctx context.Context) error {
+	loaded, err := doc.model.FindOneByID(ctx, doc.ID())
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(doc.value).Elem().Set(reflect.ValueOf(loaded.value).Elem())
+	doc.raw = loaded.raw
+	doc.populated = nil
+	return nil
+}
+
+// Populate load related documents of populate fields, populate all relations if names is empty
+func (doc *Document) Populate(ctx context.Context, names ...string) error {
+	for _, relation := range doc.model.relationship {
+		if len(names) > 0 && !containsString(names, relation.as) {
+			continue
+		}
+		localValue, err := doc.localFieldValue(relation.localField)
+		if err != nil {
+			return err
+		}
+		var related []bson.Raw
+		if localValue != nil {
+			filter, err := relatedScopeFilter(ctx, relation.from, bson.M{relation.foreignField: bson.M{"$in": localValue}})
+			if err != nil {
+				return err
+			}
+			cur, err := doc.model.collection.Database().Collection(relation.from).Find(ctx, filter)
+			if err != nil {
+				return doc.model.translateError(err)
+			}
+			if err := cur.All(ctx, &related); err != nil {
+				return doc.model.translateError(err)
+			}
+		}
+		if doc.populated == nil {
+			doc.populated = map[string][]bson.Raw{}
+		}
+		doc.populated[relation.as] = related
+	}
+	return nil
+}
+
+// localFieldValue return local field values as an array for $in query, nil if the field is empty
+func (doc *Document) localFieldValue(localField string) (bson.A, error) {
+	data, err := bson.Marshal(doc.value)
+	if err != nil {
+		return nil, err
+	}
+	value, err := bson.Raw(data).LookupErr(localField)
+	if err != nil {
+		return nil, nil
+	}
+	if values, ok := value.ArrayOK(); ok {
+		elements, err := values.Values()
+		if err != nil {
+			return nil, err
+		}
+		array := make(bson.A, 0, len(elements))
+		for _, element := range elements {
+			array = append(array, element)
+		}
+		return array, nil
+	}
+	return bson.A{value}, nil
+}
+
+// Populated decode populated documents of a relation into v, v can be a pointer to slice or struct
+func (doc *Document) Populated(name string, v interface{}) error {
+	related, ok := doc.populated[name]
+	if !ok && doc.raw != nil {
+		value, err := doc.raw.LookupErr(name)
+		if err != nil {
+			return ErrNotFound
+		}
+		array, ok := value.ArrayOK()
+		if !ok {
+			return ErrNotFound
+		}
+		return decodePopulated(array, v)
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	data, err := bson.Marshal(bson.M{"related": related})
+	if err != nil {
+		return err
+	}
+	return decodePopulated(bson.Raw(data).Lookup("related").Array(), v)
+}
+
+func decodePopulated(array bson.Raw, v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr {
+		return errors.New("goose: populated target must be a pointer")
+	}
+	if target.Elem().Kind() == reflect.Slice {
+		return bson.RawValue{Type: bson.TypeArray, Value: array}.Unmarshal(v)
+	}
+	values, err := array.Values()
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return ErrNotFound
+	}
+	return values[0].Unmarshal(v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
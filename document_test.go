@@ -0,0 +1,64 @@
+package goose
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSchemaShared(t *testing.T) {
+	if mustSchema(reflect.TypeOf(Post{})) != mustSchema(reflect.TypeOf(Post{})) {
+		t.Error("schema should be parsed once per type")
+	}
+}
+
+func TestSchemaVersionKind(t *testing.T) {
+	type Counter struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		Version uint               `goose:"version" bson:"version"`
+	}
+	NewMemoryDatabase()
+	if _, err := NewModel("counters", &Counter{}); err == nil {
+		t.Error("expected error of unsigned version field")
+	}
+}
+
+func TestDecodeDocument(t *testing.T) {
+	model := newTestModel("TestPosts", &Post{})
+	postID := primitive.NewObjectID()
+	userID := primitive.NewObjectID()
+	raw, err := bson.Marshal(bson.M{
+		"_id":    postID,
+		"title":  "test post",
+		"userId": userID,
+		"User":   bson.A{bson.M{"_id": userID, "name": "John Doe"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := model.decodeDocument(context.Background(), raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, ok := doc.Value().(*Post)
+	if !ok || post.Title != "test post" {
+		t.Fatalf("unexpected value %#v", doc.Value())
+	}
+	if doc.ID() != postID {
+		t.Errorf("unexpected id %v", doc.ID())
+	}
+	var user User
+	if err := doc.Populated("User", &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != userID || user.Name != "John Doe" {
+		t.Errorf("unexpected populated user %#v", user)
+	}
+	var users []User
+	if err := doc.Populated("User", &users); err != nil || len(users) != 1 {
+		t.Errorf("unexpected populated users %#v, %v", users, err)
+	}
+}
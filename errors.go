@@ -0,0 +1,179 @@
+package goose
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// goose error taxonomy, every model operation returns errors matching these with errors.Is
+var (
+	// ErrNotFound no document matched the filter
+	ErrNotFound = errors.New("goose: document not found")
+	// ErrDuplicateKey a unique index rejected the write, see DuplicateKeyError for details
+	ErrDuplicateKey = errors.New("goose: duplicate key")
+	// ErrInvalidID the given id can not be converted to the model primary key
+	ErrInvalidID = errors.New("goose: invalid id")
+	// ErrValidation the document did not pass struct validation, see ValidationError for details
+	ErrValidation = errors.New("goose: validation failed")
+	// ErrVersionConflict the document was modified since it was read
+	ErrVersionConflict = errors.New("goose: version conflict")
+	// ErrNoTenant the model or database is tenant scoped and the context has no tenant, see WithTenant
+	ErrNoTenant = errors.New("goose: no tenant in context")
+)
+
+// duplicate key error codes returned by mongo server
+var duplicateKeyCodes = map[int]bool{
+	11000: true,
+	11001: true,
+	12582: true,
+}
+
+var duplicateKeyPattern = regexp.MustCompile(`index: (\S+) dup key: \{(.*)\}`)
+
+// DuplicateKeyError a unique index rejected the write
+type DuplicateKeyError struct {
+	Collection string
+	Index      string
+	// Fields struct field names of the index keys, bson names are used if the field is not in the model
+	Fields []string
+	Err    error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("goose: duplicate key in %s index %s on %s", e.Collection, e.Index, strings.Join(e.Fields, ", "))
+}
+
+// Is make errors.Is(err, ErrDuplicateKey) work
+func (e *DuplicateKeyError) Is(target error) bool {
+	return target == ErrDuplicateKey
+}
+
+// Unwrap return the original mongo error
+func (e *DuplicateKeyError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError the document did not pass struct validation
+type ValidationError struct {
+	// Fields struct field names which failed validation
+	Fields []string
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("goose: validation failed on %s: %v", strings.Join(e.Fields, ", "), e.Err)
+}
+
+// Is make errors.Is(err, ErrValidation) work
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Unwrap return the original validator error
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func invalidIDError(id interface{}, err error) error {
+	if err == nil {
+		return fmt.Errorf("%w: %v", ErrInvalidID, id)
+	}
+	return fmt.Errorf("%w: %v: %v", ErrInvalidID, id, err)
+}
+
+func newValidationError(err error) error {
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return &ValidationError{Err: err}
+	}
+	fields := make([]string, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		fields = append(fields, fieldError.StructField())
+	}
+	return &ValidationError{Fields: fields, Err: err}
+}
+
+// translateError convert mongo driver errors into goose errors
+func (model *Model) translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	if message, ok := duplicateKeyMessage(err); ok {
+		return model.newDuplicateKeyError(message, err)
+	}
+	return err
+}
+
+func duplicateKeyMessage(err error) (string, bool) {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if duplicateKeyCodes[writeError.Code] {
+				return writeError.Message, true
+			}
+		}
+	}
+	var bulkWriteException mongo.BulkWriteException
+	if errors.As(err, &bulkWriteException) {
+		for _, writeError := range bulkWriteException.WriteErrors {
+			if duplicateKeyCodes[writeError.Code] {
+				return writeError.Message, true
+			}
+		}
+	}
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) && duplicateKeyCodes[int(commandError.Code)] {
+		return commandError.Message, true
+	}
+	return "", false
+}
+
+// newDuplicateKeyError parse message like `E11000 duplicate key error collection: test.users index: email_1 dup key: { email: "a@b" }`
+func (model *Model) newDuplicateKeyError(message string, err error) *DuplicateKeyError {
+	dupErr := &DuplicateKeyError{Collection: model.collectionName, Err: err}
+	matches := duplicateKeyPattern.FindStringSubmatch(message)
+	if matches == nil {
+		return dupErr
+	}
+	dupErr.Index = matches[1]
+	for _, bsonName := range duplicateKeyFields(matches[2]) {
+		dupErr.Fields = append(dupErr.Fields, model.structFieldName(bsonName))
+	}
+	return dupErr
+}
+
+// duplicateKeyFields extract keys from `{ email: "a@b", name: "x" }`, older servers omit the key names
+func duplicateKeyFields(keys string) []string {
+	var fields []string
+	inString := false
+	depth := 0
+	start := 0
+	for i, c := range keys {
+		switch {
+		case c == '"' && (i == 0 || keys[i-1] != '\\'):
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		case depth > 0:
+		case c == ':':
+			if name := strings.TrimSpace(keys[start:i]); name != "" {
+				fields = append(fields, name)
+			}
+			start = i + 1
+		case c == ',':
+			start = i + 1
+		}
+	}
+	return fields
+}
@@ -0,0 +1,77 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTranslateDuplicateKeyError(t *testing.T) {
+	model := &Model{
+		collectionName: "TestUsers",
+		schema: &schema{
+			fields: []Field{
+				{StructFieldName: "Email", BsonName: "email"},
+				{StructFieldName: "Name", BsonName: "name"},
+			},
+		},
+	}
+	mongoErr := mongo.WriteException{
+		WriteErrors: mongo.WriteErrors{{
+			Code:    11000,
+			Message: `E11000 duplicate key error collection: test.TestUsers index: email_1_name_1 dup key: { email: "john@example", name: "a, b: c" }`,
+		}},
+	}
+	err := model.translateError(mongoErr)
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected ErrDuplicateKey, got %v", err)
+	}
+	var dupErr *DuplicateKeyError
+	if !errors.As(err, &dupErr) {
+		t.Fatalf("expected DuplicateKeyError, got %T", err)
+	}
+	if dupErr.Index != "email_1_name_1" {
+		t.Errorf("unexpected index %s", dupErr.Index)
+	}
+	if !reflect.DeepEqual(dupErr.Fields, []string{"Email", "Name"}) {
+		t.Errorf("unexpected fields %v", dupErr.Fields)
+	}
+}
+
+func TestTranslateNotFoundError(t *testing.T) {
+	model := &Model{}
+	if err := model.translateError(mongo.ErrNoDocuments); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := model.translateError(nil); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	type account struct {
+		Email string `validate:"required,email"`
+	}
+	err := validateStruct(&account{Email: "not an email"})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected ErrValidation, got %v", err)
+	}
+	var validationErr *ValidationError
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &validationErr) {
+		t.Fatalf("expected ValidationError, got %T", err)
+	}
+	if !reflect.DeepEqual(validationErr.Fields, []string{"Email"}) {
+		t.Errorf("unexpected fields %v", validationErr.Fields)
+	}
+}
+
+func TestInvalidIDError(t *testing.T) {
+	model := &Model{schema: &schema{primaryKey: "_id", primaryType: objectIDType}}
+	if _, err := model.FindOneByID(context.Background(), "not-an-object-id"); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("expected ErrInvalidID, got %v", err)
+	}
+}
@@ -43,6 +43,7 @@ func main() {
 		log.Fatal(err)
 	}
 	defer db.Close()
+	ctx := context.Background()
 
 	userID := primitive.NewObjectID()
 	user := User{
@@ -50,31 +51,58 @@ func main() {
 		Name:  "John Doe",
 		Email: "john@example",
 	}
-	userModel := goose.NewModel("TestUsers", &user)
-	postModel := goose.NewModel("TestPosts", &Post{
+	userModel, err := goose.NewModel("TestUsers", &user)
+	if err != nil {
+		log.Fatal(err)
+	}
+	postModel, err := goose.NewModel("TestPosts", &Post{
 		UserID: userID,
 		Title:  "test post",
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
 	user.Name = "Pascal Lin"
-	err = userModel.Save()
+	err = userModel.Save(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = postModel.Save(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = postModel.Save()
+
+	postModel, err = goose.NewModel("TestPosts", &Post{})
 	if err != nil {
 		log.Fatal(err)
 	}
+	posts, err := postModel.Populate("User").Find(ctx, bson.M{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, doc := range posts {
+		var users []User
+		if err := doc.Populated("User", &users); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("post: ", doc.Value(), "users: ", users)
+	}
 
-	postModel = goose.NewModel("TestPosts", &Post{})
-	result, err := postModel.Populate("User").Find(bson.M{})
+	fmt.Println("======================")
+
+	doc, err := userModel.FindOneByID(ctx, userID)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("result: ", result)
+	doc.Value().(*User).Email = "pascal@example"
+	if err := doc.Save(ctx); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("user: ", doc.Value())
 
 	fmt.Println("======================")
 
-	singleResult := goose.DB.Collection("TestUsers").FindOne(context.Background(), bson.M{"_id": userID})
+	singleResult := goose.DB.Collection("TestUsers").FindOne(ctx, bson.M{"_id": userID})
 	var userResult User
 	singleResult.Decode(&userResult)
 	fmt.Println("user: ", userResult)
@@ -0,0 +1,183 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DocumentFactory create a document, it is implemented by ModelFactory and used for factory relations
+type DocumentFactory interface {
+	CreateDocument(ctx context.Context) (*Document, error)
+}
+
+// ModelFactory build and insert values of model struct T for tests and seeding.
+// Like Query, it is immutable, every method returns a new factory sharing the sequence counter
+type ModelFactory[T any] struct {
+	build     func(f *Faker) T
+	model     *Model
+	seed      int64
+	overrides []func(v *T)
+	relations []factoryRelation
+	counter   *int64
+}
+
+type factoryRelation struct {
+	name   string
+	parent DocumentFactory
+}
+
+// Factory new a factory of model struct T, values are inserted by the model created by NewModel for T
+// unless Model is set. Inserts go through InsertOne, so defaults, timestamps, ids and sequences are applied
+func Factory[T any](build func(f *Faker) T) *ModelFactory[T] {
+	return &ModelFactory[T]{build: build, seed: 1, counter: new(int64)}
+}
+
+func (factory *ModelFactory[T]) clone() *ModelFactory[T] {
+	cloned := *factory
+	cloned.overrides = append(make([]func(v *T), 0, len(factory.overrides)), factory.overrides...)
+	cloned.relations = append([]factoryRelation(nil), factory.relations...)
+	return &cloned
+}
+
+// Model insert values by model
+func (factory *ModelFactory[T]) Model(model *Model) *ModelFactory[T] {
+	cloned := factory.clone()
+	cloned.model = model
+	return cloned
+}
+
+// Seed set the seed of fakers and restart the sequence, the value of sequence number N is the same for the same seed
+func (factory *ModelFactory[T]) Seed(seed int64) *ModelFactory[T] {
+	cloned := factory.clone()
+	cloned.seed = seed
+	cloned.counter = new(int64)
+	return cloned
+}
+
+// With change built values before insert
+func (factory *ModelFactory[T]) With(override func(v *T)) *ModelFactory[T] {
+	cloned := factory.clone()
+	cloned.overrides = append(cloned.overrides, override)
+	return cloned
+}
+
+// For create the related document of a populate relation by parent if the local field is empty,
+// such as Factory[Post](...).For("User", userFactory)
+func (factory *ModelFactory[T]) For(relation string, parent DocumentFactory) *ModelFactory[T] {
+	cloned := factory.clone()
+	cloned.relations = append(cloned.relations, factoryRelation{name: relation, parent: parent})
+	return cloned
+}
+
+// Build build a value without inserting it
+func (factory *ModelFactory[T]) Build() T {
+	seq := int(atomic.AddInt64(factory.counter, 1))
+	v := factory.build(NewFaker(factory.seed+int64(seq), seq))
+	for _, override := range factory.overrides {
+		override(&v)
+	}
+	return v
+}
+
+// BuildMany build n values without inserting them
+func (factory *ModelFactory[T]) BuildMany(n int) []T {
+	values := make([]T, n)
+	for i := range values {
+		values[i] = factory.Build()
+	}
+	return values
+}
+
+// Create build and insert a value
+func (factory *ModelFactory[T]) Create(ctx context.Context) (*T, error) {
+	doc, err := factory.CreateDocument(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Value().(*T), nil
+}
+
+// CreateMany build and insert n values
+func (factory *ModelFactory[T]) CreateMany(ctx context.Context, n int) ([]*T, error) {
+	values := make([]*T, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := factory.Create(ctx)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// CreateDocument build and insert a value, return it as a document
+func (factory *ModelFactory[T]) CreateDocument(ctx context.Context) (*Document, error) {
+	model, err := factory.getModel()
+	if err != nil {
+		return nil, err
+	}
+	v := factory.Build()
+	for _, relation := range factory.relations {
+		if err := factory.createRelated(ctx, model, &v, relation); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := model.InsertOne(ctx, &v); err != nil {
+		return nil, err
+	}
+	return model.NewDocument(&v), nil
+}
+
+func (factory *ModelFactory[T]) getModel() (*Model, error) {
+	if factory.model != nil {
+		return factory.model, nil
+	}
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	model, ok := registeredModel(t)
+	if !ok {
+		return nil, fmt.Errorf("goose: no model of %s, create it by NewModel or set it by Factory.Model", t)
+	}
+	return model, nil
+}
+
+// createRelated create the parent document and set the local field of v to its foreign field
+func (factory *ModelFactory[T]) createRelated(ctx context.Context, model *Model, v *T, relation factoryRelation) error {
+	var rel *Relation
+	for i := range model.relationship {
+		if model.relationship[i].as == relation.name {
+			rel = &model.relationship[i]
+		}
+	}
+	if rel == nil {
+		return fmt.Errorf("goose: %s has no relation %s", model.collectionName, relation.name)
+	}
+	field := reflect.ValueOf(v).Elem().FieldByName(model.structFieldName(rel.localField))
+	if !field.IsValid() {
+		return fmt.Errorf("goose: relation %s local field %s is not a struct field", relation.name, rel.localField)
+	}
+	if !field.IsZero() {
+		return nil
+	}
+	parent, err := relation.parent.CreateDocument(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := bson.Marshal(parent.Value())
+	if err != nil {
+		return err
+	}
+	foreignValue, err := bson.Raw(data).LookupErr(rel.foreignField)
+	if err != nil {
+		return fmt.Errorf("goose: related %s document has no %s", relation.name, rel.foreignField)
+	}
+	target := reflect.New(field.Type())
+	if err := foreignValue.Unmarshal(target.Interface()); err != nil {
+		return err
+	}
+	field.Set(target.Elem())
+	return nil
+}
@@ -0,0 +1,63 @@
+package goose
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFactory(t *testing.T) {
+	NewMemoryDatabase()
+	ctx := context.Background()
+	if _, err := NewModel("TestUsers", &User{}); err != nil {
+		t.Fatal(err)
+	}
+	postModel, err := NewModel("TestPosts", &Post{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := Factory(func(f *Faker) User {
+		return User{Name: f.Name(), Email: f.Email()}
+	}).Seed(42)
+	if a, b := users.Seed(7).BuildMany(3), users.Seed(7).BuildMany(3); !reflect.DeepEqual(a, b) {
+		t.Errorf("expected the same values for the same seed, got %v and %v", a, b)
+	}
+
+	created, err := users.CreateMany(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 3 || created[0].ID.IsZero() || created[0].CreatedTime.IsZero() || created[0].Email == created[1].Email {
+		t.Fatalf("unexpected users %+v", created)
+	}
+
+	posts := Factory(func(f *Faker) Post {
+		return Post{Title: f.Sentence()}
+	}).For("User", users)
+	post, err := posts.With(func(p *Post) { p.Title = "hello" }).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Title != "hello" || post.Description != "No description." || post.UserID.IsZero() {
+		t.Fatalf("unexpected post %+v", post)
+	}
+	result, err := postModel.Populate("User").Find(ctx, bson.M{"_id": post.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var related []User
+	if err := result[0].Populated("User", &related); err != nil {
+		t.Fatal(err)
+	}
+	if len(related) != 1 || related[0].ID != post.UserID {
+		t.Errorf("unexpected related users %+v", related)
+	}
+
+	type Unknown struct{ Name string }
+	if _, err := Factory(func(f *Faker) Unknown { return Unknown{} }).Create(ctx); err == nil {
+		t.Error("expected error for struct without model")
+	}
+}
@@ -0,0 +1,137 @@
+package goose
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Faker generate fake values for factories, values are reproducible for the same seed and sequence number
+type Faker struct {
+	rand *rand.Rand
+	seq  int
+}
+
+var (
+	fakeFirstNames = []string{"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda", "William", "Elizabeth",
+		"David", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah", "Charles", "Karen", "Wei", "Yuki", "Ana", "Luca"}
+	fakeLastNames = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez",
+		"Hernandez", "Lopez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson", "Martin", "Lee", "Lin", "Tanaka", "Rossi", "Silva"}
+	fakeWords = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit", "sed", "do", "eiusmod",
+		"tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua", "enim", "ad", "minim", "veniam", "quis",
+		"nostrud", "exercitation", "ullamco", "laboris", "nisi", "aliquip", "ex", "ea", "commodo", "consequat"}
+	fakeDomains = []string{"example.com", "example.net", "example.org"}
+)
+
+// NewFaker new a faker, seq is the sequence number returned by Seq
+func NewFaker(seed int64, seq int) *Faker {
+	return &Faker{rand: rand.New(rand.NewSource(seed)), seq: seq}
+}
+
+// Seq the 1-based sequence number of the value being built, it is unique in a factory
+func (f *Faker) Seq() int {
+	return f.seq
+}
+
+// Rand the random source of the faker
+func (f *Faker) Rand() *rand.Rand {
+	return f.rand
+}
+
+// Int random int in [min, max]
+func (f *Faker) Int(min int, max int) int {
+	if max <= min {
+		return min
+	}
+	return min + f.rand.Intn(max-min+1)
+}
+
+// Float random float in [min, max)
+func (f *Faker) Float(min float64, max float64) float64 {
+	return min + f.rand.Float64()*(max-min)
+}
+
+// Bool random bool
+func (f *Faker) Bool() bool {
+	return f.rand.Intn(2) == 1
+}
+
+// Pick pick one of options
+func (f *Faker) Pick(options ...string) string {
+	if len(options) == 0 {
+		return ""
+	}
+	return options[f.rand.Intn(len(options))]
+}
+
+// Digits random string of n digits
+func (f *Faker) Digits(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteByte(byte('0' + f.rand.Intn(10)))
+	}
+	return b.String()
+}
+
+// Word random lorem word
+func (f *Faker) Word() string {
+	return f.Pick(fakeWords...)
+}
+
+// Words n random lorem words joined by space
+func (f *Faker) Words(n int) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = f.Word()
+	}
+	return strings.Join(words, " ")
+}
+
+// Sentence random lorem sentence
+func (f *Faker) Sentence() string {
+	sentence := f.Words(f.Int(4, 10))
+	return strings.ToUpper(sentence[:1]) + sentence[1:] + "."
+}
+
+// FirstName random first name
+func (f *Faker) FirstName() string {
+	return f.Pick(fakeFirstNames...)
+}
+
+// LastName random last name
+func (f *Faker) LastName() string {
+	return f.Pick(fakeLastNames...)
+}
+
+// Name random full name
+func (f *Faker) Name() string {
+	return f.FirstName() + " " + f.LastName()
+}
+
+// UserName random user name, it contains the sequence number so it is unique in a factory
+func (f *Faker) UserName() string {
+	return fmt.Sprintf("%s.%s%d", strings.ToLower(f.FirstName()), strings.ToLower(f.LastName()), f.seq)
+}
+
+// Email random email, it contains the sequence number so it is unique in a factory
+func (f *Faker) Email() string {
+	return f.UserName() + "@" + f.Pick(fakeDomains...)
+}
+
+// Time random time in [from, to)
+func (f *Faker) Time(from time.Time, to time.Time) time.Time {
+	if !to.After(from) {
+		return from
+	}
+	return from.Add(time.Duration(f.rand.Int63n(int64(to.Sub(from)))))
+}
+
+// UUID random version 4 UUID string
+func (f *Faker) UUID() string {
+	var u UUID
+	f.rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u.String()
+}
@@ -2,13 +2,9 @@ package goose
 
 import (
 	"context"
-	"log"
-	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
@@ -16,126 +12,242 @@ var (
 	defaultLimit int64 = 20
 )
 
-// FindOption goose custom FindOption extends mongo.options.FindOption
-type FindOption struct {
-	options.FindOptions
-	pipeline []primitive.D
+// Query find query of a model, it is immutable, every method returns a new Query,
+// so a model and its queries can be shared between goroutines
+type Query struct {
+	model    *Model
+	limit    *int64
+	skip     *int64
+	sort     bson.D
+	populate []string
+	scopes   []string
+	unscoped bool
+}
+
+func (model *Model) query() *Query {
+	return &Query{model: model}
+}
+
+// clone copy query so the returned query can be changed without affecting q
+func (q *Query) clone() *Query {
+	cloned := *q
+	cloned.sort = append(bson.D(nil), q.sort...)
+	cloned.populate = append([]string(nil), q.populate...)
+	cloned.scopes = append([]string(nil), q.scopes...)
+	return &cloned
 }
 
 // Limit set limit for find
-func (model *Model) Limit(num int64) *Model {
-	model.findOpt.SetLimit(num)
-	return model
+func (model *Model) Limit(num int64) *Query {
+	return model.query().Limit(num)
 }
 
 // Skip set skip for find
-func (model *Model) Skip(num int64) *Model {
-	model.findOpt.SetSkip(num)
-	return model
+func (model *Model) Skip(num int64) *Query {
+	return model.query().Skip(num)
 }
 
-// Populate populate data from other collection
-func (model *Model) Populate(collectionName string) *Model {
-	for _, relation := range model.relationship {
-		lookupStage := bson.D{
-			{
-				"$lookup",
-				bson.D{
-					{"from", relation.from},
-					{"localField", relation.localField},
-					{"foreignField", relation.foreignField},
-					{"as", relation.as}},
-			},
+// Sort set sort for find, such as bson.D{{Key: "createdTime", Value: -1}}
+func (model *Model) Sort(sort bson.D) *Query {
+	return model.query().Sort(sort)
+}
+
+// Populate populate data from other collection by relation names, all relations are populated if names is empty
+func (model *Model) Populate(names ...string) *Query {
+	return model.query().Populate(names...)
+}
+
+// Limit set limit for find
+func (q *Query) Limit(num int64) *Query {
+	cloned := q.clone()
+	cloned.limit = &num
+	return cloned
+}
+
+// Skip set skip for find
+func (q *Query) Skip(num int64) *Query {
+	cloned := q.clone()
+	cloned.skip = &num
+	return cloned
+}
+
+// Sort append sort keys for find
+func (q *Query) Sort(sort bson.D) *Query {
+	cloned := q.clone()
+	cloned.sort = append(cloned.sort, sort...)
+	return cloned
+}
+
+// Populate populate data from other collection by relation names, all relations are populated if names is empty
+func (q *Query) Populate(names ...string) *Query {
+	cloned := q.clone()
+	if len(names) == 0 {
+		for _, relation := range q.model.relationship {
+			names = append(names, relation.as)
+		}
+	}
+	for _, name := range names {
+		if !containsString(cloned.populate, name) {
+			cloned.populate = append(cloned.populate, name)
 		}
-		model.findOpt.pipeline = append(model.findOpt.pipeline, lookupStage)
 	}
-	return model
+	return cloned
 }
 
-func (model *Model) clearPagination() {
-	model.findOpt.SetLimit(defaultLimit)
-	model.findOpt.SetLimit(defaultSkip)
+// pipeline build aggregate pipeline for find
+func (q *Query) pipeline(filter interface{}) mongo.Pipeline {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+	if len(q.sort) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: q.sort}})
+	}
+	if q.skip != nil && *q.skip > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: *q.skip}})
+	}
+	if q.limit != nil && *q.limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: *q.limit}})
+	}
+	for _, relation := range q.model.relationship {
+		if !containsString(q.populate, relation.as) {
+			continue
+		}
+		pipeline = append(pipeline, bson.D{
+			{
+				Key: "$lookup",
+				Value: bson.D{
+					{Key: "from", Value: relation.from},
+					{Key: "localField", Value: relation.localField},
+					{Key: "foreignField", Value: relation.foreignField},
+					{Key: "as", Value: relation.as}},
+			},
+		})
+	}
+	return pipeline
 }
 
 // FindAndCountResult data struct for FindAndCount
 type FindAndCountResult struct {
 	Total int64
-	Data  []bson.Raw
+	Data  []*Document
 }
 
-// FindAndCount find data and number count
-func (model *Model) FindAndCount(filter bson.M) (*FindAndCountResult, error) {
-	var result []bson.Raw
-	options := &options.FindOptions{
-		Limit: model.findOpt.Limit,
-		Skip:  model.findOpt.Skip,
-	}
+// FindAndCount find data and number count, default limit is 20
+func (model *Model) FindAndCount(ctx context.Context, filter bson.M) (*FindAndCountResult, error) {
+	return model.query().FindAndCount(ctx, filter)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer func() {
-		model.clearPagination()
-		cancel()
-	}()
+// Find support populate find operation, populated documents can be read by Document.Populated
+func (model *Model) Find(ctx context.Context, filter interface{}) ([]*Document, error) {
+	return model.query().Find(ctx, filter)
+}
 
-	cur, err := model.collection.Find(ctx, filter, options)
-	if err != nil {
+// FindOne find data by filter, return ErrNotFound if no document matched
+func (model *Model) FindOne(ctx context.Context, filter interface{}) (doc *Document, err error) {
+	ctx, finish := model.startOperation(ctx, "FindOne")
+	defer func() { finish(err) }()
+	if filter, err = model.query().scopeFilter(ctx, filter); err != nil {
 		return nil, err
 	}
-	defer cur.Close(ctx)
-	cur.Current.Lookup()
-	for cur.Next(ctx) {
-		result = append(result, cur.Current)
+	raw, err := model.collection.FindOne(ctx, filter)
+	if err != nil {
+		return nil, model.translateError(err)
 	}
-	if err := cur.Err(); err != nil {
-		log.Fatal(err)
+	return model.decodeDocument(ctx, raw)
+}
+
+// FindAndCount find data and number count, default limit is 20 and default skip is 0
+func (q *Query) FindAndCount(ctx context.Context, filter bson.M) (result *FindAndCountResult, err error) {
+	ctx, finish := q.model.startOperation(ctx, "FindAndCount")
+	defer func() { finish(err) }()
+	paginated := q
+	if q.limit == nil {
+		paginated = paginated.Limit(defaultLimit)
 	}
-	total, err := model.collection.CountDocuments(ctx, filter)
+	if q.skip == nil {
+		paginated = paginated.Skip(defaultSkip)
+	}
+	docs, err := paginated.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
+	var countFilter interface{} = filter
+	if filter == nil {
+		countFilter = bson.M{}
+	}
+	if countFilter, err = q.scopeFilter(ctx, countFilter); err != nil {
+		return nil, err
+	}
+	total, err := q.model.collection.CountDocuments(ctx, countFilter)
+	if err != nil {
+		return nil, q.model.translateError(err)
+	}
 	return &FindAndCountResult{
 		Total: total,
-		Data:  result,
+		Data:  docs,
 	}, nil
 }
 
-// Find support populate find operation
-func (model *Model) Find(filter interface{}) (result []bson.M, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	showLoadedCursor, err := model.collection.Aggregate(ctx, model.findOpt.pipeline)
-	if err != nil {
+// Find find documents by filter with query options
+func (q *Query) Find(ctx context.Context, filter interface{}) (docs []*Document, err error) {
+	ctx, finish := q.model.startOperation(ctx, "Find")
+	defer func() { finish(err) }()
+	if filter, err = q.scopeFilter(ctx, filter); err != nil {
 		return nil, err
 	}
-	var showsLoaded []bson.M
-	if err = showLoadedCursor.All(ctx, &showsLoaded); err != nil {
+	pipeline, err := q.model.scopeLookups(ctx, q.pipeline(filter))
+	if err != nil {
 		return nil, err
 	}
-	return showsLoaded, nil
+	cur, err := q.model.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, q.model.translateError(err)
+	}
+	return q.model.decodeCursor(ctx, cur)
 }
 
-// FindOne find data by filter
-func (model *Model) FindOne(filter interface{}) (result *mongo.SingleResult, err error) {
-	result = model.collection.FindOne(context.Background(), filter)
-	err = result.Err()
+// FindOne find the first document by filter with query options, return ErrNotFound if no document matched
+func (q *Query) FindOne(ctx context.Context, filter interface{}) (doc *Document, err error) {
+	ctx, finish := q.model.startOperation(ctx, "FindOne")
+	defer func() { finish(err) }()
+	docs, err := q.Limit(1).Find(ctx, filter)
 	if err != nil {
-		if result.Err() == mongo.ErrNoDocuments {
-			return nil, nil
-		}
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNotFound
 	}
-	return result, err
+	return docs[0], nil
 }
 
-// FindOneByID find data by model.primaryKey
-func (model *Model) FindOneByID(id string) (*mongo.SingleResult, error) {
-	mongoID, err := primitive.ObjectIDFromHex(id)
+// FindOneByID find data by model.primaryKey, id is the primary key in its native type or a string form of it,
+// return ErrNotFound if no document matched
+func (model *Model) FindOneByID(ctx context.Context, id interface{}) (doc *Document, err error) {
+	ctx, finish := model.startOperation(ctx, "FindOneByID")
+	defer func() { finish(err) }()
+	filter, err := model.idFilter(id)
 	if err != nil {
 		return nil, err
 	}
-	singleResult := model.collection.FindOne(context.Background(), bson.M{model.primaryKey: mongoID})
-	if singleResult.Err() != nil {
-		return nil, singleResult.Err()
+	return model.FindOne(ctx, filter)
+}
+
+func (model *Model) decodeCursor(ctx context.Context, cur cursor) ([]*Document, error) {
+	defer cur.Close(ctx)
+	var docs []*Document
+	for cur.Next(ctx) {
+		// cursor reuses the buffer of Current
+		raw := make(bson.Raw, len(cur.Raw()))
+		copy(raw, cur.Raw())
+		doc, err := model.decodeDocument(ctx, raw)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, model.translateError(err)
 	}
-	return singleResult, nil
+	return docs, nil
 }
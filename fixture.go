@@ -0,0 +1,415 @@
+package goose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/yaml.v3"
+)
+
+// fixtureRefPrefix string values like "@users.alice" are replaced by the primary key of fixture alice in users,
+// "@users.alice.email" by its email, use "@@" for a literal "@"
+const fixtureRefPrefix = "@"
+
+// Fixtures documents loaded from fixture files, by collection and symbolic name.
+//
+// A fixture file is Extended JSON (.json) or YAML (.yaml, .yml) mapping collection names to named documents:
+//
+//	users:
+//	  alice:
+//	    name: Alice
+//	posts:
+//	  hello:
+//	    title: Hello
+//	    userId: "@users.alice"
+//
+// Missing primary keys are generated before references are resolved, so documents can reference each other across files
+type Fixtures struct {
+	collections []string
+	names       map[string][]string
+	docs        map[string]map[string]bson.D
+}
+
+// LoadFixtures parse fixture files and insert the documents, paths can be files or directories
+func LoadFixtures(ctx context.Context, paths ...string) (*Fixtures, error) {
+	fixtures, err := ParseFixtures(paths...)
+	if err != nil {
+		return nil, err
+	}
+	return fixtures, fixtures.Insert(ctx)
+}
+
+// ParseFixtures parse fixture files, generate primary keys and resolve references without inserting,
+// paths can be files or directories, files of a directory are read in name order
+func ParseFixtures(paths ...string) (*Fixtures, error) {
+	fixtures := &Fixtures{
+		names: map[string][]string{},
+		docs:  map[string]map[string]bson.D{},
+	}
+	files, err := fixtureFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := fixtures.parseFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := fixtures.ensureIDs(); err != nil {
+		return nil, err
+	}
+	if err := fixtures.resolveReferences(); err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+func fixtureFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		var dirFiles []string
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".json", ".yaml", ".yml":
+				if !entry.IsDir() {
+					dirFiles = append(dirFiles, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+		sort.Strings(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+func (fixtures *Fixtures) parseFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return fmt.Errorf("goose: fixture %s: %w", file, err)
+		}
+		var buf bytes.Buffer
+		if err := yamlToExtJSON(&node, &buf); err != nil {
+			return fmt.Errorf("goose: fixture %s: %w", file, err)
+		}
+		data = buf.Bytes()
+	case ".json":
+	default:
+		return fmt.Errorf("goose: fixture %s must be .json, .yaml or .yml", file)
+	}
+	var content bson.D
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := bson.UnmarshalExtJSON(data, false, &content); err != nil {
+			return fmt.Errorf("goose: fixture %s: %w", file, err)
+		}
+	}
+	for _, collection := range content {
+		named, ok := collection.Value.(bson.D)
+		if !ok {
+			return fmt.Errorf("goose: fixture %s: %s must map names to documents", file, collection.Key)
+		}
+		if _, ok := fixtures.docs[collection.Key]; !ok {
+			fixtures.collections = append(fixtures.collections, collection.Key)
+			fixtures.docs[collection.Key] = map[string]bson.D{}
+		}
+		for _, e := range named {
+			doc, ok := e.Value.(bson.D)
+			if !ok {
+				return fmt.Errorf("goose: fixture %s: %s.%s must be a document", file, collection.Key, e.Key)
+			}
+			if _, exists := fixtures.docs[collection.Key][e.Key]; exists {
+				return fmt.Errorf("goose: fixture %s: duplicated name %s.%s", file, collection.Key, e.Key)
+			}
+			fixtures.docs[collection.Key][e.Key] = doc
+			fixtures.names[collection.Key] = append(fixtures.names[collection.Key], e.Key)
+		}
+	}
+	return nil
+}
+
+// yamlToExtJSON write YAML as Extended JSON keeping the key order, so `$oid`, `$date` and other
+// Extended JSON wrappers work in YAML too
+func yamlToExtJSON(node *yaml.Node, buf *bytes.Buffer) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return yamlToExtJSON(node.Content[0], buf)
+	case yaml.AliasNode:
+		return yamlToExtJSON(node.Alias, buf)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := yamlToExtJSON(node.Content[i+1], buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := yamlToExtJSON(item, buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case time.Time:
+			fmt.Fprintf(buf, `{"$date":%q}`, v.UTC().Format(time.RFC3339Nano))
+		case float64:
+			switch {
+			case math.IsNaN(v):
+				buf.WriteString(`{"$numberDouble":"NaN"}`)
+			case math.IsInf(v, 1):
+				buf.WriteString(`{"$numberDouble":"Infinity"}`)
+			case math.IsInf(v, -1):
+				buf.WriteString(`{"$numberDouble":"-Infinity"}`)
+			default:
+				// keep doubles as doubles, relaxed Extended JSON reads `1` as int
+				s := strconv.FormatFloat(v, 'g', -1, 64)
+				if !strings.ContainsAny(s, ".eE") {
+					s += ".0"
+				}
+				buf.WriteString(s)
+			}
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			buf.Write(data)
+		}
+	}
+	return nil
+}
+
+// primaryKey the primary key bson name of fixtures in collection
+func fixturePrimaryKey(collection string) string {
+	if model, ok := registeredCollectionModel(collection); ok {
+		return model.primaryKey
+	}
+	return "_id"
+}
+
+// ensureIDs generate missing primary keys by the id generator of the collection model, or ObjectID without model
+func (fixtures *Fixtures) ensureIDs() error {
+	for _, collection := range fixtures.collections {
+		model, hasModel := registeredCollectionModel(collection)
+		for _, name := range fixtures.names[collection] {
+			doc := fixtures.docs[collection][name]
+			primaryKey := fixturePrimaryKey(collection)
+			if _, ok := lookupValue(doc, primaryKey); ok {
+				continue
+			}
+			var id interface{} = primitive.NewObjectID()
+			if hasModel {
+				if model.idGeneratorName == "" {
+					continue
+				}
+				generator, ok := getIDGenerator(model.idGeneratorName)
+				if !ok {
+					return fmt.Errorf("goose: unknown id generator %q", model.idGeneratorName)
+				}
+				var err error
+				if id, err = convertID(generator(), model.primaryType); err != nil {
+					return err
+				}
+			}
+			doc = append(bson.D{{Key: primaryKey, Value: id}}, doc...)
+			fixtures.docs[collection][name] = doc
+		}
+	}
+	return nil
+}
+
+func (fixtures *Fixtures) resolveReferences() error {
+	for _, collection := range fixtures.collections {
+		for _, name := range fixtures.names[collection] {
+			resolved, err := fixtures.resolveValue(fixtures.docs[collection][name])
+			if err != nil {
+				return fmt.Errorf("goose: fixture %s.%s: %w", collection, name, err)
+			}
+			fixtures.docs[collection][name] = resolved.(bson.D)
+		}
+	}
+	return nil
+}
+
+func (fixtures *Fixtures) resolveValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bson.D:
+		resolved := make(bson.D, len(v))
+		for i, e := range v {
+			child, err := fixtures.resolveValue(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = bson.E{Key: e.Key, Value: child}
+		}
+		return resolved, nil
+	case bson.A:
+		resolved := make(bson.A, len(v))
+		for i, item := range v {
+			child, err := fixtures.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = child
+		}
+		return resolved, nil
+	case string:
+		if !strings.HasPrefix(v, fixtureRefPrefix) {
+			return v, nil
+		}
+		if strings.HasPrefix(v, fixtureRefPrefix+fixtureRefPrefix) {
+			return v[len(fixtureRefPrefix):], nil
+		}
+		return fixtures.reference(v[len(fixtureRefPrefix):])
+	default:
+		return value, nil
+	}
+}
+
+// reference resolve `collection.name` or `collection.name.field`
+func (fixtures *Fixtures) reference(ref string) (interface{}, error) {
+	parts := strings.SplitN(ref, ".", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid reference %q, expected @collection.name", fixtureRefPrefix+ref)
+	}
+	doc, ok := fixtures.docs[parts[0]][parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("reference %q not found", fixtureRefPrefix+ref)
+	}
+	field := fixturePrimaryKey(parts[0])
+	if len(parts) == 3 {
+		field = parts[2]
+	}
+	value, ok := lookupValue(doc, field)
+	if !ok {
+		return nil, fmt.Errorf("reference %q has no field %s", fixtureRefPrefix+ref, field)
+	}
+	if s, isString := value.(string); isString && strings.HasPrefix(s, fixtureRefPrefix) && !strings.HasPrefix(s, fixtureRefPrefix+fixtureRefPrefix) {
+		return nil, fmt.Errorf("reference %q points to another reference", fixtureRefPrefix+ref)
+	}
+	return value, nil
+}
+
+// Insert insert fixtures into the database connected last, collections with a model are inserted by
+// model.InsertOne so defaults, timestamps, sequences and validation are applied, others by BulkWrite
+func (fixtures *Fixtures) Insert(ctx context.Context) error {
+	for _, collection := range fixtures.collections {
+		names := fixtures.names[collection]
+		if len(names) == 0 {
+			continue
+		}
+		model, ok := registeredCollectionModel(collection)
+		if !ok {
+			writes := make([]mongo.WriteModel, 0, len(names))
+			for _, name := range names {
+				writes = append(writes, mongo.NewInsertOneModel().SetDocument(fixtures.docs[collection][name]))
+			}
+			if _, err := getCollection(collection).BulkWrite(ctx, writes); err != nil {
+				return fmt.Errorf("goose: insert fixtures of %s: %w", collection, err)
+			}
+			continue
+		}
+		for _, name := range names {
+			data, err := bson.Marshal(fixtures.docs[collection][name])
+			if err != nil {
+				return err
+			}
+			value := model.newValue()
+			if err := bson.Unmarshal(data, value); err != nil {
+				return fmt.Errorf("goose: fixture %s.%s: %w", collection, name, err)
+			}
+			if _, err := model.InsertOne(ctx, value); err != nil {
+				return fmt.Errorf("goose: insert fixture %s.%s: %w", collection, name, err)
+			}
+			// keep generated values, such as sequences and timestamps
+			if inserted, err := toDocument(value); err == nil {
+				fixtures.docs[collection][name] = inserted
+			}
+		}
+	}
+	return nil
+}
+
+// Len the number of fixture documents
+func (fixtures *Fixtures) Len() int {
+	n := 0
+	for _, names := range fixtures.names {
+		n += len(names)
+	}
+	return n
+}
+
+// ID return the primary key of a fixture, nil if not found
+func (fixtures *Fixtures) ID(collection string, name string) interface{} {
+	doc, ok := fixtures.docs[collection][name]
+	if !ok {
+		return nil
+	}
+	id, _ := lookupValue(doc, fixturePrimaryKey(collection))
+	return id
+}
+
+// Raw return the document of a fixture, nil if not found
+func (fixtures *Fixtures) Raw(collection string, name string) bson.Raw {
+	doc, ok := fixtures.docs[collection][name]
+	if !ok {
+		return nil
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,84 @@
+package goose
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func writeFixture(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	NewMemoryDatabase()
+	ctx := context.Background()
+	if _, err := NewModel("TestUsers", &User{}); err != nil {
+		t.Fatal(err)
+	}
+	postModel, err := NewModel("TestPosts", &Post{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeFixture(t, dir, "1_users.json", `{
+		"TestUsers": {
+			"alice": {"_id": {"$oid": "5f7c0a8e9d3b2a1c4e5f6a7b"}, "name": "Alice", "email": "alice@example.com"},
+			"bob": {"name": "Bob", "email": "bob@example.com"}
+		}
+	}`)
+	writeFixture(t, dir, "2_posts.yaml", `
+TestPosts:
+  hello:
+    title: Hello
+    userId: "@TestUsers.bob"
+    rate: 4.0
+TestTags:
+  go:
+    label: "@@go"
+    author: "@TestUsers.alice.email"
+`)
+
+	fixtures, err := LoadFixtures(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceID, _ := primitive.ObjectIDFromHex("5f7c0a8e9d3b2a1c4e5f6a7b")
+	if fixtures.ID("TestUsers", "alice") != aliceID {
+		t.Errorf("unexpected alice id %v", fixtures.ID("TestUsers", "alice"))
+	}
+	bobID, ok := fixtures.ID("TestUsers", "bob").(primitive.ObjectID)
+	if !ok || bobID.IsZero() {
+		t.Fatalf("expected generated bob id, got %v", fixtures.ID("TestUsers", "bob"))
+	}
+
+	doc, err := postModel.FindOneByID(ctx, fixtures.ID("TestPosts", "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := doc.Value().(*Post)
+	if post.UserID != bobID || post.Rate != 4 || post.Description != "No description." || post.CreatedTime.IsZero() {
+		t.Errorf("unexpected post %+v", post)
+	}
+
+	raw, err := getCollection("TestTags").FindOne(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Lookup("label").StringValue() != "@go" || raw.Lookup("author").StringValue() != "alice@example.com" {
+		t.Errorf("unexpected tag %v", raw)
+	}
+
+	writeFixture(t, dir, "3_broken.json", `{"TestPosts": {"bad": {"userId": "@TestUsers.carol"}}}`)
+	if _, err := ParseFixtures(dir); err == nil {
+		t.Error("expected unresolved reference error")
+	}
+}
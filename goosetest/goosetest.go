@@ -0,0 +1,259 @@
+// Package goosetest runs a local mongod for integration tests.
+//
+// The mongod binary is looked up in PATH, tests are skipped if it is not installed:
+//
+//	func TestUsers(t *testing.T) {
+//		server := goosetest.StartServer(t, nil)
+//		t.Run("create", func(t *testing.T) {
+//			db := server.NewDatabase(t) // empty database, dropped after the test
+//			...
+//		})
+//	}
+package goosetest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pascallin/goose"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// ReplicaSetName replica set name of servers started with Options.ReplicaSet
+const ReplicaSetName = "rs0"
+
+const (
+	defaultBinary       = "mongod"
+	defaultStartTimeout = 30 * time.Second
+	stopTimeout         = 10 * time.Second
+)
+
+// Options mongod options
+type Options struct {
+	// ReplicaSet start a single node replica set, which is needed by transactions and change streams
+	ReplicaSet bool
+	// Binary mongod binary name or path, default is mongod
+	Binary string
+	// Args extra mongod arguments
+	Args []string
+	// StartTimeout time to wait for mongod accepting connections, default is 30 seconds
+	StartTimeout time.Duration
+}
+
+// Server a running mongod listening on 127.0.0.1 with a temporary dbpath
+type Server struct {
+	url     string
+	port    int
+	cmd     *exec.Cmd
+	logPath string
+	exited  chan struct{}
+}
+
+// database names are unique in a test binary, so tests can share a server
+var databaseCounter int64
+
+// StartServer start a mongod on a random port, it is stopped and its data removed when t finished.
+// t is skipped if mongod is not in PATH
+func StartServer(t testing.TB, opts *Options) *Server {
+	t.Helper()
+	if opts == nil {
+		opts = &Options{}
+	}
+	binary := opts.Binary
+	if binary == "" {
+		binary = defaultBinary
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		t.Skipf("goosetest: %s not found in PATH, skip integration test", binary)
+	}
+	startTimeout := opts.StartTimeout
+	if startTimeout == 0 {
+		startTimeout = defaultStartTimeout
+	}
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("goosetest: find free port: %v", err)
+	}
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "db")
+	if err := os.Mkdir(dbPath, 0o755); err != nil {
+		t.Fatalf("goosetest: create dbpath: %v", err)
+	}
+	args := []string{
+		"--port", strconv.Itoa(port),
+		"--bind_ip", "127.0.0.1",
+		"--dbpath", dbPath,
+		"--logpath", filepath.Join(dir, "mongod.log"),
+	}
+	if opts.ReplicaSet {
+		args = append(args, "--replSet", ReplicaSetName)
+	}
+	args = append(args, opts.Args...)
+
+	server := &Server{
+		url:     fmt.Sprintf("mongodb://127.0.0.1:%d/", port),
+		port:    port,
+		cmd:     exec.Command(path, args...),
+		logPath: filepath.Join(dir, "mongod.log"),
+		exited:  make(chan struct{}),
+	}
+	if err := server.cmd.Start(); err != nil {
+		t.Fatalf("goosetest: start mongod: %v", err)
+	}
+	go func() {
+		server.cmd.Wait()
+		close(server.exited)
+	}()
+	t.Cleanup(server.stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	if err := server.waitReady(ctx, opts.ReplicaSet); err != nil {
+		t.Fatalf("goosetest: mongod not ready: %v\n%s", err, server.logTail())
+	}
+	if opts.ReplicaSet {
+		server.url += "?replicaSet=" + ReplicaSetName
+	}
+	return server
+}
+
+// URL connection URL of the server
+func (s *Server) URL() string {
+	return s.url
+}
+
+// NewDatabase connect to an empty database with a unique name, the database is dropped and closed when t finished
+func (s *Server) NewDatabase(t testing.TB) *goose.Database {
+	t.Helper()
+	name := fmt.Sprintf("goosetest_%d_%d", os.Getpid(), atomic.AddInt64(&databaseCounter, 1))
+	db, err := goose.NewMongoDatabase(&goose.DatabaseOptions{
+		URL:          s.url,
+		DatabaseName: name,
+		Logger:       goose.NewNopLogger(),
+	})
+	if err != nil {
+		t.Fatalf("goosetest: connect mongod: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+		if err := db.DB.Drop(ctx); err != nil {
+			t.Errorf("goosetest: drop database %s: %v", name, err)
+		}
+		db.Close()
+	})
+	return db
+}
+
+// NewDatabase start a standalone mongod for t and connect to an empty database on it
+func NewDatabase(t testing.TB) *goose.Database {
+	t.Helper()
+	return StartServer(t, nil).NewDatabase(t)
+}
+
+// waitReady ping the server until it accepts connections, initiate the replica set if needed
+func (s *Server) waitReady(ctx context.Context, replicaSet bool) error {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(s.url).SetDirect(true))
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	for {
+		select {
+		case <-s.exited:
+			return fmt.Errorf("mongod exited: %v", s.cmd.ProcessState)
+		default:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, time.Second)
+		err = client.Ping(pingCtx, readpref.PrimaryPreferred())
+		cancel()
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !replicaSet {
+		return nil
+	}
+
+	config := bson.D{
+		{Key: "_id", Value: ReplicaSetName},
+		{Key: "members", Value: bson.A{bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: fmt.Sprintf("127.0.0.1:%d", s.port)}}}},
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "replSetInitiate", Value: config}}).Err(); err != nil {
+		return fmt.Errorf("replSetInitiate: %w", err)
+	}
+	for {
+		var hello struct {
+			IsMaster bool `bson:"ismaster"`
+		}
+		if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello); err != nil {
+			return err
+		}
+		if hello.IsMaster {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("replica set has no primary: %w", ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// stop interrupt mongod so it shuts down cleanly, kill it if it does not exit in time
+func (s *Server) stop() {
+	select {
+	case <-s.exited:
+		return
+	default:
+	}
+	s.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-s.exited:
+	case <-time.After(stopTimeout):
+		s.cmd.Process.Kill()
+		<-s.exited
+	}
+}
+
+// logTail the last lines of mongod log for failure messages
+func (s *Server) logTail() string {
+	data, err := ioutil.ReadFile(s.logPath)
+	if err != nil {
+		return ""
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) > 20 {
+		lines = lines[len(lines)-20:]
+	}
+	return string(bytes.Join(lines, []byte("\n")))
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,503 @@
+package goose
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HistoryCollectionSuffix history records of a collection are stored in `<collection>_history` by default
+const HistoryCollectionSuffix = "_history"
+
+var errNoHistory = errors.New("goose: model history is not enabled")
+
+// HistoryOperation the write recorded by a revision
+type HistoryOperation string
+
+// history operations
+const (
+	HistoryInsert     HistoryOperation = "insert"
+	HistoryUpdate     HistoryOperation = "update"
+	HistorySoftDelete HistoryOperation = "softDelete"
+	HistoryDelete     HistoryOperation = "delete"
+	HistoryRestore    HistoryOperation = "restore"
+)
+
+// HistoryChange a top level field changed by a write, Before is nil for added fields and After is nil for removed fields
+type HistoryChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// Revision a history record of a document, revisions of a document are numbered from 1
+type Revision struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	DocumentID interface{}        `bson:"documentId" json:"documentId"`
+	Revision   int64              `bson:"revision" json:"revision"`
+	Operation  HistoryOperation   `bson:"operation" json:"operation"`
+	Actor      string             `bson:"actor,omitempty" json:"actor,omitempty"`
+	// Tenant the `goose:"tenant"` field of the document, revisions are only read by its tenant
+	Tenant    string          `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Timestamp time.Time       `bson:"timestamp" json:"timestamp"`
+	Changes   []HistoryChange `bson:"changes" json:"changes"`
+	// Document the stored document after the write, it is empty for deletes
+	Document bson.Raw `bson:"document,omitempty" json:"-"`
+}
+
+// HistoryOptions options of model history
+type HistoryOptions struct {
+	// Collection the history collection, default is the model collection name with HistoryCollectionSuffix
+	Collection string
+	// Actor get the actor of a write from its context, default is ActorFromContext
+	Actor func(ctx context.Context) string
+}
+
+// history enabled history of a model
+type history struct {
+	collection collection
+	actor      func(ctx context.Context) string
+}
+
+type actorKey struct{}
+
+// WithActor attach the user or service doing writes to ctx, it is recorded in the history
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext return the actor attached by WithActor
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok
+}
+
+// EnableHistory record every insert, update, soft delete and delete of the model to the history collection.
+// options can be nil
+func (model *Model) EnableHistory(options *HistoryOptions) error {
+	collectionName := model.collectionName + HistoryCollectionSuffix
+	if options != nil && options.Collection != "" {
+		collectionName = options.Collection
+	}
+	h := &history{
+		collection: model.collection.Database().Collection(collectionName),
+		actor: func(ctx context.Context) string {
+			actor, _ := ActorFromContext(ctx)
+			return actor
+		},
+	}
+	if options != nil && options.Actor != nil {
+		h.actor = options.Actor
+	}
+	index := Index{Name: "documentId_1_revision_1", Keys: bson.D{{Key: "documentId", Value: int32(1)}, {Key: "revision", Value: int32(1)}}, Unique: true}
+	if _, err := h.collection.CreateIndex(context.Background(), index.model()); err != nil {
+		return model.translateError(err)
+	}
+	model.history = h
+	return nil
+}
+
+// Revisions list the revisions of a document by id, oldest first
+func (model *Model) Revisions(ctx context.Context, id interface{}) (revisions []Revision, err error) {
+	ctx, finish := model.startOperation(ctx, "Revisions")
+	defer func() { finish(err) }()
+	if model.history == nil {
+		return nil, errNoHistory
+	}
+	filter, err := model.idFilter(id)
+	if err != nil {
+		return nil, err
+	}
+	historyFilter, err := model.historyFilter(ctx, filter[model.primaryKey])
+	if err != nil {
+		return nil, err
+	}
+	cur, err := model.history.collection.Find(ctx, historyFilter, options.Find().SetSort(bson.D{{Key: "revision", Value: 1}}))
+	if err != nil {
+		return nil, model.translateError(err)
+	}
+	defer cur.Close(ctx)
+	revisions = []Revision{}
+	if err := cur.All(ctx, &revisions); err != nil {
+		return nil, model.translateError(err)
+	}
+	return revisions, nil
+}
+
+// RestoreRevision write back the document stored by a revision, a deleted document is inserted again.
+// The restore is recorded as a new revision
+func (model *Model) RestoreRevision(ctx context.Context, id interface{}, revision int64) (doc *Document, err error) {
+	ctx, finish := model.startOperation(ctx, "RestoreRevision")
+	defer func() { finish(err) }()
+	if model.history == nil {
+		return nil, errNoHistory
+	}
+	filter, err := model.idFilter(id)
+	if err != nil {
+		return nil, err
+	}
+	historyFilter, err := model.historyFilter(ctx, filter[model.primaryKey])
+	if err != nil {
+		return nil, err
+	}
+	historyFilter["revision"] = revision
+	var record Revision
+	raw, err := model.history.collection.FindOne(ctx, historyFilter)
+	if err != nil {
+		return nil, model.translateError(err)
+	}
+	if err := bson.Unmarshal(raw, &record); err != nil {
+		return nil, err
+	}
+	if len(record.Document) == 0 {
+		return nil, fmt.Errorf("goose: revision %d of %v has no document to restore", revision, id)
+	}
+
+	scoped, err := model.scopeFilter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	before, _, err := model.historyTargets(ctx, scoped, false)
+	if err != nil {
+		return nil, err
+	}
+	replace := mongo.NewReplaceOneModel().SetFilter(scoped).SetReplacement(record.Document).SetUpsert(true)
+	if _, err := model.collection.BulkWrite(ctx, []mongo.WriteModel{replace}); err != nil {
+		return nil, model.translateError(err)
+	}
+	after, err := model.historyDocuments(ctx, bson.A{filter[model.primaryKey]})
+	if err != nil {
+		return nil, err
+	}
+	if len(after) == 0 {
+		return nil, ErrNotFound
+	}
+	if err := model.recordHistory(ctx, HistoryRestore, before, after); err != nil {
+		return nil, err
+	}
+	return model.decodeDocument(ctx, after[0])
+}
+
+// historyFilter filter of the revisions of a document. Query scopes of the model are applied so a scope error,
+// such as ErrNoTenant, aborts the read, and revisions of a tenant model are filtered by the tenant of ctx
+func (model *Model) historyFilter(ctx context.Context, documentID interface{}) (bson.M, error) {
+	if _, err := model.scopeFilter(ctx, nil); err != nil {
+		return nil, err
+	}
+	filter := bson.M{"documentId": documentID}
+	if len(model.tagged[tenantTag]) > 0 {
+		tenant, err := requireTenant(ctx)
+		if err != nil {
+			return nil, err
+		}
+		filter["tenant"] = tenant
+	}
+	return filter, nil
+}
+
+// historyTargets load the documents matched by filter before a write, and narrow filter to them,
+// so the recorded documents are the written ones. filter is returned as it is if history is not enabled
+func (model *Model) historyTargets(ctx context.Context, filter interface{}, multi bool) ([]bson.Raw, interface{}, error) {
+	if model.history == nil {
+		return nil, filter, nil
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+	findOptions := options.Find()
+	if !multi {
+		findOptions.SetLimit(1)
+	}
+	before, err := model.findRaw(ctx, filter, findOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := model.documentIDs(before)
+	return before, bson.M{"$and": bson.A{filter, bson.M{model.primaryKey: bson.M{"$in": ids}}}}, nil
+}
+
+// historyDocuments load the documents written by a write to record them
+func (model *Model) historyDocuments(ctx context.Context, ids bson.A) ([]bson.Raw, error) {
+	if model.history == nil || len(ids) == 0 {
+		return nil, nil
+	}
+	return model.findRaw(ctx, bson.M{model.primaryKey: bson.M{"$in": ids}}, options.Find())
+}
+
+// bulkWrite run a bulk write of write models on the model collection, and record the documents it wrote if history
+// is enabled. Documents matched by the filters of update, replace and delete models are read before the write, inserted
+// and upserted documents by id after it, so each written document gets one revision from its state before the bulk
+// write to its state after it
+func (model *Model) bulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	if model.history == nil {
+		return model.collection.BulkWrite(ctx, models, opts...)
+	}
+	before, ids, err := model.bulkHistoryTargets(ctx, models)
+	if err != nil {
+		return nil, err
+	}
+	result, writeErr := model.collection.BulkWrite(ctx, models, opts...)
+	var upserted bson.A
+	if result != nil {
+		for _, id := range result.UpsertedIDs {
+			upserted = append(upserted, id)
+		}
+	}
+	// writes before a failed write of an ordered bulk are recorded too
+	if err := model.recordBulkHistory(ctx, before, ids, upserted); err != nil && writeErr == nil {
+		return result, err
+	}
+	return result, writeErr
+}
+
+// bulkHistoryTargets load the documents matched by the filters of write models, and the primary keys of
+// the matched and inserted documents
+func (model *Model) bulkHistoryTargets(ctx context.Context, models []mongo.WriteModel) ([]bson.Raw, bson.A, error) {
+	var before []bson.Raw
+	var ids bson.A
+	for _, writeModel := range models {
+		var filter interface{}
+		multi := false
+		switch m := writeModel.(type) {
+		case *mongo.InsertOneModel:
+			doc, err := toDocument(m.Document)
+			if err != nil {
+				return nil, nil, err
+			}
+			if id, ok := lookupValue(doc, model.primaryKey); ok {
+				ids = append(ids, id)
+			}
+			continue
+		case *mongo.UpdateOneModel:
+			filter = m.Filter
+		case *mongo.UpdateManyModel:
+			filter, multi = m.Filter, true
+		case *mongo.ReplaceOneModel:
+			filter = m.Filter
+		case *mongo.DeleteOneModel:
+			filter = m.Filter
+		case *mongo.DeleteManyModel:
+			filter, multi = m.Filter, true
+		default:
+			continue
+		}
+		docs, _, err := model.historyTargets(ctx, filter, multi)
+		if err != nil {
+			return nil, nil, err
+		}
+		before = append(before, docs...)
+		ids = append(ids, model.documentIDs(docs)...)
+	}
+	return before, ids, nil
+}
+
+// recordBulkHistory record the documents of ids and upserted _id after a bulk write against their state in before,
+// documents only in before were deleted and documents not in before were inserted
+func (model *Model) recordBulkHistory(ctx context.Context, before []bson.Raw, ids bson.A, upserted bson.A) error {
+	after, err := model.historyDocuments(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if len(upserted) > 0 {
+		docs, err := model.findRaw(ctx, bson.M{"_id": bson.M{"$in": upserted}}, options.Find())
+		if err != nil {
+			return err
+		}
+		after = append(after, docs...)
+	}
+	afterByID := map[string]bson.Raw{}
+	for _, doc := range after {
+		afterByID[doc.Lookup(model.primaryKey).String()] = doc
+	}
+	var updatedBefore, updatedAfter, inserted, deleted []bson.Raw
+	seen := map[string]bool{}
+	for _, doc := range before {
+		key := doc.Lookup(model.primaryKey).String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if afterDoc, ok := afterByID[key]; ok {
+			updatedBefore, updatedAfter = append(updatedBefore, doc), append(updatedAfter, afterDoc)
+		} else {
+			deleted = append(deleted, doc)
+		}
+	}
+	for key, doc := range afterByID {
+		if !seen[key] {
+			inserted = append(inserted, doc)
+		}
+	}
+	if err := model.recordHistory(ctx, HistoryUpdate, updatedBefore, updatedAfter); err != nil {
+		return err
+	}
+	if err := model.recordHistory(ctx, HistoryInsert, nil, inserted); err != nil {
+		return err
+	}
+	return model.recordHistory(ctx, HistoryDelete, deleted, nil)
+}
+
+func (model *Model) findRaw(ctx context.Context, filter interface{}, findOptions *options.FindOptions) ([]bson.Raw, error) {
+	cur, err := model.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, model.translateError(err)
+	}
+	defer cur.Close(ctx)
+	var docs []bson.Raw
+	for cur.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cur.Raw()...))
+	}
+	return docs, cur.Err()
+}
+
+// documentIDs primary keys of raw documents
+func (model *Model) documentIDs(docs []bson.Raw) bson.A {
+	ids := make(bson.A, 0, len(docs))
+	for _, doc := range docs {
+		var id interface{}
+		if err := doc.Lookup(model.primaryKey).Unmarshal(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// recordHistory write a revision for each document in before or after, documents are paired by primary key.
+// Updates which changed nothing are not recorded
+func (model *Model) recordHistory(ctx context.Context, operation HistoryOperation, before []bson.Raw, after []bson.Raw) error {
+	if model.history == nil {
+		return nil
+	}
+	afterByID := map[string]bson.Raw{}
+	for _, doc := range after {
+		afterByID[doc.Lookup(model.primaryKey).String()] = doc
+	}
+	recorded := map[string]bool{}
+	record := func(beforeDoc bson.Raw, afterDoc bson.Raw) error {
+		doc := afterDoc
+		if doc == nil {
+			doc = beforeDoc
+		}
+		key := doc.Lookup(model.primaryKey).String()
+		recorded[key] = true
+		changes, err := diffDocuments(beforeDoc, afterDoc)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 && (operation == HistoryUpdate || operation == HistorySoftDelete) {
+			return nil
+		}
+		var id interface{}
+		if err := doc.Lookup(model.primaryKey).Unmarshal(&id); err != nil {
+			return err
+		}
+		var tenant string
+		for _, field := range model.tagged[tenantTag] {
+			tenant, _ = doc.Lookup(field.BsonName).StringValueOK()
+		}
+		return model.insertRevision(ctx, Revision{
+			ID:         primitive.NewObjectID(),
+			DocumentID: id,
+			Operation:  operation,
+			Actor:      model.history.actor(ctx),
+			Tenant:     tenant,
+			Timestamp:  time.Now(),
+			Changes:    changes,
+			Document:   afterDoc,
+		})
+	}
+	for _, doc := range before {
+		afterDoc := afterByID[doc.Lookup(model.primaryKey).String()]
+		if afterDoc == nil && operation != HistoryDelete {
+			// the document was deleted by someone else between the write and the read
+			continue
+		}
+		if err := record(doc, afterDoc); err != nil {
+			return fmt.Errorf("goose: write history of %s: %w", model.collectionName, err)
+		}
+	}
+	for _, doc := range after {
+		if recorded[doc.Lookup(model.primaryKey).String()] {
+			continue
+		}
+		if err := record(nil, doc); err != nil {
+			return fmt.Errorf("goose: write history of %s: %w", model.collectionName, err)
+		}
+	}
+	return nil
+}
+
+// insertRevision number the revision after the last one of the document, retry if a concurrent write took the number
+func (model *Model) insertRevision(ctx context.Context, revision Revision) error {
+	const attempts = 5
+	var err error
+	for i := 0; i < attempts; i++ {
+		revision.Revision, err = model.lastRevision(ctx, revision.DocumentID)
+		if err != nil {
+			return err
+		}
+		revision.Revision++
+		_, err = model.history.collection.InsertOne(ctx, revision)
+		if _, duplicate := duplicateKeyMessage(err); !duplicate {
+			return err
+		}
+	}
+	return err
+}
+
+func (model *Model) lastRevision(ctx context.Context, documentID interface{}) (int64, error) {
+	cur, err := model.history.collection.Find(ctx, bson.M{"documentId": documentID},
+		options.Find().SetSort(bson.D{{Key: "revision", Value: -1}}).SetLimit(1))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	if !cur.Next(ctx) {
+		return 0, cur.Err()
+	}
+	var last Revision
+	if err := cur.Decode(&last); err != nil {
+		return 0, err
+	}
+	return last.Revision, nil
+}
+
+// diffDocuments compare top level fields of two documents, either can be nil
+func diffDocuments(before bson.Raw, after bson.Raw) ([]HistoryChange, error) {
+	var beforeElements, afterElements []bson.RawElement
+	var err error
+	if before != nil {
+		if beforeElements, err = before.Elements(); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if afterElements, err = after.Elements(); err != nil {
+			return nil, err
+		}
+	}
+	changes := []HistoryChange{}
+	for _, element := range beforeElements {
+		value, err := after.LookupErr(element.Key())
+		if err != nil {
+			changes = append(changes, HistoryChange{Field: element.Key(), Before: element.Value()})
+			continue
+		}
+		if value.Type != element.Value().Type || !bytes.Equal(value.Value, element.Value().Value) {
+			changes = append(changes, HistoryChange{Field: element.Key(), Before: element.Value(), After: value})
+		}
+	}
+	for _, element := range afterElements {
+		if _, err := before.LookupErr(element.Key()); err != nil {
+			changes = append(changes, HistoryChange{Field: element.Key(), After: element.Value()})
+		}
+	}
+	return changes, nil
+}
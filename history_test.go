@@ -0,0 +1,225 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestHistory(t *testing.T) {
+	type Contract struct {
+		ID        int64     `goose:"primary" bson:"_id"`
+		Customer  string    `bson:"customer"`
+		Amount    int       `bson:"amount"`
+		DeletedAt time.Time `goose:"deletedAt" bson:"deletedAt,omitempty"`
+	}
+	NewMemoryDatabase()
+	model, err := NewModel("contracts", &Contract{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.Revisions(context.Background(), 1); err != errNoHistory {
+		t.Fatalf("revisions without history: %v", err)
+	}
+	if err := model.EnableHistory(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithActor(context.Background(), "alice")
+
+	if _, err := model.InsertOne(ctx, &Contract{ID: 1, Customer: "acme", Amount: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.InsertOne(ctx, &Contract{ID: 2, Customer: "globex", Amount: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.FindOneByIDAndUpdate(WithActor(ctx, "bob"), 1, bson.M{"amount": 120}); err != nil {
+		t.Fatal(err)
+	}
+	// nothing changed, not recorded
+	if _, err := model.FindOneByIDAndUpdate(ctx, 1, bson.M{"amount": 120}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.UpdateMany(ctx, bson.M{}, bson.M{"$inc": bson.M{"amount": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.SoftDeleteOne(ctx, bson.M{"_id": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.DeleteOneByID(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := model.Revisions(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	operations := []HistoryOperation{HistoryInsert, HistoryUpdate, HistoryUpdate, HistorySoftDelete, HistoryDelete}
+	if len(revisions) != len(operations) {
+		t.Fatalf("expected %d revisions, got %+v", len(operations), revisions)
+	}
+	for i, revision := range revisions {
+		if revision.Revision != int64(i+1) || revision.Operation != operations[i] {
+			t.Errorf("revision %d: %d %s, expected %s", i, revision.Revision, revision.Operation, operations[i])
+		}
+	}
+	update := revisions[1]
+	if update.Actor != "bob" || len(update.Changes) != 1 || update.Changes[0].Field != "amount" ||
+		update.Changes[0].Before != int32(100) || update.Changes[0].After != int32(120) {
+		t.Errorf("unexpected update revision %+v", update)
+	}
+	if revisions[3].Changes[0].Field != "deletedAt" || revisions[3].Changes[0].Before != nil {
+		t.Errorf("unexpected soft delete changes %+v", revisions[3].Changes)
+	}
+	if revisions[4].Document != nil || len(revisions[4].Changes) != 4 {
+		t.Errorf("unexpected delete revision %+v", revisions[4])
+	}
+	if others, _ := model.Revisions(ctx, 2); len(others) != 2 {
+		t.Errorf("expected insert and update revisions of contract 2, got %+v", others)
+	}
+
+	if _, err := model.RestoreRevision(ctx, 1, 5); err == nil {
+		t.Error("expected error restoring a delete revision")
+	}
+	doc, err := model.RestoreRevision(WithActor(context.Background(), "carol"), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contract := doc.Value().(*Contract); contract.Amount != 120 || !contract.DeletedAt.IsZero() {
+		t.Errorf("unexpected restored contract %+v", contract)
+	}
+	revisions, _ = model.Revisions(ctx, 1)
+	if last := revisions[len(revisions)-1]; last.Revision != 6 || last.Operation != HistoryRestore || last.Actor != "carol" {
+		t.Errorf("unexpected restore revision %+v", last)
+	}
+}
+
+func TestHistoryTenant(t *testing.T) {
+	type Lease struct {
+		ID     int64  `goose:"primary" bson:"_id"`
+		Tenant string `goose:"tenant" bson:"tenant"`
+		Rent   int    `bson:"rent"`
+	}
+	NewMemoryDatabase()
+	model, err := NewModel("leases", &Lease{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.EnableHistory(nil); err != nil {
+		t.Fatal(err)
+	}
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+	if _, err := model.InsertOne(acme, &Lease{ID: 1, Rent: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.FindOneByIDAndUpdate(acme, 1, bson.M{"rent": 110}); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := model.Revisions(acme, 1)
+	if err != nil || len(revisions) != 2 || revisions[0].Tenant != "acme" {
+		t.Fatalf("expected 2 revisions of acme, got %+v %v", revisions, err)
+	}
+	if revisions, err := model.Revisions(globex, 1); err != nil || len(revisions) != 0 {
+		t.Errorf("expected revisions of acme to be hidden, got %+v %v", revisions, err)
+	}
+	if _, err := model.Revisions(context.Background(), 1); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+	if _, err := model.RestoreRevision(globex, 1, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected revision of acme not to be found, got %v", err)
+	}
+	if _, err := model.RestoreRevision(context.Background(), 1, 1); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+	doc, err := model.RestoreRevision(acme, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease := doc.Value().(*Lease); lease.Rent != 100 || lease.Tenant != "acme" {
+		t.Errorf("unexpected restored lease %+v", lease)
+	}
+}
+
+func TestHistoryPrimaryKey(t *testing.T) {
+	type Coupon struct {
+		Code     string `goose:"primary" bson:"code"`
+		Discount int    `bson:"discount"`
+	}
+	NewMemoryDatabase()
+	model, err := NewModel("coupons", &Coupon{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.EnableHistory(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := model.InsertOne(ctx, &Coupon{Code: "a1", Discount: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.FindOneByIDAndUpdate(ctx, "a1", bson.M{"discount": 20}); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := model.Revisions(ctx, "a1")
+	if err != nil || len(revisions) != 2 || revisions[0].Operation != HistoryInsert || revisions[0].DocumentID != "a1" {
+		t.Errorf("expected insert and update revisions of a1, got %+v %v", revisions, err)
+	}
+}
+
+func TestHistoryBulkWrite(t *testing.T) {
+	type Ticket struct {
+		ID    int64  `goose:"primary" bson:"_id"`
+		State string `bson:"state"`
+	}
+	NewMemoryDatabase()
+	model, err := NewModel("historyTickets", &Ticket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.EnableHistory(nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := model.InsertOne(ctx, &Ticket{ID: 1, State: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.InsertOne(ctx, &Ticket{ID: 2, State: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.BulkWrite(ctx, []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(&Ticket{ID: 3, State: "new"}),
+		mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": int64(1)}).SetUpdate(bson.M{"$set": bson.M{"state": "closed"}}),
+		mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": int64(1)}).SetUpdate(bson.M{"$set": bson.M{"state": "archived"}}),
+		mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": int64(2)}),
+		mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": int64(4)}).SetUpdate(bson.M{"$set": bson.M{"state": "new"}}).SetUpsert(true),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for id, operations := range map[int64][]HistoryOperation{
+		1: {HistoryInsert, HistoryUpdate},
+		2: {HistoryInsert, HistoryDelete},
+		3: {HistoryInsert},
+		4: {HistoryInsert},
+	} {
+		revisions, err := model.Revisions(ctx, id)
+		if err != nil || len(revisions) != len(operations) {
+			t.Errorf("expected %d revisions of ticket %d, got %+v %v", len(operations), id, revisions, err)
+			continue
+		}
+		for i, operation := range operations {
+			if revisions[i].Operation != operation {
+				t.Errorf("expected revision %d of ticket %d to be %s, got %+v", i, id, operation, revisions[i])
+			}
+		}
+	}
+	// the update revision goes from the state before the bulk write to the state after it
+	if revisions, _ := model.Revisions(ctx, 1); len(revisions) == 2 &&
+		(revisions[1].Changes[0].Before != "open" || revisions[1].Changes[0].After != "archived") {
+		t.Errorf("unexpected bulk update changes %+v", revisions[1].Changes)
+	}
+}
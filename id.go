@@ -0,0 +1,238 @@
+package goose
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IDGenerator generate a new primary key value, the value will be converted to the primary key field type,
+// such as primitive.ObjectID to hex string, or UUID to string and primitive.Binary
+type IDGenerator func() interface{}
+
+// built-in id generator names for `goose:"primary,gen=NAME"`
+const (
+	ObjectIDGenerator = "objectid"
+	UUIDGenerator     = "uuid"
+	UUIDv4Generator   = "uuidv4"
+	UUIDv7Generator   = "uuidv7"
+	ULIDGenerator     = "ulid"
+)
+
+var (
+	idGeneratorsMu sync.RWMutex
+	idGenerators   = map[string]IDGenerator{
+		ObjectIDGenerator: func() interface{} { return primitive.NewObjectID() },
+		UUIDGenerator:     func() interface{} { return newUUIDv4() },
+		UUIDv4Generator:   func() interface{} { return newUUIDv4() },
+		UUIDv7Generator:   func() interface{} { return newUUIDv7() },
+		ULIDGenerator:     func() interface{} { return newULID() },
+	}
+)
+
+var (
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	binaryType   = reflect.TypeOf(primitive.Binary{})
+)
+
+// RegisterIDGenerator register a custom id generator which can be used by `goose:"primary,gen=NAME"`
+func RegisterIDGenerator(name string, generator IDGenerator) {
+	idGeneratorsMu.Lock()
+	defer idGeneratorsMu.Unlock()
+	idGenerators[name] = generator
+}
+
+func getIDGenerator(name string) (IDGenerator, bool) {
+	idGeneratorsMu.RLock()
+	defer idGeneratorsMu.RUnlock()
+	generator, ok := idGenerators[name]
+	return generator, ok
+}
+
+// UUID a RFC 4122 UUID produced by uuid generators
+type UUID [16]byte
+
+func (u UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+func parseUUID(s string) (UUID, error) {
+	var u UUID
+	raw := strings.ReplaceAll(s, "-", "")
+	if len(raw) != 32 {
+		return u, fmt.Errorf("invalid UUID length %d", len(s))
+	}
+	if _, err := hex.Decode(u[:], []byte(raw)); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+func newUUIDv4() UUID {
+	var u UUID
+	_, _ = rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u
+}
+
+func newUUIDv7() UUID {
+	var u UUID
+	_, _ = rand.Read(u[6:])
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	u[0] = byte(ms >> 40)
+	u[1] = byte(ms >> 32)
+	u[2] = byte(ms >> 24)
+	u[3] = byte(ms >> 16)
+	u[4] = byte(ms >> 8)
+	u[5] = byte(ms)
+	u[6] = (u[6] & 0x0f) | 0x70
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID return a 26 characters ULID string, 48 bits millisecond timestamp and 80 bits randomness
+func newULID() string {
+	var id [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	_, _ = rand.Read(id[6:])
+
+	// encode 128 bits into 26 base32 characters, the first character only holds 3 bits
+	hi := binary.BigEndian.Uint64(id[0:8])
+	lo := binary.BigEndian.Uint64(id[8:16])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// convertID convert id in native go type to the primary key field type t
+func convertID(id interface{}, t reflect.Type) (interface{}, error) {
+	if id == nil {
+		return nil, invalidIDError(id, nil)
+	}
+	value := reflect.ValueOf(id)
+	if value.Type() == t {
+		return id, nil
+	}
+	if t == nil {
+		return id, nil
+	}
+	switch {
+	case t == objectIDType:
+		if s, ok := id.(string); ok {
+			objectID, err := primitive.ObjectIDFromHex(s)
+			if err != nil {
+				return nil, invalidIDError(id, err)
+			}
+			return objectID, nil
+		}
+	case t == binaryType:
+		u, ok := uuidBytes(id)
+		if ok {
+			return primitive.Binary{Subtype: bsontype.BinaryUUID, Data: u[:]}, nil
+		}
+	case t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8 && t.Len() == 16:
+		u, ok := uuidBytes(id)
+		if ok {
+			return reflect.ValueOf(u).Convert(t).Interface(), nil
+		}
+	case t.Kind() == reflect.String:
+		switch v := id.(type) {
+		case primitive.ObjectID:
+			return reflect.ValueOf(v.Hex()).Convert(t).Interface(), nil
+		case fmt.Stringer:
+			return reflect.ValueOf(v.String()).Convert(t).Interface(), nil
+		}
+		if value.Kind() == reflect.String {
+			return value.Convert(t).Interface(), nil
+		}
+	case isIntKind(t.Kind()) || isUintKind(t.Kind()):
+		if value.Kind() == reflect.String {
+			n, err := strconv.ParseInt(value.String(), 10, 64)
+			if err != nil {
+				return nil, invalidIDError(id, err)
+			}
+			value = reflect.ValueOf(n)
+		}
+		if isIntKind(value.Kind()) || isUintKind(value.Kind()) {
+			converted := value.Convert(t)
+			if !reflect.DeepEqual(converted.Convert(value.Type()).Interface(), value.Interface()) {
+				return nil, invalidIDError(id, fmt.Errorf("overflows %s", t))
+			}
+			return converted.Interface(), nil
+		}
+	case t.Kind() == reflect.Struct:
+		// composite primary key
+		if value.Type().ConvertibleTo(t) {
+			return value.Convert(t).Interface(), nil
+		}
+	}
+	return nil, invalidIDError(id, fmt.Errorf("can not convert %T to %s", id, t))
+}
+
+func uuidBytes(id interface{}) (UUID, bool) {
+	switch v := id.(type) {
+	case UUID:
+		return v, true
+	case [16]byte:
+		return v, true
+	case primitive.Binary:
+		var u UUID
+		if len(v.Data) != len(u) {
+			return u, false
+		}
+		copy(u[:], v.Data)
+		return u, true
+	case string:
+		u, err := parseUUID(v)
+		return u, err == nil
+	}
+	value := reflect.ValueOf(id)
+	if value.Kind() == reflect.Array && value.Type().Elem().Kind() == reflect.Uint8 && value.Len() == 16 {
+		return value.Convert(reflect.TypeOf(UUID{})).Interface().(UUID), true
+	}
+	return UUID{}, false
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUintKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
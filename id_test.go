@@ -0,0 +1,100 @@
+package goose
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Slug string
+
+type CompositeKey struct {
+	Tenant string `bson:"tenant"`
+	Number int64  `bson:"number"`
+}
+
+func TestConvertID(t *testing.T) {
+	objectID := primitive.NewObjectID()
+	uuid := newUUIDv4()
+	cases := []struct {
+		name string
+		id   interface{}
+		t    reflect.Type
+		want interface{}
+	}{
+		{"object id from hex", objectID.Hex(), objectIDType, objectID},
+		{"object id", objectID, objectIDType, objectID},
+		{"string", "my-post", reflect.TypeOf(""), "my-post"},
+		{"named string", "my-post", reflect.TypeOf(Slug("")), Slug("my-post")},
+		{"string from object id", objectID, reflect.TypeOf(""), objectID.Hex()},
+		{"int64 from int", 42, reflect.TypeOf(int64(0)), int64(42)},
+		{"int32 from string", "42", reflect.TypeOf(int32(0)), int32(42)},
+		{"uuid string", uuid, reflect.TypeOf(""), uuid.String()},
+		{"uuid array from string", uuid.String(), reflect.TypeOf([16]byte{}), [16]byte(uuid)},
+		{"uuid binary", uuid.String(), binaryType, primitive.Binary{Subtype: 4, Data: uuid[:]}},
+		{"composite", CompositeKey{"a", 1}, reflect.TypeOf(CompositeKey{}), CompositeKey{"a", 1}},
+	}
+	for _, c := range cases {
+		got, err := convertID(c.id, c.t)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestConvertInvalidID(t *testing.T) {
+	cases := []struct {
+		id interface{}
+		t  reflect.Type
+	}{
+		{"not-hex", objectIDType},
+		{"abc", reflect.TypeOf(int64(0))},
+		{300, reflect.TypeOf(int8(0))},
+		{12, reflect.TypeOf("")},
+		{nil, objectIDType},
+	}
+	for _, c := range cases {
+		if _, err := convertID(c.id, c.t); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("convert %#v to %s: expected ErrInvalidID, got %v", c.id, c.t, err)
+		}
+	}
+}
+
+func TestIDGenerators(t *testing.T) {
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-([47])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if v := newUUIDv4().String(); uuidPattern.FindStringSubmatch(v) == nil || v[14] != '4' {
+		t.Errorf("invalid uuid v4 %s", v)
+	}
+	if v := newUUIDv7().String(); uuidPattern.FindStringSubmatch(v) == nil || v[14] != '7' {
+		t.Errorf("invalid uuid v7 %s", v)
+	}
+	if v := newULID(); !regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`).MatchString(v) {
+		t.Errorf("invalid ulid %s", v)
+	}
+}
+
+func TestEnsureID(t *testing.T) {
+	type Article struct {
+		ID    string `goose:"primary,gen=ulid" bson:"_id"`
+		Title string `bson:"title"`
+	}
+	article := &Article{}
+	s := mustSchema(reflect.TypeOf(article).Elem())
+	if err := s.ensureID(article); err != nil {
+		t.Fatal(err)
+	}
+	if len(article.ID) != 26 {
+		t.Errorf("expected ulid id, got %q", article.ID)
+	}
+	id := article.ID
+	if err := s.ensureID(article); err != nil || article.ID != id {
+		t.Errorf("id should not be regenerated, got %q, %v", article.ID, err)
+	}
+}
@@ -0,0 +1,152 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// idIndexName the index of _id, it can not be dropped
+const idIndexName = "_id_"
+
+// Index an index of a collection
+type Index struct {
+	Name   string
+	Keys   bson.D
+	Unique bool
+}
+
+// String format index like `email_1 {"email":1} unique`
+func (index Index) String() string {
+	s := index.Name + " " + extJSONValue(index.Keys)
+	if index.Unique {
+		s += " unique"
+	}
+	return s
+}
+
+// IndexAction action of an index change
+type IndexAction string
+
+// index change actions
+const (
+	IndexCreate IndexAction = "create"
+	IndexDrop   IndexAction = "drop"
+)
+
+// IndexChange a change making collection indexes match the indexes declared by struct tags
+type IndexChange struct {
+	Collection string
+	Action     IndexAction
+	Index      Index
+}
+
+// String format change like `create users.email_1 {"email":1} unique`
+func (change IndexChange) String() string {
+	return fmt.Sprintf("%s %s.%s", change.Action, change.Collection, change.Index)
+}
+
+// declaredIndexes indexes declared by `index` and `unique` tags, named like the mongo default names
+func (s *schema) declaredIndexes() []Index {
+	indexes := make([]Index, 0, len(s.indexes)+len(s.uniqueIndexes))
+	for _, field := range s.indexes {
+		indexes = append(indexes, Index{Name: field.BsonName + "_1", Keys: bson.D{{Key: field.BsonName, Value: int32(1)}}})
+	}
+	for _, field := range s.uniqueIndexes {
+		indexes = append(indexes, Index{Name: field.BsonName + "_1", Keys: bson.D{{Key: field.BsonName, Value: int32(1)}}, Unique: true})
+	}
+	return indexes
+}
+
+func (index Index) model() mongo.IndexModel {
+	indexOptions := options.Index().SetName(index.Name)
+	if index.Unique {
+		indexOptions.SetUnique(true)
+	}
+	return mongo.IndexModel{Keys: index.Keys, Options: indexOptions}
+}
+
+// sameIndex compare keys and uniqueness, key directions are compared as numbers
+func sameIndex(a Index, b Index) bool {
+	if a.Unique != b.Unique || len(a.Keys) != len(b.Keys) {
+		return false
+	}
+	for i := range a.Keys {
+		if a.Keys[i].Key != b.Keys[i].Key || compareValues(a.Keys[i].Value, b.Keys[i].Value) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncIndexes make the indexes of a collection match the `index` and `unique` tags of value, a pointer to the model struct,
+// and the indexes added by plugins of its model.
+// Missing indexes are created, indexes not declared by tags are dropped, changed indexes are dropped and created again.
+// With dryRun, the changes are returned without being applied
+func (d *Database) SyncIndexes(ctx context.Context, collectionName string, value interface{}, dryRun bool) ([]IndexChange, error) {
+	t := reflect.TypeOf(value)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("goose: model value must be a pointer to struct, got %T", value)
+	}
+	s, err := getSchema(t.Elem())
+	if err != nil {
+		return nil, err
+	}
+	coll := d.collection(collectionName)
+	existing, err := coll.ListIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	declared := s.declaredIndexes()
+	if model, ok := registeredModel(s.typ); ok && model.collectionName == collectionName {
+		declared = model.indexes()
+	}
+
+	var changes []IndexChange
+	kept := map[string]bool{}
+	for _, index := range existing {
+		if index.Name == idIndexName {
+			continue
+		}
+		for _, want := range declared {
+			if want.Name == index.Name && sameIndex(want, index) {
+				kept[index.Name] = true
+			}
+		}
+		if !kept[index.Name] {
+			changes = append(changes, IndexChange{Collection: collectionName, Action: IndexDrop, Index: index})
+		}
+	}
+	for _, index := range declared {
+		if !kept[index.Name] {
+			changes = append(changes, IndexChange{Collection: collectionName, Action: IndexCreate, Index: index})
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+	for _, change := range changes {
+		switch change.Action {
+		case IndexDrop:
+			err = coll.DropIndex(ctx, change.Index.Name)
+		case IndexCreate:
+			_, err = coll.CreateIndex(ctx, change.Index.model())
+		}
+		if err != nil {
+			return changes, fmt.Errorf("goose: %s: %w", change, err)
+		}
+	}
+	return changes, nil
+}
+
+// collection get a collection of the database
+func (d *Database) collection(name string) collection {
+	if d.backend != nil {
+		return d.backend.Collection(name)
+	}
+	return mongoCollection{d.DB.Collection(name)}
+}
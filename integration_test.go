@@ -0,0 +1,88 @@
+package goose_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pascallin/goose"
+	"github.com/pascallin/goose/goosetest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type integrationUser struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Email string             `goose:"unique" bson:"email" validate:"required"`
+	Age   int                `bson:"age,omitempty" validate:"omitempty,min=0,max=150"`
+}
+
+func TestMongoIntegration(t *testing.T) {
+	server := goosetest.StartServer(t, nil)
+
+	t.Run("ConnectUsingURL", func(t *testing.T) {
+		db, err := goose.NewMongoDatabase(&goose.DatabaseOptions{
+			DatabaseName: "test",
+			URL:          server.URL(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+	})
+
+	t.Run("ConnectUsingEnv", func(t *testing.T) {
+		t.Setenv("MONGODB_URL", server.URL())
+		t.Setenv("MONGODB_DATABASE", "test")
+		db, err := goose.NewMongoDatabase(&goose.DatabaseOptions{
+			UsingEnv: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+	})
+
+	// each subtest gets an empty database
+	for _, name := range []string{"FirstDatabase", "SecondDatabase"} {
+		t.Run(name, func(t *testing.T) {
+			server.NewDatabase(t)
+			ctx := context.Background()
+			userModel, err := goose.NewModel("users", &integrationUser{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := userModel.InsertOne(ctx, &integrationUser{Email: "a@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			result, err := userModel.FindAndCount(ctx, bson.M{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != 1 {
+				t.Errorf("expected 1 user in a new database, got %d", result.Total)
+			}
+		})
+	}
+
+	t.Run("ApplySchema", func(t *testing.T) {
+		db := server.NewDatabase(t)
+		ctx := context.Background()
+		userModel, err := goose.NewModel("users", &integrationUser{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userModel.ApplySchema(ctx, goose.ValidationLevelStrict, goose.ValidationActionError); err != nil {
+			t.Fatal(err)
+		}
+		// writes bypassing goose are validated by the server
+		if _, err := db.DB.Collection("users").InsertOne(ctx, bson.M{"email": "a@example.com", "age": 200}); err == nil {
+			t.Error("expected document validation error")
+		}
+		if _, err := db.DB.Collection("users").InsertOne(ctx, bson.M{"email": "b@example.com", "age": 20}); err != nil {
+			t.Error(err)
+		}
+		if err := userModel.ApplySchema(ctx, goose.ValidationLevelModerate, goose.ValidationActionWarn); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
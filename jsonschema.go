@@ -0,0 +1,387 @@
+package goose
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ValidationLevel which documents the server validates, see collMod validationLevel
+type ValidationLevel string
+
+// validation levels
+const (
+	ValidationLevelOff      ValidationLevel = "off"
+	ValidationLevelStrict   ValidationLevel = "strict"
+	ValidationLevelModerate ValidationLevel = "moderate"
+)
+
+// ValidationAction what the server does with invalid documents, see collMod validationAction
+type ValidationAction string
+
+// validation actions
+const (
+	ValidationActionError ValidationAction = "error"
+	ValidationActionWarn  ValidationAction = "warn"
+)
+
+const validateTagName = "validate"
+
+// mongo error code of a missing collection
+const namespaceNotFoundCode = 26
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	dateTimeType   = reflect.TypeOf(primitive.DateTime(0))
+	decimalType    = reflect.TypeOf(primitive.Decimal128{})
+	regexType      = reflect.TypeOf(primitive.Regex{})
+	timestampType  = reflect.TypeOf(primitive.Timestamp{})
+	documentType   = reflect.TypeOf(bson.D{})
+	rawType        = reflect.TypeOf(bson.Raw{})
+	arrayType      = reflect.TypeOf(bson.A{})
+	emptyInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// JSONSchema derive a $jsonSchema document from the model struct.
+// Properties are named by bson tags and typed by BSON types, nested structs become subdocument schemas.
+// Rules of `validate` tags are converted: required, oneof to enum, min, max, len, gt, gte, lt and lte
+// to minimum/maximum, minLength/maxLength or minItems/maxItems by field type, rules after dive apply to items.
+// Unknown fields are allowed, other validate rules are only checked by goose
+func (model *Model) JSONSchema() bson.D {
+	return model.jsonSchema()
+}
+
+func (s *schema) jsonSchema() bson.D {
+	builder := &jsonSchemaBuilder{visiting: map[reflect.Type]bool{}}
+	return ensureRequired(builder.structSchema(s.typ), s.primaryKey)
+}
+
+// ApplySchema install JSONSchema as the collection validator by collMod, the collection is created if it does not exist
+func (model *Model) ApplySchema(ctx context.Context, level ValidationLevel, action ValidationAction) (err error) {
+	ctx, finish := model.startOperation(ctx, "ApplySchema")
+	defer func() { finish(err) }()
+	validator := bson.D{{Key: "$jsonSchema", Value: model.JSONSchema()}}
+	return model.collection.SetValidator(ctx, validator, string(level), string(action))
+}
+
+type jsonSchemaBuilder struct {
+	// struct types being built, recursive types are not expanded again
+	visiting map[reflect.Type]bool
+}
+
+// structSchema build the object schema of struct type t
+func (b *jsonSchemaBuilder) structSchema(t reflect.Type) bson.D {
+	schema := bson.D{{Key: "bsonType", Value: "object"}}
+	if b.visiting[t] {
+		return schema
+	}
+	b.visiting[t] = true
+	defer delete(b.visiting, t)
+
+	properties, required := b.properties(t)
+	if len(required) > 0 {
+		schema = append(schema, bson.E{Key: "required", Value: required})
+	}
+	if len(properties) > 0 {
+		schema = append(schema, bson.E{Key: "properties", Value: properties})
+	}
+	return schema
+}
+
+func (b *jsonSchemaBuilder) properties(t reflect.Type) (properties bson.D, required bson.A) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		bsonTags, err := bsoncodec.DefaultStructTagParser(field)
+		if err != nil || bsonTags.Skip {
+			continue
+		}
+		fieldType := field.Type
+		if bsonTags.Inline {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				inlineProperties, inlineRequired := b.properties(fieldType)
+				properties = append(properties, inlineProperties...)
+				required = append(required, inlineRequired...)
+			}
+			continue
+		}
+		rules, itemRules := splitValidateRules(field.Tag.Get(validateTagName))
+		schema := b.typeSchema(fieldType)
+		if hasRule(rules, "required") {
+			required = append(required, bsonTags.Name)
+			schema = withoutNull(schema)
+		}
+		schema = applyRules(schema, fieldType, rules)
+		if len(itemRules) > 0 {
+			schema = applyItemRules(schema, fieldType, itemRules)
+		}
+		properties = append(properties, bson.E{Key: bsonTags.Name, Value: schema})
+	}
+	return properties, required
+}
+
+// typeSchema the schema of a go type by the BSON type it is encoded to, nil-able types allow null
+func (b *jsonSchemaBuilder) typeSchema(t reflect.Type) bson.D {
+	switch t {
+	case timeType, dateTimeType:
+		return bsonTypeSchema("date")
+	case objectIDType:
+		return bsonTypeSchema("objectId")
+	case decimalType:
+		return bsonTypeSchema("decimal")
+	case binaryType:
+		return bsonTypeSchema("binData")
+	case regexType:
+		return bsonTypeSchema("regex")
+	case timestampType:
+		return bsonTypeSchema("timestamp")
+	case documentType, rawType:
+		return bsonTypeSchema("object", "null")
+	case arrayType:
+		return bsonTypeSchema("array", "null")
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return withNull(b.typeSchema(t.Elem()))
+	case reflect.Interface:
+		return bson.D{}
+	case reflect.String:
+		return bsonTypeSchema("string")
+	case reflect.Bool:
+		return bsonTypeSchema("bool")
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return bsonTypeSchema("int")
+	case reflect.Int64, reflect.Uint32, reflect.Uint64:
+		return bsonTypeSchema("long")
+	case reflect.Int, reflect.Uint:
+		// int is encoded as int32 if it fits
+		return bsonTypeSchema("int", "long")
+	case reflect.Float32, reflect.Float64:
+		return bsonTypeSchema("double")
+	case reflect.Struct:
+		return b.structSchema(t)
+	case reflect.Map:
+		schema := bsonTypeSchema("object", "null")
+		if t.Elem() != emptyInterface {
+			schema = append(schema, bson.E{Key: "additionalProperties", Value: b.typeSchema(t.Elem())})
+		}
+		return schema
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if t.Kind() == reflect.Slice {
+				return bsonTypeSchema("binData", "null")
+			}
+			return bsonTypeSchema("binData")
+		}
+		schema := bsonTypeSchema("array")
+		if t.Kind() == reflect.Slice {
+			schema = bsonTypeSchema("array", "null")
+		}
+		if t.Elem() != emptyInterface {
+			schema = append(schema, bson.E{Key: "items", Value: b.typeSchema(t.Elem())})
+		}
+		return schema
+	}
+	return bson.D{}
+}
+
+func bsonTypeSchema(types ...string) bson.D {
+	if len(types) == 1 {
+		return bson.D{{Key: "bsonType", Value: types[0]}}
+	}
+	value := make(bson.A, len(types))
+	for i, t := range types {
+		value[i] = t
+	}
+	return bson.D{{Key: "bsonType", Value: value}}
+}
+
+// withNull allow null in the bsonType of schema
+func withNull(schema bson.D) bson.D {
+	for i, e := range schema {
+		if e.Key != "bsonType" {
+			continue
+		}
+		switch types := e.Value.(type) {
+		case string:
+			schema[i].Value = bson.A{types, "null"}
+		case bson.A:
+			for _, t := range types {
+				if t == "null" {
+					return schema
+				}
+			}
+			schema[i].Value = append(types, "null")
+		}
+	}
+	return schema
+}
+
+// withoutNull remove null from the bsonType of schema, required fields must not be null
+func withoutNull(schema bson.D) bson.D {
+	for i, e := range schema {
+		types, ok := e.Value.(bson.A)
+		if e.Key != "bsonType" || !ok {
+			continue
+		}
+		var kept bson.A
+		for _, t := range types {
+			if t != "null" {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 1 {
+			schema[i].Value = kept[0]
+		} else {
+			schema[i].Value = kept
+		}
+	}
+	return schema
+}
+
+// ensureRequired add name to the required list of an object schema
+func ensureRequired(schema bson.D, name string) bson.D {
+	for i, e := range schema {
+		if e.Key != "required" {
+			continue
+		}
+		required := e.Value.(bson.A)
+		for _, r := range required {
+			if r == name {
+				return schema
+			}
+		}
+		schema[i].Value = append(bson.A{name}, required...)
+		return schema
+	}
+	return append(schema[:1:1], append(bson.D{{Key: "required", Value: bson.A{name}}}, schema[1:]...)...)
+}
+
+// splitValidateRules parse validate tag rules, rules after dive apply to items
+func splitValidateRules(tag string) (rules []string, itemRules []string) {
+	if tag == "" || tag == "-" {
+		return nil, nil
+	}
+	rules = strings.Split(tag, ",")
+	for i, rule := range rules {
+		if rule == "dive" {
+			return rules[:i], rules[i+1:]
+		}
+	}
+	return rules, nil
+}
+
+func hasRule(rules []string, name string) bool {
+	for _, rule := range rules {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRules convert validate rules of a field of type t to schema keywords
+func applyRules(schema bson.D, t reflect.Type, rules []string) bson.D {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, rule := range rules {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "oneof":
+			var values bson.A
+			for _, option := range strings.Fields(param) {
+				if value, ok := ruleValue(t, strings.Trim(option, "'")); ok {
+					values = append(values, value)
+				}
+			}
+			if len(values) > 0 {
+				schema = append(schema, bson.E{Key: "enum", Value: values})
+			}
+		case "min", "gte":
+			schema = appendBound(schema, t, param, "minimum", "minLength", "minItems", false)
+		case "max", "lte":
+			schema = appendBound(schema, t, param, "maximum", "maxLength", "maxItems", false)
+		case "gt":
+			schema = appendBound(schema, t, param, "minimum", "", "", true)
+		case "lt":
+			schema = appendBound(schema, t, param, "maximum", "", "", true)
+		case "len":
+			schema = appendBound(schema, t, param, "", "minLength", "minItems", false)
+			schema = appendBound(schema, t, param, "", "maxLength", "maxItems", false)
+		}
+	}
+	return schema
+}
+
+// appendBound append a bound keyword by the kind of t, numbers use number, strings use length and slices use items
+func appendBound(schema bson.D, t reflect.Type, param string, number string, length string, items string, exclusive bool) bson.D {
+	switch {
+	case t.Kind() == reflect.String && length != "":
+		if n, err := strconv.ParseInt(param, 10, 64); err == nil {
+			schema = append(schema, bson.E{Key: length, Value: n})
+		}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) && items != "":
+		if n, err := strconv.ParseInt(param, 10, 64); err == nil && t.Kind() != reflect.Map {
+			schema = append(schema, bson.E{Key: items, Value: n})
+		}
+	case (isIntKind(t.Kind()) || isUintKind(t.Kind()) || t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64) && number != "":
+		if value, ok := ruleValue(t, param); ok {
+			schema = append(schema, bson.E{Key: number, Value: value})
+			if exclusive {
+				schema = append(schema, bson.E{Key: "exclusive" + strings.ToUpper(number[:1]) + number[1:], Value: true})
+			}
+		}
+	}
+	return schema
+}
+
+// ruleValue parse a rule parameter as a value of type t
+func ruleValue(t reflect.Type, param string) (interface{}, bool) {
+	switch {
+	case isIntKind(t.Kind()) || isUintKind(t.Kind()):
+		n, err := strconv.ParseInt(param, 10, 64)
+		return n, err == nil
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(param, 64)
+		return f, err == nil
+	case t.Kind() == reflect.String:
+		return param, true
+	}
+	return nil, false
+}
+
+// applyItemRules apply rules after dive to the items schema of an array schema
+func applyItemRules(schema bson.D, t reflect.Type, itemRules []string) bson.D {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return schema
+	}
+	for i, e := range schema {
+		if e.Key == "items" {
+			items := e.Value.(bson.D)
+			if hasRule(itemRules, "required") {
+				items = withoutNull(items)
+			}
+			schema[i].Value = applyRules(items, t.Elem(), itemRules)
+			return schema
+		}
+	}
+	return schema
+}
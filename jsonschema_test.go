@@ -0,0 +1,69 @@
+package goose
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestJSONSchema(t *testing.T) {
+	type Address struct {
+		City string `bson:"city" validate:"required"`
+		Zip  string `bson:"zip,omitempty" validate:"len=5"`
+	}
+	type Account struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Name      string             `bson:"name" validate:"required,min=1,max=64"`
+		Role      string             `bson:"role" validate:"oneof=admin user"`
+		Age       int32              `bson:"age" validate:"gte=0,lt=150"`
+		Score     float64            `bson:"score"`
+		Tags      []string           `bson:"tags" validate:"max=3,dive,min=2"`
+		Address   Address            `bson:"address"`
+		Manager   *Address           `bson:"manager,omitempty"`
+		CreatedAt time.Time          `bson:"createdAt"`
+		Extra     map[string]int64   `bson:"extra"`
+		Any       interface{}        `bson:"any"`
+		Ignored   string             `bson:"-"`
+	}
+	NewMemoryDatabase()
+	model, err := NewModel("accounts", &Account{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := bson.D{
+		{Key: "bsonType", Value: "object"},
+		{Key: "required", Value: bson.A{"city"}},
+		{Key: "properties", Value: bson.D{
+			{Key: "city", Value: bson.D{{Key: "bsonType", Value: "string"}}},
+			{Key: "zip", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: int64(5)}, {Key: "maxLength", Value: int64(5)}}},
+		}},
+	}
+	manager := append(bson.D{{Key: "bsonType", Value: bson.A{"object", "null"}}}, address[1:]...)
+	want := bson.D{
+		{Key: "bsonType", Value: "object"},
+		{Key: "required", Value: bson.A{"_id", "name"}},
+		{Key: "properties", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "bsonType", Value: "objectId"}}},
+			{Key: "name", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: int64(1)}, {Key: "maxLength", Value: int64(64)}}},
+			{Key: "role", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "enum", Value: bson.A{"admin", "user"}}}},
+			{Key: "age", Value: bson.D{{Key: "bsonType", Value: "int"}, {Key: "minimum", Value: int64(0)}, {Key: "maximum", Value: int64(150)}, {Key: "exclusiveMaximum", Value: true}}},
+			{Key: "score", Value: bson.D{{Key: "bsonType", Value: "double"}}},
+			{Key: "tags", Value: bson.D{
+				{Key: "bsonType", Value: bson.A{"array", "null"}},
+				{Key: "items", Value: bson.D{{Key: "bsonType", Value: "string"}, {Key: "minLength", Value: int64(2)}}},
+				{Key: "maxItems", Value: int64(3)},
+			}},
+			{Key: "address", Value: address},
+			{Key: "manager", Value: manager},
+			{Key: "createdAt", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+			{Key: "extra", Value: bson.D{{Key: "bsonType", Value: bson.A{"object", "null"}}, {Key: "additionalProperties", Value: bson.D{{Key: "bsonType", Value: "long"}}}}},
+			{Key: "any", Value: bson.D{}},
+		}},
+	}
+	if got := model.JSONSchema(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+package goose
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger goose logger interface, keysAndValues are pairs of field name and value
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+var (
+	loggerMu     sync.RWMutex
+	globalLogger Logger = NewLogrusLogger(logrus.StandardLogger())
+)
+
+// SetLogger set global logger, it is used by databases which have no DatabaseOptions.Logger
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NewNopLogger()
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	globalLogger = logger
+}
+
+// GetLogger get global logger
+func GetLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return globalLogger
+}
+
+type nopLogger struct{}
+
+// NewNopLogger new a logger discarding everything
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+type logrusLogger struct {
+	logger logrus.FieldLogger
+}
+
+// NewLogrusLogger adapt a logrus logger or entry
+func NewLogrusLogger(logger logrus.FieldLogger) Logger {
+	return logrusLogger{logger: logger}
+}
+
+func (l logrusLogger) with(keysAndValues []interface{}) logrus.FieldLogger {
+	if len(keysAndValues) == 0 {
+		return l.logger
+	}
+	fields := logrus.Fields{}
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 < len(keysAndValues) {
+			fields[key] = keysAndValues[i+1]
+		} else {
+			fields[key] = nil
+		}
+	}
+	return l.logger.WithFields(fields)
+}
+
+func (l logrusLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.with(keysAndValues).Debug(msg)
+}
+
+func (l logrusLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.with(keysAndValues).Info(msg)
+}
+
+func (l logrusLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.with(keysAndValues).Warn(msg)
+}
+
+func (l logrusLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.with(keysAndValues).Error(msg)
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger adapt a log/slog logger
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+func (l slogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debug(msg, keysAndValues...)
+}
+
+func (l slogLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Info(msg, keysAndValues...)
+}
+
+func (l slogLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warn(msg, keysAndValues...)
+}
+
+func (l slogLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Error(msg, keysAndValues...)
+}
+
+// ZapSugaredLogger methods of *zap.SugaredLogger used by the zap adapter, so goose does not depend on zap
+type ZapSugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+type zapLogger struct {
+	logger ZapSugaredLogger
+}
+
+// NewZapLogger adapt a zap sugared logger, such as goose.NewZapLogger(zapLogger.Sugar())
+func NewZapLogger(logger ZapSugaredLogger) Logger {
+	return zapLogger{logger: logger}
+}
+
+func (l zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debugw(msg, keysAndValues...)
+}
+
+func (l zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Infow(msg, keysAndValues...)
+}
+
+func (l zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warnw(msg, keysAndValues...)
+}
+
+func (l zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Errorw(msg, keysAndValues...)
+}
@@ -0,0 +1,78 @@
+package goose
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type fakeSugaredLogger struct {
+	lines []string
+}
+
+func (l *fakeSugaredLogger) log(level string, msg string, keysAndValues ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(level, " ", msg, " ", keysAndValues))
+}
+
+func (l *fakeSugaredLogger) Debugw(msg string, kv ...interface{}) { l.log("debug", msg, kv...) }
+func (l *fakeSugaredLogger) Infow(msg string, kv ...interface{})  { l.log("info", msg, kv...) }
+func (l *fakeSugaredLogger) Warnw(msg string, kv ...interface{})  { l.log("warn", msg, kv...) }
+func (l *fakeSugaredLogger) Errorw(msg string, kv ...interface{}) { l.log("error", msg, kv...) }
+
+func TestLoggerAdapters(t *testing.T) {
+	var slogOutput bytes.Buffer
+	NewSlogLogger(slog.New(slog.NewTextHandler(&slogOutput, nil))).Warn("slow query", "collection", "TestPosts")
+	if !strings.Contains(slogOutput.String(), "slow query") || !strings.Contains(slogOutput.String(), "collection=TestPosts") {
+		t.Errorf("unexpected slog output %s", slogOutput.String())
+	}
+
+	var logrusOutput bytes.Buffer
+	logrusLogger := logrus.New()
+	logrusLogger.SetOutput(&logrusOutput)
+	NewLogrusLogger(logrusLogger).Error("close error", "message", "boom")
+	if !strings.Contains(logrusOutput.String(), "close error") || !strings.Contains(logrusOutput.String(), "message=boom") {
+		t.Errorf("unexpected logrus output %s", logrusOutput.String())
+	}
+
+	zap := &fakeSugaredLogger{}
+	NewZapLogger(zap).Info("connected", "database", "test")
+	if len(zap.lines) != 1 || zap.lines[0] != "info connected [database test]" {
+		t.Errorf("unexpected zap output %v", zap.lines)
+	}
+
+	NewNopLogger().Error("nothing")
+}
+
+func TestDatabaseLogger(t *testing.T) {
+	zap := &fakeSugaredLogger{}
+	_, err := NewMongoDatabase(&DatabaseOptions{Logger: NewZapLogger(zap)})
+	if err == nil {
+		t.Fatal("expected options validation error")
+	}
+	if len(zap.lines) != 1 || !strings.HasPrefix(zap.lines[0], "error database options not valid.") {
+		t.Errorf("database should log to its own logger, got %v", zap.lines)
+	}
+
+	var global bytes.Buffer
+	SetLogger(NewSlogLogger(slog.New(slog.NewTextHandler(&global, nil))))
+	defer SetLogger(NewLogrusLogger(logrus.StandardLogger()))
+	if (&Database{}).Logger() != GetLogger() {
+		t.Error("database without logger should use the global logger")
+	}
+}
+
+func TestInvalidTagReturnsError(t *testing.T) {
+	type Broken struct {
+		Count int64 `goose:"default=many" bson:"count"`
+	}
+	if _, err := NewModel("Broken", &Broken{}); err == nil || !strings.Contains(err.Error(), "Broken.Count") {
+		t.Errorf("expected invalid default error, got %v", err)
+	}
+	if _, err := NewModel("Broken", Broken{}); err == nil {
+		t.Error("expected error for non pointer model value")
+	}
+}
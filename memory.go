@@ -0,0 +1,724 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryDatabaseName database name of the in-memory database
+const MemoryDatabaseName = "memory"
+
+// mongo server error codes returned by the in-memory database
+const (
+	duplicateKeyCode   = 11000
+	immutableFieldCode = 66
+)
+
+// NewMemoryDatabase new an empty in-memory database for unit tests, models created after it store documents in memory.
+// It supports the query and update operators, sort, skip, limit, $lookup and unique indexes used by goose, but not transactions
+func NewMemoryDatabase() *Database {
+	db := &Database{
+		Context: context.Background(),
+		logger:  GetLogger(),
+		backend: newMemoryBackend(MemoryDatabaseName),
+	}
+	DB = nil
+	defaultDatabase = db
+	return db
+}
+
+// memoryBackend documents of all collections are guarded by one lock, so $lookup sees a consistent state
+type memoryBackend struct {
+	name        string
+	mu          sync.RWMutex
+	collections map[string]*memoryCollectionData
+}
+
+// memoryCollectionData documents in insertion order and indexes of a collection
+type memoryCollectionData struct {
+	docs    []bson.D
+	indexes []memoryIndex
+}
+
+type memoryIndex struct {
+	name   string
+	keys   bson.D
+	unique bool
+}
+
+func newMemoryBackend(name string) *memoryBackend {
+	return &memoryBackend{name: name, collections: map[string]*memoryCollectionData{}}
+}
+
+func (b *memoryBackend) Name() string {
+	return b.name
+}
+
+func (b *memoryBackend) Collection(name string) collection {
+	return &memoryCollection{backend: b, name: name}
+}
+
+// data get collection data, create it if not exists, the caller must hold the write lock if create is true
+func (b *memoryBackend) data(name string, create bool) *memoryCollectionData {
+	data, ok := b.collections[name]
+	if !ok && create {
+		data = &memoryCollectionData{
+			indexes: []memoryIndex{{name: "_id_", keys: bson.D{{Key: "_id", Value: int32(1)}}, unique: true}},
+		}
+		b.collections[name] = data
+	}
+	return data
+}
+
+// documents return the documents of a collection, the caller must hold the lock
+func (b *memoryBackend) documents(name string) []bson.D {
+	if data := b.data(name, false); data != nil {
+		return data.docs
+	}
+	return nil
+}
+
+// memoryCollection collection of the in-memory database
+type memoryCollection struct {
+	backend *memoryBackend
+	name    string
+}
+
+func (c *memoryCollection) Name() string {
+	return c.name
+}
+
+func (c *memoryCollection) Database() backend {
+	return c.backend
+}
+
+func (c *memoryCollection) InsertOne(ctx context.Context, document interface{}) (*mongo.InsertOneResult, error) {
+	doc, err := toDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	c.backend.mu.Lock()
+	defer c.backend.mu.Unlock()
+	id, err := c.insert(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+// insert add doc with a generated _id if it has none, the caller must hold the write lock
+func (c *memoryCollection) insert(doc bson.D) (interface{}, error) {
+	id, ok := lookupValue(doc, "_id")
+	if !ok {
+		id = primitive.NewObjectID()
+		doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+	}
+	data := c.backend.data(c.name, true)
+	if err := data.checkUnique(c.fullName(), doc, -1); err != nil {
+		return nil, err
+	}
+	data.docs = append(data.docs, doc)
+	return id, nil
+}
+
+func (c *memoryCollection) FindOne(ctx context.Context, filter interface{}) (bson.Raw, error) {
+	docs, err := c.findDocuments(filter, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return bson.Marshal(docs[0])
+}
+
+func (c *memoryCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (cursor, error) {
+	findOptions := options.MergeFindOptions(opts...)
+	var sort bson.D
+	if findOptions.Sort != nil {
+		var err error
+		if sort, err = toDocument(findOptions.Sort); err != nil {
+			return nil, err
+		}
+	}
+	docs, err := c.findDocuments(filter, sort, 0)
+	if err != nil {
+		return nil, err
+	}
+	if findOptions.Skip != nil {
+		docs = skipDocuments(docs, *findOptions.Skip)
+	}
+	if findOptions.Limit != nil && *findOptions.Limit != 0 {
+		docs = limitDocuments(docs, *findOptions.Limit)
+	}
+	if findOptions.Projection != nil {
+		projection, err := toDocument(findOptions.Projection)
+		if err != nil {
+			return nil, err
+		}
+		if docs, err = projectDocuments(docs, projection); err != nil {
+			return nil, err
+		}
+	}
+	return newMemoryCursor(docs)
+}
+
+// findDocuments return documents matching filter sorted by sort, at most limit documents if limit > 0
+func (c *memoryCollection) findDocuments(filter interface{}, sort bson.D, limit int) ([]bson.D, error) {
+	filterDocument, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.backend.mu.RLock()
+	defer c.backend.mu.RUnlock()
+	var docs []bson.D
+	for _, doc := range c.backend.documents(c.name) {
+		matched, err := matchDocument(doc, filterDocument)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			docs = append(docs, doc)
+		}
+		if limit > 0 && len(sort) == 0 && len(docs) == limit {
+			break
+		}
+	}
+	if len(sort) > 0 {
+		if err := sortDocuments(docs, sort); err != nil {
+			return nil, err
+		}
+		if limit > 0 && len(docs) > limit {
+			docs = docs[:limit]
+		}
+	}
+	return docs, nil
+}
+
+func (c *memoryCollection) Aggregate(ctx context.Context, pipeline interface{}) (cursor, error) {
+	stages, err := toPipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var output bson.D
+	if n := len(stages); n > 0 && (stages[n-1][0].Key == "$out" || stages[n-1][0].Key == "$merge") {
+		stages, output = stages[:n-1], stages[n-1]
+	}
+	if output != nil {
+		// the pipeline and its output see the same state
+		c.backend.mu.Lock()
+		defer c.backend.mu.Unlock()
+	} else {
+		c.backend.mu.RLock()
+		defer c.backend.mu.RUnlock()
+	}
+	docs := append([]bson.D(nil), c.backend.documents(c.name)...)
+	if docs, err = c.backend.aggregate(docs, stages); err != nil {
+		return nil, err
+	}
+	if output != nil {
+		if err := c.backend.writeOutput(docs, output); err != nil {
+			return nil, err
+		}
+		docs = nil
+	}
+	return newMemoryCursor(docs)
+}
+
+func (c *memoryCollection) CountDocuments(ctx context.Context, filter interface{}) (int64, error) {
+	docs, err := c.findDocuments(filter, nil, 0)
+	return int64(len(docs)), err
+}
+
+func (c *memoryCollection) EstimatedDocumentCount(ctx context.Context) (int64, error) {
+	c.backend.mu.RLock()
+	defer c.backend.mu.RUnlock()
+	return int64(len(c.backend.documents(c.name))), nil
+}
+
+func (c *memoryCollection) Distinct(ctx context.Context, field string, filter interface{}) ([]interface{}, error) {
+	docs, err := c.findDocuments(filter, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	values := []interface{}{}
+	add := func(v interface{}) {
+		for _, value := range values {
+			if compareValues(value, v) == 0 {
+				return
+			}
+		}
+		values = append(values, v)
+	}
+	for _, doc := range docs {
+		v, ok := lookupValue(doc, field)
+		if !ok {
+			continue
+		}
+		if array, isArray := v.(bson.A); isArray {
+			for _, item := range array {
+				add(item)
+			}
+			continue
+		}
+		add(v)
+	}
+	return values, nil
+}
+
+func (c *memoryCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) (bson.Raw, error) {
+	findOptions := options.MergeFindOneAndUpdateOptions(opts...)
+	var sort bson.D
+	if findOptions.Sort != nil {
+		var err error
+		if sort, err = toDocument(findOptions.Sort); err != nil {
+			return nil, err
+		}
+	}
+	upsert := findOptions.Upsert != nil && *findOptions.Upsert
+	returnAfter := findOptions.ReturnDocument != nil && *findOptions.ReturnDocument == options.After
+	before, after, _, err := c.updateDocuments(filter, update, sort, false, upsert)
+	if err != nil {
+		return nil, err
+	}
+	result := before
+	if returnAfter {
+		result = after
+	}
+	if len(result) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return bson.Marshal(result[0])
+}
+
+func (c *memoryCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.update(filter, update, false, opts...)
+}
+
+func (c *memoryCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.update(filter, update, true, opts...)
+}
+
+func (c *memoryCollection) update(filter interface{}, update interface{}, multi bool, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	updateOptions := options.MergeUpdateOptions(opts...)
+	upsert := updateOptions.Upsert != nil && *updateOptions.Upsert
+	before, after, upsertedID, err := c.updateDocuments(filter, update, nil, multi, upsert)
+	if err != nil {
+		return nil, err
+	}
+	result := &mongo.UpdateResult{UpsertedID: upsertedID}
+	if upsertedID != nil {
+		result.UpsertedCount = 1
+		return result, nil
+	}
+	result.MatchedCount = int64(len(before))
+	for i := range before {
+		if !documentsEqual(before[i], after[i]) {
+			result.ModifiedCount++
+		}
+	}
+	return result, nil
+}
+
+// updateDocuments apply update to the first matched document, or all matched documents if multi is true,
+// return matched documents before and after the update, and the id of the inserted document if upserted
+func (c *memoryCollection) updateDocuments(filter interface{}, update interface{}, sort bson.D, multi bool, upsert bool) (before []bson.D, after []bson.D, upsertedID interface{}, err error) {
+	filterDocument, err := toDocument(filter)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	updateDocument, err := toDocument(update)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c.backend.mu.Lock()
+	defer c.backend.mu.Unlock()
+	data := c.backend.data(c.name, true)
+
+	var matched []int
+	for i, doc := range data.docs {
+		ok, err := matchDocument(doc, filterDocument)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if ok {
+			matched = append(matched, i)
+		}
+	}
+	if len(sort) > 0 {
+		if err := sortIndices(data.docs, matched, sort); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if !multi && len(matched) > 1 {
+		matched = matched[:1]
+	}
+
+	if len(matched) == 0 {
+		if !upsert {
+			return nil, nil, nil, nil
+		}
+		doc, err := applyUpdate(upsertDocument(filterDocument), updateDocument, true)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if id, ok := lookupValue(filterDocument, "_id"); ok && !isOperatorDocument(id) {
+			if doc, err = setPath(doc, []string{"_id"}, id); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		id, err := c.insert(doc)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		inserted := data.docs[len(data.docs)-1]
+		return nil, []bson.D{inserted}, id, nil
+	}
+
+	// apply all updates before storing any, so a failed update changes nothing
+	updated := make([]bson.D, len(matched))
+	for n, i := range matched {
+		doc, err := applyUpdate(copyDocument(data.docs[i]), updateDocument, false)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		oldID, _ := lookupValue(data.docs[i], "_id")
+		newID, _ := lookupValue(doc, "_id")
+		if compareValues(oldID, newID) != 0 {
+			return nil, nil, nil, writeException(immutableFieldCode, "Performing an update on the path '_id' would modify the immutable field '_id'")
+		}
+		if err := data.checkUnique(c.fullName(), doc, i); err != nil {
+			return nil, nil, nil, err
+		}
+		updated[n] = doc
+	}
+	for n, i := range matched {
+		before = append(before, data.docs[i])
+		data.docs[i] = updated[n]
+	}
+	return before, updated, nil, nil
+}
+
+func (c *memoryCollection) DeleteOne(ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+	return c.delete(filter, false)
+}
+
+func (c *memoryCollection) DeleteMany(ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+	return c.delete(filter, true)
+}
+
+func (c *memoryCollection) delete(filter interface{}, multi bool) (*mongo.DeleteResult, error) {
+	filterDocument, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	c.backend.mu.Lock()
+	defer c.backend.mu.Unlock()
+	data := c.backend.data(c.name, false)
+	if data == nil {
+		return &mongo.DeleteResult{}, nil
+	}
+	kept := data.docs[:0:0]
+	var deleted int64
+	for _, doc := range data.docs {
+		if multi || deleted == 0 {
+			matched, err := matchDocument(doc, filterDocument)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				deleted++
+				continue
+			}
+		}
+		kept = append(kept, doc)
+	}
+	data.docs = kept
+	return &mongo.DeleteResult{DeletedCount: deleted}, nil
+}
+
+func (c *memoryCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	if len(models) == 0 {
+		return nil, mongo.ErrEmptySlice
+	}
+	bulkOptions := options.MergeBulkWriteOptions(opts...)
+	ordered := bulkOptions.Ordered == nil || *bulkOptions.Ordered
+	result := &mongo.BulkWriteResult{UpsertedIDs: map[int64]interface{}{}}
+	var writeErrors []mongo.BulkWriteError
+	for i, model := range models {
+		err := c.writeModel(ctx, model, int64(i), result)
+		if err == nil {
+			continue
+		}
+		writeError, ok := firstWriteError(err)
+		if !ok {
+			return result, err
+		}
+		writeError.Index = i
+		writeErrors = append(writeErrors, mongo.BulkWriteError{WriteError: writeError, Request: model})
+		if ordered {
+			break
+		}
+	}
+	if len(writeErrors) > 0 {
+		return result, mongo.BulkWriteException{WriteErrors: writeErrors}
+	}
+	return result, nil
+}
+
+// writeModel execute one write of BulkWrite and add its counts to result
+func (c *memoryCollection) writeModel(ctx context.Context, model mongo.WriteModel, index int64, result *mongo.BulkWriteResult) error {
+	addUpdate := func(updateResult *mongo.UpdateResult, err error) error {
+		if err != nil {
+			return err
+		}
+		result.MatchedCount += updateResult.MatchedCount
+		result.ModifiedCount += updateResult.ModifiedCount
+		result.UpsertedCount += updateResult.UpsertedCount
+		if updateResult.UpsertedID != nil {
+			result.UpsertedIDs[index] = updateResult.UpsertedID
+		}
+		return nil
+	}
+	addDelete := func(deleteResult *mongo.DeleteResult, err error) error {
+		if err != nil {
+			return err
+		}
+		result.DeletedCount += deleteResult.DeletedCount
+		return nil
+	}
+	switch m := model.(type) {
+	case *mongo.InsertOneModel:
+		if _, err := c.InsertOne(ctx, m.Document); err != nil {
+			return err
+		}
+		result.InsertedCount++
+		return nil
+	case *mongo.UpdateOneModel:
+		return addUpdate(c.update(m.Filter, m.Update, false, &options.UpdateOptions{Upsert: m.Upsert}))
+	case *mongo.UpdateManyModel:
+		return addUpdate(c.update(m.Filter, m.Update, true, &options.UpdateOptions{Upsert: m.Upsert}))
+	case *mongo.ReplaceOneModel:
+		replacement, err := toDocument(m.Replacement)
+		if err != nil {
+			return err
+		}
+		if hasOperatorKeys(replacement) {
+			return fmt.Errorf("goose: replacement document must not contain update operators")
+		}
+		return addUpdate(c.update(m.Filter, replacement, false, &options.UpdateOptions{Upsert: m.Upsert}))
+	case *mongo.DeleteOneModel:
+		return addDelete(c.DeleteOne(ctx, m.Filter))
+	case *mongo.DeleteManyModel:
+		return addDelete(c.DeleteMany(ctx, m.Filter))
+	default:
+		return fmt.Errorf("goose: unsupported write model %T", model)
+	}
+}
+
+func (c *memoryCollection) CreateIndex(ctx context.Context, index mongo.IndexModel) (string, error) {
+	keys, err := toDocument(index.Keys)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("goose: index keys must not be empty")
+	}
+	newIndex := memoryIndex{keys: keys}
+	if index.Options != nil {
+		if index.Options.Name != nil {
+			newIndex.name = *index.Options.Name
+		}
+		newIndex.unique = index.Options.Unique != nil && *index.Options.Unique
+	}
+	if newIndex.name == "" {
+		var parts []string
+		for _, key := range keys {
+			parts = append(parts, fmt.Sprintf("%s_%v", key.Key, key.Value))
+		}
+		newIndex.name = strings.Join(parts, "_")
+	}
+
+	c.backend.mu.Lock()
+	defer c.backend.mu.Unlock()
+	data := c.backend.data(c.name, true)
+	for _, existing := range data.indexes {
+		if existing.name == newIndex.name {
+			return existing.name, nil
+		}
+	}
+	if newIndex.unique {
+		checked := &memoryCollectionData{indexes: []memoryIndex{newIndex}}
+		for i, doc := range data.docs {
+			if err := checked.checkUnique(c.fullName(), doc, -1); err != nil {
+				return "", err
+			}
+			checked.docs = append(checked.docs, data.docs[i])
+		}
+	}
+	data.indexes = append(data.indexes, newIndex)
+	return newIndex.name, nil
+}
+
+// ListIndexes list indexes of a memory collection, the _id_ index is always listed
+func (c *memoryCollection) ListIndexes(ctx context.Context) ([]Index, error) {
+	c.backend.mu.Lock()
+	defer c.backend.mu.Unlock()
+	data := c.backend.data(c.name, true)
+	indexes := make([]Index, 0, len(data.indexes))
+	for _, index := range data.indexes {
+		indexes = append(indexes, Index{Name: index.name, Keys: copyDocument(index.keys), Unique: index.unique && index.name != idIndexName})
+	}
+	return indexes, nil
+}
+
+// DropIndex drop an index of a memory collection by name
+func (c *memoryCollection) DropIndex(ctx context.Context, name string) error {
+	if name == idIndexName {
+		return mongo.CommandError{Code: 72, Message: "cannot drop _id index"}
+	}
+	c.backend.mu.Lock()
+	defer c.backend.mu.Unlock()
+	data := c.backend.data(c.name, true)
+	for i, index := range data.indexes {
+		if index.name == name {
+			data.indexes = append(data.indexes[:i], data.indexes[i+1:]...)
+			return nil
+		}
+	}
+	return mongo.CommandError{Code: 27, Message: "index not found with name [" + name + "]"}
+}
+
+// SetValidator the memory database does not validate documents by $jsonSchema
+func (c *memoryCollection) SetValidator(ctx context.Context, validator bson.D, level string, action string) error {
+	return errors.New("goose: collection validators are not supported by the memory database")
+}
+
+func (c *memoryCollection) fullName() string {
+	return c.backend.name + "." + c.name
+}
+
+// checkUnique check doc against unique indexes, skip the document at position self
+func (data *memoryCollectionData) checkUnique(fullName string, doc bson.D, self int) error {
+	for _, index := range data.indexes {
+		if !index.unique {
+			continue
+		}
+		key := index.key(doc)
+		for i, other := range data.docs {
+			if i == self {
+				continue
+			}
+			if compareValues(key, index.key(other)) == 0 {
+				return writeException(duplicateKeyCode, fmt.Sprintf(
+					"E11000 duplicate key error collection: %s index: %s dup key: %s", fullName, index.name, index.dupKey(doc)))
+			}
+		}
+	}
+	return nil
+}
+
+// key the values of index keys in doc, missing fields are null
+func (index memoryIndex) key(doc bson.D) bson.A {
+	key := make(bson.A, 0, len(index.keys))
+	for _, k := range index.keys {
+		value, _ := lookupValue(doc, k.Key)
+		key = append(key, value)
+	}
+	return key
+}
+
+// dupKey format index keys like `{ email: "a@b" }`
+func (index memoryIndex) dupKey(doc bson.D) string {
+	var parts []string
+	for i, value := range index.key(doc) {
+		parts = append(parts, index.keys[i].Key+": "+extJSONValue(value))
+	}
+	return "{ " + strings.Join(parts, ", ") + " }"
+}
+
+func extJSONValue(value interface{}) string {
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data[len(`{"v":`) : len(data)-1])
+}
+
+func writeException(code int, message string) error {
+	return mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: code, Message: message}}}
+}
+
+func firstWriteError(err error) (mongo.WriteError, bool) {
+	if writeException, ok := err.(mongo.WriteException); ok && len(writeException.WriteErrors) > 0 {
+		return writeException.WriteErrors[0], true
+	}
+	return mongo.WriteError{}, false
+}
+
+// memoryCursor cursor over documents copied from the in-memory database
+type memoryCursor struct {
+	docs    []bson.Raw
+	pos     int
+	current bson.Raw
+}
+
+func newMemoryCursor(docs []bson.D) (*memoryCursor, error) {
+	cur := &memoryCursor{docs: make([]bson.Raw, 0, len(docs))}
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		cur.docs = append(cur.docs, raw)
+	}
+	return cur, nil
+}
+
+func (cur *memoryCursor) Next(ctx context.Context) bool {
+	if cur.pos >= len(cur.docs) {
+		cur.current = nil
+		return false
+	}
+	cur.current = cur.docs[cur.pos]
+	cur.pos++
+	return true
+}
+
+func (cur *memoryCursor) Raw() bson.Raw {
+	return cur.current
+}
+
+func (cur *memoryCursor) Decode(v interface{}) error {
+	return bson.Unmarshal(cur.current, v)
+}
+
+func (cur *memoryCursor) All(ctx context.Context, results interface{}) error {
+	remaining := make(bson.A, 0, len(cur.docs)-cur.pos)
+	for cur.Next(ctx) {
+		remaining = append(remaining, cur.current)
+	}
+	data, err := bson.Marshal(bson.D{{Key: "all", Value: remaining}})
+	if err != nil {
+		return err
+	}
+	return bson.Raw(data).Lookup("all").Unmarshal(results)
+}
+
+func (cur *memoryCursor) Err() error {
+	return nil
+}
+
+func (cur *memoryCursor) Close(ctx context.Context) error {
+	cur.docs = nil
+	cur.pos = 0
+	return nil
+}
@@ -0,0 +1,369 @@
+package goose
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// accumulators supported by the $group stage of the memory database
+var memoryAccumulators = map[string]bool{
+	"$sum": true, "$avg": true, "$min": true, "$max": true, "$first": true, "$last": true,
+	"$push": true, "$addToSet": true, "$count": true,
+}
+
+// evalExpression evaluate field paths like "$amount", documents and arrays of them, other values are constants
+func evalExpression(doc bson.D, expr interface{}) (interface{}, error) {
+	switch value := expr.(type) {
+	case string:
+		if strings.HasPrefix(value, "$$") {
+			return nil, fmt.Errorf("goose: memory database does not support variable %s", value)
+		}
+		if strings.HasPrefix(value, "$") {
+			v, _ := lookupValue(doc, value[1:])
+			return v, nil
+		}
+		return value, nil
+	case bson.D:
+		if hasOperatorKeys(value) {
+			return nil, fmt.Errorf("goose: memory database does not support expression operator %s", value[0].Key)
+		}
+		result := make(bson.D, 0, len(value))
+		for _, e := range value {
+			v, err := evalExpression(doc, e.Value)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, bson.E{Key: e.Key, Value: v})
+		}
+		return result, nil
+	case bson.A:
+		result := make(bson.A, 0, len(value))
+		for _, item := range value {
+			v, err := evalExpression(doc, item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, v)
+		}
+		return result, nil
+	default:
+		return expr, nil
+	}
+}
+
+// memoryGroup documents of a $group key
+type memoryGroup struct {
+	id     interface{}
+	values map[string][]interface{}
+}
+
+// groupDocuments run a $group stage, groups are output in the order of their first document
+func groupDocuments(docs []bson.D, value interface{}) ([]bson.D, error) {
+	spec, ok := value.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("goose: $group needs a document")
+	}
+	var idExpr interface{}
+	hasID := false
+	type accumulator struct {
+		field, operator string
+		expr            interface{}
+	}
+	var accumulators []accumulator
+	for _, e := range spec {
+		if e.Key == "_id" {
+			idExpr, hasID = e.Value, true
+			continue
+		}
+		operator, ok := e.Value.(bson.D)
+		if !ok || len(operator) != 1 || !memoryAccumulators[operator[0].Key] {
+			return nil, fmt.Errorf("goose: memory database does not support $group field %s", e.Key)
+		}
+		accumulators = append(accumulators, accumulator{field: e.Key, operator: operator[0].Key, expr: operator[0].Value})
+	}
+	if !hasID {
+		return nil, fmt.Errorf("goose: $group needs _id")
+	}
+
+	var groups []*memoryGroup
+	byKey := map[string]*memoryGroup{}
+	for _, doc := range docs {
+		id, err := evalExpression(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		key := extJSONValue(id)
+		group, ok := byKey[key]
+		if !ok {
+			group = &memoryGroup{id: id, values: map[string][]interface{}{}}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		for _, acc := range accumulators {
+			if acc.operator == "$count" {
+				group.values[acc.field] = append(group.values[acc.field], int32(1))
+				continue
+			}
+			v, err := evalExpression(doc, acc.expr)
+			if err != nil {
+				return nil, err
+			}
+			group.values[acc.field] = append(group.values[acc.field], v)
+		}
+	}
+
+	grouped := make([]bson.D, 0, len(groups))
+	for _, group := range groups {
+		result := bson.D{{Key: "_id", Value: group.id}}
+		for _, acc := range accumulators {
+			result = append(result, bson.E{Key: acc.field, Value: accumulate(acc.operator, group.values[acc.field])})
+		}
+		grouped = append(grouped, result)
+	}
+	return grouped, nil
+}
+
+// accumulate compute an accumulator over the values of a group
+func accumulate(operator string, values []interface{}) interface{} {
+	switch operator {
+	case "$sum", "$count":
+		return sumValues(values)
+	case "$avg":
+		sum, n := 0.0, 0
+		for _, v := range values {
+			if f, ok := numberToFloat(v); ok {
+				sum += f
+				n++
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		return sum / float64(n)
+	case "$min", "$max":
+		var result interface{}
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			if result == nil || (operator == "$min" && compareValues(v, result) < 0) || (operator == "$max" && compareValues(v, result) > 0) {
+				result = v
+			}
+		}
+		return result
+	case "$first":
+		if len(values) > 0 {
+			return values[0]
+		}
+	case "$last":
+		if len(values) > 0 {
+			return values[len(values)-1]
+		}
+	case "$push":
+		return append(bson.A{}, values...)
+	case "$addToSet":
+		set := bson.A{}
+		for _, v := range values {
+			found := false
+			for _, item := range set {
+				if compareValues(item, v) == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				set = append(set, v)
+			}
+		}
+		return set
+	}
+	return nil
+}
+
+// sumValues sum numbers like mongo, integers stay int32 or int64 unless a double is added, other values are ignored
+func sumValues(values []interface{}) interface{} {
+	var intSum int64
+	var floatSum float64
+	isFloat := false
+	for _, v := range values {
+		if n, ok := integerValue(v); ok {
+			intSum += n
+			floatSum += float64(n)
+			continue
+		}
+		if f, ok := numberToFloat(v); ok {
+			floatSum += f
+			isFloat = true
+		}
+	}
+	switch {
+	case isFloat:
+		return floatSum
+	case intSum >= math.MinInt32 && intSum <= math.MaxInt32:
+		return int32(intSum)
+	default:
+		return intSum
+	}
+}
+
+// facetDocuments run the sub-pipelines of a $facet stage on the same documents
+func (b *memoryBackend) facetDocuments(docs []bson.D, value interface{}) ([]bson.D, error) {
+	spec, ok := value.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("goose: $facet needs a document")
+	}
+	result := bson.D{}
+	for _, e := range spec {
+		stages, err := toPipeline(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, stage := range stages {
+			switch stage[0].Key {
+			case "$facet", "$out", "$merge":
+				return nil, fmt.Errorf("goose: %s is not allowed in $facet", stage[0].Key)
+			}
+		}
+		facetDocs, err := b.aggregate(append([]bson.D(nil), docs...), stages)
+		if err != nil {
+			return nil, err
+		}
+		array := make(bson.A, 0, len(facetDocs))
+		for _, doc := range facetDocs {
+			array = append(array, doc)
+		}
+		result = append(result, bson.E{Key: e.Key, Value: array})
+	}
+	return []bson.D{result}, nil
+}
+
+// writeOutput write the result of a pipeline ending with $out or $merge, the caller must hold the write lock
+func (b *memoryBackend) writeOutput(docs []bson.D, stage bson.D) error {
+	name, value := stage[0].Key, stage[0].Value
+	if name == "$out" {
+		into, ok := value.(string)
+		if !ok || into == "" {
+			return fmt.Errorf("goose: memory database only supports $out with a collection name")
+		}
+		data := b.data(into, true)
+		replaced := &memoryCollectionData{indexes: data.indexes}
+		for _, doc := range docs {
+			doc = withID(copyDocument(doc))
+			if err := replaced.checkUnique(b.name+"."+into, doc, -1); err != nil {
+				return err
+			}
+			replaced.docs = append(replaced.docs, doc)
+		}
+		*data = *replaced
+		return nil
+	}
+	return b.merge(docs, value)
+}
+
+// merge run a $merge stage with into, on, whenMatched (merge, replace, keepExisting, fail) and whenNotMatched (insert, discard, fail)
+func (b *memoryBackend) merge(docs []bson.D, value interface{}) error {
+	into, _ := value.(string)
+	on := []string{"_id"}
+	whenMatched, whenNotMatched := "merge", "insert"
+	if spec, ok := value.(bson.D); ok {
+		for _, e := range spec {
+			switch e.Key {
+			case "into":
+				into, _ = e.Value.(string)
+			case "on":
+				switch fields := e.Value.(type) {
+				case string:
+					on = []string{fields}
+				case bson.A:
+					on = on[:0]
+					for _, field := range fields {
+						on = append(on, stringValue(field))
+					}
+				}
+			case "whenMatched":
+				whenMatched, _ = e.Value.(string)
+			case "whenNotMatched":
+				whenNotMatched, _ = e.Value.(string)
+			default:
+				return fmt.Errorf("goose: memory database does not support $merge option %s", e.Key)
+			}
+		}
+	}
+	if into == "" {
+		return fmt.Errorf("goose: memory database only supports $merge into a collection name")
+	}
+	data := b.data(into, true)
+	for _, doc := range docs {
+		matched := -1
+		for i, existing := range data.docs {
+			same := true
+			for _, field := range on {
+				value, ok := lookupValue(doc, field)
+				existingValue, existingOK := lookupValue(existing, field)
+				if !ok || !existingOK || compareValues(value, existingValue) != 0 {
+					same = false
+					break
+				}
+			}
+			if same {
+				matched = i
+				break
+			}
+		}
+		if matched < 0 {
+			switch whenNotMatched {
+			case "insert":
+				doc = withID(copyDocument(doc))
+				if err := data.checkUnique(b.name+"."+into, doc, -1); err != nil {
+					return err
+				}
+				data.docs = append(data.docs, doc)
+			case "discard":
+			case "fail":
+				return fmt.Errorf("goose: $merge found no matching document in %s", into)
+			default:
+				return fmt.Errorf("goose: memory database does not support $merge whenNotMatched %s", whenNotMatched)
+			}
+			continue
+		}
+		var merged bson.D
+		switch whenMatched {
+		case "merge":
+			merged = copyDocument(data.docs[matched])
+			for _, e := range doc {
+				if e.Key == "_id" {
+					continue
+				}
+				merged = setFields(merged, bson.D{e})
+			}
+		case "replace":
+			merged = copyDocument(doc)
+			if id, ok := lookupValue(data.docs[matched], "_id"); ok {
+				merged = setFields(merged, bson.D{{Key: "_id", Value: id}})
+			}
+		case "keepExisting":
+			continue
+		case "fail":
+			return fmt.Errorf("goose: $merge matched an existing document in %s", into)
+		default:
+			return fmt.Errorf("goose: memory database does not support $merge whenMatched %s", whenMatched)
+		}
+		if err := data.checkUnique(b.name+"."+into, merged, matched); err != nil {
+			return err
+		}
+		data.docs[matched] = merged
+	}
+	return nil
+}
+
+// withID add a generated _id to doc if it has none
+func withID(doc bson.D) bson.D {
+	if _, ok := lookupValue(doc, "_id"); ok {
+		return doc
+	}
+	return append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, doc...)
+}
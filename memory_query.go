@@ -0,0 +1,981 @@
+package goose
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// toDocument convert a filter, update or document to bson.D through bson encoding,
+// so values have the same types as documents decoded from the database
+func toDocument(v interface{}) (bson.D, error) {
+	var raw bson.Raw
+	switch value := v.(type) {
+	case nil:
+		return bson.D{}, nil
+	case bson.Raw:
+		raw = value
+	case []byte:
+		raw = value
+	default:
+		data, err := bson.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		raw = data
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = bson.D{}
+	}
+	return doc, nil
+}
+
+// toPipeline convert mongo.Pipeline, []bson.M, bson.A or other arrays of stages to []bson.D
+func toPipeline(pipeline interface{}) ([]bson.D, error) {
+	doc, err := toDocument(bson.D{{Key: "pipeline", Value: pipeline}})
+	if err != nil {
+		return nil, err
+	}
+	array, ok := doc[0].Value.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("goose: pipeline must be an array of stages, got %T", pipeline)
+	}
+	stages := make([]bson.D, 0, len(array))
+	for _, stage := range array {
+		stageDocument, ok := stage.(bson.D)
+		if !ok || len(stageDocument) != 1 {
+			return nil, fmt.Errorf("goose: pipeline stage must be a document with one key, got %v", stage)
+		}
+		stages = append(stages, stageDocument)
+	}
+	return stages, nil
+}
+
+func copyDocument(doc bson.D) bson.D {
+	copied, err := toDocument(doc)
+	if err != nil {
+		return doc
+	}
+	return copied
+}
+
+func documentsEqual(a, b bson.D) bool {
+	dataA, errA := bson.Marshal(a)
+	dataB, errB := bson.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+func isOperatorDocument(v interface{}) bool {
+	doc, ok := v.(bson.D)
+	return ok && hasOperatorKeys(doc)
+}
+
+func hasOperatorKeys(doc bson.D) bool {
+	return len(doc) > 0 && strings.HasPrefix(doc[0].Key, "$")
+}
+
+// lookupValue get the value at dotted path, array elements are addressed by index
+func lookupValue(doc bson.D, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		switch value := current.(type) {
+		case bson.D:
+			found := false
+			for _, e := range value {
+				if e.Key == key {
+					current, found = e.Value, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case bson.A:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(value) {
+				return nil, false
+			}
+			current = value[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// lookupValues get the values at dotted path as a query sees them, documents in arrays on the path are traversed
+func lookupValues(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+	switch v := value.(type) {
+	case bson.D:
+		for _, e := range v {
+			if e.Key == path[0] {
+				return lookupValues(e.Value, path[1:])
+			}
+		}
+	case bson.A:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i >= 0 && i < len(v) {
+				return lookupValues(v[i], path[1:])
+			}
+			return nil
+		}
+		var values []interface{}
+		for _, item := range v {
+			if _, ok := item.(bson.D); ok {
+				values = append(values, lookupValues(item, path)...)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// matchDocument test doc against a query filter
+func matchDocument(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		var matched bool
+		var err error
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			matched, err = matchLogical(doc, e.Key, e.Value)
+		case "$comment":
+			matched = true
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				return false, fmt.Errorf("goose: memory database does not support query operator %s", e.Key)
+			}
+			matched, err = matchCondition(lookupValues(doc, strings.Split(e.Key, ".")), e.Value)
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.D, operator string, value interface{}) (bool, error) {
+	conditions, ok := value.(bson.A)
+	if !ok || len(conditions) == 0 {
+		return false, fmt.Errorf("goose: %s must be a nonempty array", operator)
+	}
+	for _, condition := range conditions {
+		filter, ok := condition.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("goose: %s entries must be documents", operator)
+		}
+		matched, err := matchDocument(doc, filter)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case operator == "$and" && !matched:
+			return false, nil
+		case operator == "$or" && matched:
+			return true, nil
+		case operator == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return operator != "$or", nil
+}
+
+// matchCondition test field values against a condition, which is a value for equality or an operator document
+func matchCondition(values []interface{}, condition interface{}) (bool, error) {
+	if regex, ok := condition.(primitive.Regex); ok {
+		return matchRegex(values, regex)
+	}
+	operators, ok := condition.(bson.D)
+	if !ok || !hasOperatorKeys(operators) {
+		return matchEqual(values, condition), nil
+	}
+	for _, e := range operators {
+		matched, err := matchOperator(values, e.Key, e.Value, operators)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchOperator(values []interface{}, operator string, operand interface{}, operators bson.D) (bool, error) {
+	switch operator {
+	case "$eq":
+		return matchEqual(values, operand), nil
+	case "$ne":
+		return !matchEqual(values, operand), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, value := range expandArrays(values) {
+			if typeRank(value) != typeRank(operand) {
+				continue
+			}
+			c := compareValues(value, operand)
+			if (operator == "$gt" && c > 0) || (operator == "$gte" && c >= 0) ||
+				(operator == "$lt" && c < 0) || (operator == "$lte" && c <= 0) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$in", "$nin":
+		list, ok := operand.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("goose: %s needs an array", operator)
+		}
+		in := false
+		for _, item := range list {
+			var matched bool
+			if regex, ok := item.(primitive.Regex); ok {
+				var err error
+				if matched, err = matchRegex(values, regex); err != nil {
+					return false, err
+				}
+			} else {
+				matched = matchEqual(values, item)
+			}
+			if matched {
+				in = true
+				break
+			}
+		}
+		return in == (operator == "$in"), nil
+	case "$exists":
+		return (len(values) > 0) == truthy(operand), nil
+	case "$regex":
+		regex := primitive.Regex{}
+		switch pattern := operand.(type) {
+		case string:
+			regex.Pattern = pattern
+		case primitive.Regex:
+			regex = pattern
+		default:
+			return false, fmt.Errorf("goose: $regex needs a string, got %T", operand)
+		}
+		if options, ok := lookupValue(operators, "$options"); ok {
+			regex.Options, _ = options.(string)
+		}
+		return matchRegex(values, regex)
+	case "$options":
+		return true, nil
+	case "$not":
+		matched, err := matchCondition(values, operand)
+		return !matched, err
+	case "$size":
+		size, ok := numberToFloat(operand)
+		if !ok {
+			return false, fmt.Errorf("goose: $size needs a number")
+		}
+		for _, value := range values {
+			if array, ok := value.(bson.A); ok && float64(len(array)) == size {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$all":
+		list, ok := operand.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("goose: $all needs an array")
+		}
+		for _, item := range list {
+			if !matchEqual(values, item) {
+				return false, nil
+			}
+		}
+		return len(list) > 0, nil
+	case "$elemMatch":
+		condition, ok := operand.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("goose: $elemMatch needs a document")
+		}
+		for _, value := range values {
+			array, ok := value.(bson.A)
+			if !ok {
+				continue
+			}
+			for _, element := range array {
+				var matched bool
+				var err error
+				if doc, isDocument := element.(bson.D); isDocument && !hasOperatorKeys(condition) {
+					matched, err = matchDocument(doc, condition)
+				} else {
+					matched, err = matchCondition([]interface{}{element}, condition)
+				}
+				if err != nil {
+					return false, err
+				}
+				if matched {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("goose: memory database does not support query operator %s", operator)
+	}
+}
+
+// matchEqual missing fields equal null, arrays match if the array or any element equals
+func matchEqual(values []interface{}, expected interface{}) bool {
+	if len(values) == 0 {
+		return expected == nil
+	}
+	for _, value := range values {
+		if valuesEqual(value, expected) {
+			return true
+		}
+		if array, ok := value.(bson.A); ok {
+			for _, element := range array {
+				if valuesEqual(element, expected) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func matchRegex(values []interface{}, regex primitive.Regex) (bool, error) {
+	flags := ""
+	for _, option := range regex.Options {
+		if strings.ContainsRune("ims", option) {
+			flags += string(option)
+		}
+	}
+	pattern := regex.Pattern
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	for _, value := range expandArrays(values) {
+		if s, ok := value.(string); ok && compiled.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// expandArrays append elements of array values
+func expandArrays(values []interface{}) []interface{} {
+	expanded := values
+	for _, value := range values {
+		if array, ok := value.(bson.A); ok {
+			expanded = append(expanded[:len(expanded):len(expanded)], array...)
+		}
+	}
+	return expanded
+}
+
+func truthy(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return value
+	default:
+		if n, ok := numberToFloat(v); ok {
+			return n != 0
+		}
+		return true
+	}
+}
+
+func valuesEqual(a, b interface{}) bool {
+	return typeRank(a) == typeRank(b) && compareValues(a, b) == 0
+}
+
+// typeRank the BSON comparison order of value types
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return 0
+	case nil, primitive.Undefined, primitive.Null:
+		return 1
+	case int32, int64, float64, primitive.Decimal128:
+		return 2
+	case string, primitive.Symbol:
+		return 3
+	case bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	case primitive.MaxKey:
+		return 13
+	default:
+		return 12
+	}
+}
+
+func numberToFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(n.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareValues compare values in BSON order, return -1, 0 or 1
+func compareValues(a, b interface{}) int {
+	rankA, rankB := typeRank(a), typeRank(b)
+	if rankA != rankB {
+		return compareInts(int64(rankA), int64(rankB))
+	}
+	switch x := a.(type) {
+	case int32, int64, float64, primitive.Decimal128:
+		ia, aIsInt := integerValue(a)
+		ib, bIsInt := integerValue(b)
+		if aIsInt && bIsInt {
+			return compareInts(ia, ib)
+		}
+		fa, _ := numberToFloat(a)
+		fb, _ := numberToFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		case math.IsNaN(fa) && !math.IsNaN(fb):
+			return -1
+		case !math.IsNaN(fa) && math.IsNaN(fb):
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, stringValue(b))
+	case primitive.Symbol:
+		return strings.Compare(string(x), stringValue(b))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if rankX, rankY := typeRank(x[i].Value), typeRank(y[i].Value); rankX != rankY {
+				return compareInts(int64(rankX), int64(rankY))
+			}
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compareValues(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(x)), int64(len(y)))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(x)), int64(len(y)))
+	case primitive.Binary:
+		y := b.(primitive.Binary)
+		if len(x.Data) != len(y.Data) {
+			return compareInts(int64(len(x.Data)), int64(len(y.Data)))
+		}
+		if x.Subtype != y.Subtype {
+			return compareInts(int64(x.Subtype), int64(y.Subtype))
+		}
+		return bytes.Compare(x.Data, y.Data)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return compareInts(int64(x), int64(b.(primitive.DateTime)))
+	case primitive.Timestamp:
+		y := b.(primitive.Timestamp)
+		if x.T != y.T {
+			return compareInts(int64(x.T), int64(y.T))
+		}
+		return compareInts(int64(x.I), int64(y.I))
+	case primitive.Regex:
+		y := b.(primitive.Regex)
+		if c := strings.Compare(x.Pattern, y.Pattern); c != 0 {
+			return c
+		}
+		return strings.Compare(x.Options, y.Options)
+	case nil, primitive.Undefined, primitive.Null, primitive.MinKey, primitive.MaxKey:
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func integerValue(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func stringValue(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case primitive.Symbol:
+		return string(s)
+	}
+	return ""
+}
+
+// sortValue the value used to sort by path, arrays sort by their smallest element ascending and largest descending
+func sortValue(doc bson.D, path string, descending bool) interface{} {
+	values := lookupValues(doc, strings.Split(path, "."))
+	if len(values) == 0 {
+		return nil
+	}
+	candidates := values
+	if len(values) == 1 {
+		if array, ok := values[0].(bson.A); ok && len(array) > 0 {
+			candidates = array
+		}
+	}
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		c := compareValues(candidate, best)
+		if (descending && c > 0) || (!descending && c < 0) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+func sortDirection(v interface{}) (bool, error) {
+	n, ok := numberToFloat(v)
+	if !ok || (n != 1 && n != -1) {
+		return false, fmt.Errorf("goose: sort direction must be 1 or -1, got %v", v)
+	}
+	return n < 0, nil
+}
+
+func compareBySort(a, b bson.D, sortKeys bson.D) (int, error) {
+	for _, key := range sortKeys {
+		descending, err := sortDirection(key.Value)
+		if err != nil {
+			return 0, err
+		}
+		c := compareValues(sortValue(a, key.Key, descending), sortValue(b, key.Key, descending))
+		if descending {
+			c = -c
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+	return 0, nil
+}
+
+// sortDocuments stable sort, documents with equal keys keep their insertion order
+func sortDocuments(docs []bson.D, sortKeys bson.D) error {
+	var sortErr error
+	sort.SliceStable(docs, func(i, j int) bool {
+		c, err := compareBySort(docs[i], docs[j], sortKeys)
+		if err != nil {
+			sortErr = err
+		}
+		return c < 0
+	})
+	return sortErr
+}
+
+// sortIndices sort positions of docs by sortKeys
+func sortIndices(docs []bson.D, indices []int, sortKeys bson.D) error {
+	var sortErr error
+	sort.SliceStable(indices, func(i, j int) bool {
+		c, err := compareBySort(docs[indices[i]], docs[indices[j]], sortKeys)
+		if err != nil {
+			sortErr = err
+		}
+		return c < 0
+	})
+	return sortErr
+}
+
+func skipDocuments(docs []bson.D, skip int64) []bson.D {
+	if skip <= 0 {
+		return docs
+	}
+	if skip >= int64(len(docs)) {
+		return nil
+	}
+	return docs[skip:]
+}
+
+// limitDocuments negative limit means a single batch of -limit documents as in mongo
+func limitDocuments(docs []bson.D, limit int64) []bson.D {
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && limit < int64(len(docs)) {
+		return docs[:limit]
+	}
+	return docs
+}
+
+// sampleDocuments pick size documents in random order, like $sample
+func sampleDocuments(docs []bson.D, value interface{}) ([]bson.D, error) {
+	spec, ok := value.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("goose: $sample needs a document")
+	}
+	var size int64 = -1
+	for _, e := range spec {
+		if e.Key != "size" {
+			return nil, fmt.Errorf("goose: unknown $sample field %s", e.Key)
+		}
+		if n, ok := integerValue(e.Value); ok {
+			size = n
+		} else if f, ok := numberToFloat(e.Value); ok {
+			size = int64(f)
+		}
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("goose: $sample size must be a non-negative number")
+	}
+	docs = append([]bson.D(nil), docs...)
+	rand.Shuffle(len(docs), func(i, j int) { docs[i], docs[j] = docs[j], docs[i] })
+	if size < int64(len(docs)) {
+		docs = docs[:size]
+	}
+	return docs, nil
+}
+
+// projectDocuments support inclusion or exclusion of fields, _id is included unless excluded
+func projectDocuments(docs []bson.D, projection bson.D) ([]bson.D, error) {
+	if len(projection) == 0 {
+		return docs, nil
+	}
+	include := map[string]bool{}
+	inclusion := false
+	excludeID := false
+	for _, e := range projection {
+		if strings.HasPrefix(e.Key, "$") || isOperatorDocument(e.Value) {
+			return nil, fmt.Errorf("goose: memory database does not support projection expression %s", e.Key)
+		}
+		included := truthy(e.Value)
+		if e.Key == "_id" {
+			excludeID = !included
+			continue
+		}
+		include[e.Key] = included
+		if included {
+			inclusion = true
+		}
+	}
+	projected := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		var result bson.D
+		if inclusion {
+			for _, e := range doc {
+				if e.Key == "_id" && !excludeID {
+					result = append(result, e)
+				}
+			}
+			for _, e := range projection {
+				if e.Key == "_id" || !include[e.Key] {
+					continue
+				}
+				if value, ok := lookupValue(doc, e.Key); ok {
+					var err error
+					if result, err = setPath(result, strings.Split(e.Key, "."), value); err != nil {
+						return nil, err
+					}
+				}
+			}
+		} else {
+			result = copyDocument(doc)
+			for key := range include {
+				result = unsetPath(result, strings.Split(key, "."))
+			}
+			if excludeID {
+				result = unsetPath(result, []string{"_id"})
+			}
+		}
+		if result == nil {
+			result = bson.D{}
+		}
+		projected = append(projected, result)
+	}
+	return projected, nil
+}
+
+// upsertDocument the document inserted by an upsert, built from equality conditions of the filter
+func upsertDocument(filter bson.D) bson.D {
+	doc := bson.D{}
+	for _, e := range filter {
+		switch {
+		case e.Key == "$and":
+			conditions, _ := e.Value.(bson.A)
+			for _, condition := range conditions {
+				if conditionDocument, ok := condition.(bson.D); ok {
+					for _, c := range upsertDocument(conditionDocument) {
+						doc, _ = setPath(doc, strings.Split(c.Key, "."), c.Value)
+					}
+				}
+			}
+		case strings.HasPrefix(e.Key, "$"):
+		case isOperatorDocument(e.Value):
+			if operators := e.Value.(bson.D); len(operators) == 1 && operators[0].Key == "$eq" {
+				doc, _ = setPath(doc, strings.Split(e.Key, "."), operators[0].Value)
+			}
+		default:
+			if _, isRegex := e.Value.(primitive.Regex); !isRegex {
+				doc, _ = setPath(doc, strings.Split(e.Key, "."), e.Value)
+			}
+		}
+	}
+	return doc
+}
+
+// aggregate run pipeline stages on docs, the caller must hold the read lock
+func (b *memoryBackend) aggregate(docs []bson.D, stages []bson.D) ([]bson.D, error) {
+	var err error
+	for _, stage := range stages {
+		name, value := stage[0].Key, stage[0].Value
+		switch name {
+		case "$match":
+			filter, ok := value.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("goose: $match needs a document")
+			}
+			matched := make([]bson.D, 0, len(docs))
+			for _, doc := range docs {
+				ok, err := matchDocument(doc, filter)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					matched = append(matched, doc)
+				}
+			}
+			docs = matched
+		case "$sort":
+			sortKeys, ok := value.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("goose: $sort needs a document")
+			}
+			docs = append([]bson.D(nil), docs...)
+			if err := sortDocuments(docs, sortKeys); err != nil {
+				return nil, err
+			}
+		case "$skip", "$limit":
+			n, ok := integerValue(value)
+			if !ok {
+				f, isNumber := numberToFloat(value)
+				if !isNumber {
+					return nil, fmt.Errorf("goose: %s needs a number", name)
+				}
+				n = int64(f)
+			}
+			if name == "$skip" {
+				docs = skipDocuments(docs, n)
+			} else {
+				if n <= 0 {
+					return nil, fmt.Errorf("goose: $limit must be positive")
+				}
+				docs = limitDocuments(docs, n)
+			}
+		case "$sample":
+			if docs, err = sampleDocuments(docs, value); err != nil {
+				return nil, err
+			}
+		case "$project":
+			projection, ok := value.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("goose: $project needs a document")
+			}
+			if docs, err = projectDocuments(docs, projection); err != nil {
+				return nil, err
+			}
+		case "$lookup":
+			if docs, err = b.lookup(docs, value); err != nil {
+				return nil, err
+			}
+		case "$group":
+			if docs, err = groupDocuments(docs, value); err != nil {
+				return nil, err
+			}
+		case "$facet":
+			if docs, err = b.facetDocuments(docs, value); err != nil {
+				return nil, err
+			}
+		case "$unwind":
+			if docs, err = unwind(docs, value); err != nil {
+				return nil, err
+			}
+		case "$count":
+			field, ok := value.(string)
+			if !ok || field == "" {
+				return nil, fmt.Errorf("goose: $count needs a field name")
+			}
+			if len(docs) == 0 {
+				return nil, nil
+			}
+			docs = []bson.D{{{Key: field, Value: int32(len(docs))}}}
+		default:
+			return nil, fmt.Errorf("goose: memory database does not support stage %s", name)
+		}
+	}
+	return docs, nil
+}
+
+// lookup join documents of another collection by localField and foreignField
+func (b *memoryBackend) lookup(docs []bson.D, value interface{}) ([]bson.D, error) {
+	spec, ok := value.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("goose: $lookup needs a document")
+	}
+	fields := map[string]string{}
+	var pipeline []bson.D
+	for _, e := range spec {
+		if e.Key == "pipeline" {
+			stages, err := toPipeline(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, stage := range stages {
+				if stage[0].Key != "$match" {
+					return nil, fmt.Errorf("goose: memory database only supports $match stages in $lookup pipeline")
+				}
+			}
+			pipeline = stages
+			continue
+		}
+		s, ok := e.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("goose: memory database only supports $lookup with from, localField, foreignField, as and pipeline")
+		}
+		fields[e.Key] = s
+	}
+	from, localField, foreignField, as := fields["from"], fields["localField"], fields["foreignField"], fields["as"]
+	if from == "" || localField == "" || foreignField == "" || as == "" {
+		return nil, fmt.Errorf("goose: $lookup needs from, localField, foreignField and as")
+	}
+	foreignDocs, err := b.aggregate(b.documents(from), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	joined := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		localValues := expandArrays(lookupValues(doc, strings.Split(localField, ".")))
+		if len(localValues) == 0 {
+			localValues = []interface{}{nil}
+		}
+		related := bson.A{}
+		for _, foreign := range foreignDocs {
+			foreignValues := lookupValues(foreign, strings.Split(foreignField, "."))
+			for _, localValue := range localValues {
+				if _, isArray := localValue.(bson.A); isArray {
+					continue
+				}
+				if matchEqual(foreignValues, localValue) {
+					related = append(related, copyDocument(foreign))
+					break
+				}
+			}
+		}
+		result, err := setPath(copyDocument(doc), strings.Split(as, "."), related)
+		if err != nil {
+			return nil, err
+		}
+		joined = append(joined, result)
+	}
+	return joined, nil
+}
+
+// unwind output a document for each element of an array field
+func unwind(docs []bson.D, value interface{}) ([]bson.D, error) {
+	path, _ := value.(string)
+	preserve := false
+	if spec, ok := value.(bson.D); ok {
+		for _, e := range spec {
+			switch e.Key {
+			case "path":
+				path, _ = e.Value.(string)
+			case "preserveNullAndEmptyArrays":
+				preserve = truthy(e.Value)
+			default:
+				return nil, fmt.Errorf("goose: memory database does not support $unwind option %s", e.Key)
+			}
+		}
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("goose: $unwind path must start with $")
+	}
+	keys := strings.Split(path[1:], ".")
+	var unwound []bson.D
+	for _, doc := range docs {
+		value, ok := lookupValue(doc, path[1:])
+		array, isArray := value.(bson.A)
+		switch {
+		case !ok || value == nil || (isArray && len(array) == 0):
+			if preserve {
+				unwound = append(unwound, doc)
+			}
+		case !isArray:
+			unwound = append(unwound, doc)
+		default:
+			for _, element := range array {
+				result, err := setPath(copyDocument(doc), keys, element)
+				if err != nil {
+					return nil, err
+				}
+				unwound = append(unwound, result)
+			}
+		}
+	}
+	return unwound, nil
+}
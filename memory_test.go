@@ -0,0 +1,269 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func memoryNames(cur cursor) []string {
+	var names []string
+	for cur.Next(context.Background()) {
+		names = append(names, cur.Raw().Lookup("name").StringValue())
+	}
+	return names
+}
+
+func TestMemoryFilter(t *testing.T) {
+	ctx := context.Background()
+	coll := newMemoryBackend(MemoryDatabaseName).Collection("items")
+	for _, doc := range []bson.M{
+		{"name": "a", "qty": 5, "tags": bson.A{"red", "blue"}, "size": bson.M{"h": 10, "uom": "cm"}},
+		{"name": "b", "qty": int64(20), "tags": bson.A{"blue"}, "size": bson.M{"h": 20.5, "uom": "in"}},
+		{"name": "c", "qty": 7.5, "items": bson.A{bson.M{"sku": "x", "n": 1}, bson.M{"sku": "y", "n": 3}}},
+		{"name": "d", "qty": nil},
+	} {
+		if _, err := coll.InsertOne(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		filter bson.M
+		want   []string
+	}{
+		{bson.M{}, []string{"a", "b", "c", "d"}},
+		{bson.M{"qty": 20}, []string{"b"}},
+		{bson.M{"qty": bson.M{"$gt": 5, "$lte": 20}}, []string{"b", "c"}},
+		{bson.M{"qty": nil}, []string{"d"}},
+		{bson.M{"qty": bson.M{"$exists": true, "$ne": nil}}, []string{"a", "b", "c"}},
+		{bson.M{"tags": "blue"}, []string{"a", "b"}},
+		{bson.M{"tags": bson.M{"$all": bson.A{"red", "blue"}}}, []string{"a"}},
+		{bson.M{"tags": bson.M{"$size": 1}}, []string{"b"}},
+		{bson.M{"tags": bson.M{"$nin": bson.A{"red"}}}, []string{"b", "c", "d"}},
+		{bson.M{"size.uom": bson.M{"$in": bson.A{"in", "mm"}}}, []string{"b"}},
+		{bson.M{"items.sku": "y"}, []string{"c"}},
+		{bson.M{"items": bson.M{"$elemMatch": bson.M{"sku": "x", "n": bson.M{"$gte": 1}}}}, []string{"c"}},
+		{bson.M{"name": primitive.Regex{Pattern: "^[AB]$", Options: "i"}}, []string{"a", "b"}},
+		{bson.M{"name": bson.M{"$regex": "c|d", "$options": ""}}, []string{"c", "d"}},
+		{bson.M{"$or": bson.A{bson.M{"name": "a"}, bson.M{"qty": bson.M{"$lt": 6}}}}, []string{"a"}},
+		{bson.M{"$nor": bson.A{bson.M{"name": "a"}, bson.M{"name": "b"}}}, []string{"c", "d"}},
+		{bson.M{"qty": bson.M{"$not": bson.M{"$gt": 6}}}, []string{"a", "d"}},
+	}
+	for _, c := range cases {
+		cur, err := coll.Find(ctx, c.filter)
+		if err != nil {
+			t.Errorf("%v: %v", c.filter, err)
+			continue
+		}
+		if got := memoryNames(cur); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %v, want %v", c.filter, got, c.want)
+		}
+	}
+
+	cur, err := coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "qty", Value: -1}}).SetSkip(1).SetLimit(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := memoryNames(cur); !reflect.DeepEqual(got, []string{"c", "a"}) {
+		t.Errorf("unexpected sorted page %v", got)
+	}
+	if _, err := coll.Find(ctx, bson.M{"$where": "true"}); err == nil {
+		t.Error("expected unsupported operator error")
+	}
+}
+
+func TestMemoryUpdate(t *testing.T) {
+	ctx := context.Background()
+	coll := newMemoryBackend(MemoryDatabaseName).Collection("items")
+	result, err := coll.InsertOne(ctx, bson.M{"name": "a", "qty": 1, "tags": bson.A{"x"}, "old": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = coll.UpdateOne(ctx, bson.M{"_id": result.InsertedID}, bson.M{
+		"$set":      bson.M{"size.h": 10},
+		"$inc":      bson.M{"qty": 2},
+		"$push":     bson.M{"tags": bson.M{"$each": bson.A{"y", "z"}}},
+		"$addToSet": bson.M{"colors": "red"},
+		"$unset":    bson.M{"old": ""},
+		"$max":      bson.M{"best": 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := coll.FindOneAndUpdate(ctx, bson.M{"name": "a"}, bson.M{"$pull": bson.M{"tags": "y"}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Qty    int32    `bson:"qty"`
+		Tags   []string `bson:"tags"`
+		Colors []string `bson:"colors"`
+		Size   struct {
+			H int32 `bson:"h"`
+		} `bson:"size"`
+		Old  *bool `bson:"old"`
+		Best int32 `bson:"best"`
+	}
+	if err := bson.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Qty != 3 || !reflect.DeepEqual(got.Tags, []string{"x", "z"}) || got.Size.H != 10 ||
+		!reflect.DeepEqual(got.Colors, []string{"red"}) || got.Old != nil || got.Best != 3 {
+		t.Errorf("unexpected updated document %+v", got)
+	}
+
+	upserted, err := coll.UpdateOne(ctx, bson.M{"name": "b"}, bson.M{"$setOnInsert": bson.M{"qty": 9}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upserted.UpsertedCount != 1 {
+		t.Errorf("expected upsert, got %+v", upserted)
+	}
+	if n, err := coll.CountDocuments(ctx, bson.M{"name": "b", "qty": 9}); err != nil || n != 1 {
+		t.Errorf("upserted document not found, %d %v", n, err)
+	}
+	if _, err := coll.UpdateOne(ctx, bson.M{"name": "b"}, bson.M{"$set": bson.M{"_id": "new"}}); err == nil {
+		t.Error("expected immutable _id error")
+	}
+}
+
+func TestMemoryAggregate(t *testing.T) {
+	ctx := context.Background()
+	db := newMemoryBackend(MemoryDatabaseName)
+	users, posts := db.Collection("users"), db.Collection("posts")
+	for _, user := range []bson.M{{"_id": 1, "name": "a"}, {"_id": 2, "name": "b"}} {
+		if _, err := users.InsertOne(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, post := range []bson.M{{"title": "p1", "user": 1}, {"title": "p2", "user": 2}, {"title": "p3", "user": 1}} {
+		if _, err := posts.InsertOne(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cur, err := users.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{"from": "posts", "localField": "_id", "foreignField": "user", "as": "posts"}}},
+		{{Key: "$unwind", Value: "$posts"}},
+		{{Key: "$sort", Value: bson.D{{Key: "posts.title", Value: -1}}}},
+		{{Key: "$project", Value: bson.M{"name": 1, "posts.title": 1}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for cur.Next(ctx) {
+		titles = append(titles, cur.Raw().Lookup("name").StringValue()+":"+cur.Raw().Lookup("posts", "title").StringValue())
+	}
+	if !reflect.DeepEqual(titles, []string{"a:p3", "b:p2", "a:p1"}) {
+		t.Errorf("unexpected aggregate result %v", titles)
+	}
+	if _, err := users.Aggregate(ctx, bson.A{bson.M{"$bucket": bson.M{}}}); err == nil {
+		t.Error("expected unsupported stage error")
+	}
+}
+
+func TestMemoryModel(t *testing.T) {
+	type Account struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Number    int64              `goose:"autoinc" bson:"number"`
+		Email     string             `goose:"unique" bson:"email"`
+		Balance   int64              `goose:"default=10" bson:"balance"`
+		Version   int64              `goose:"version" bson:"version"`
+		CreatedAt time.Time          `goose:"createdAt" bson:"createdAt"`
+		DeletedAt *time.Time         `goose:"deletedAt" bson:"deletedAt,omitempty"`
+	}
+	NewMemoryDatabase()
+	ctx := context.Background()
+	model, err := NewModel("accounts", &Account{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := model.InsertOne(ctx, &Account{Email: email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = model.InsertOne(ctx, &Account{Email: "a@example.com"})
+	var dupErr *DuplicateKeyError
+	if !errors.As(err, &dupErr) || dupErr.Index != "email_1" || !reflect.DeepEqual(dupErr.Fields, []string{"Email"}) {
+		t.Fatalf("expected duplicate key error on Email, got %v", err)
+	}
+
+	result, err := model.Sort(bson.D{{Key: "number", Value: -1}}).Limit(2).FindAndCount(ctx, bson.M{"balance": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 3 || len(result.Data) != 2 || result.Data[0].Value().(*Account).Number != 3 {
+		t.Fatalf("unexpected page %+v", result)
+	}
+
+	doc := result.Data[1]
+	account := doc.Value().(*Account)
+	account.Balance = 50
+	if err := doc.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := model.FindOneByID(ctx, account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.Value().(*Account).Balance != 50 || stale.Value().(*Account).Version != 1 {
+		t.Errorf("unexpected saved account %+v", stale.Value())
+	}
+	account.Balance = 60
+	if err := doc.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Save(ctx); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected version conflict, got %v", err)
+	}
+
+	if _, err := model.SoftDeleteOne(ctx, bson.M{"email": "c@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if docs, err := model.Find(ctx, bson.M{"deletedAt": bson.M{"$exists": false}}); err != nil || len(docs) != 2 {
+		t.Errorf("expected 2 undeleted accounts, got %d %v", len(docs), err)
+	}
+	if _, err := model.DeleteOneByID(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	_, err = model.BulkWrite(ctx, []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(bson.M{"email": "a@example.com"}),
+		mongo.NewUpdateManyModel().SetFilter(bson.M{}).SetUpdate(bson.M{"$inc": bson.M{"balance": 1}}),
+	})
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) != 1 || bulkErr.WriteErrors[0].Index != 0 {
+		t.Fatalf("expected bulk write error at index 0, got %v", err)
+	}
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("bulk duplicate key should be translated, got %v", err)
+	}
+	if docs, _ := model.Unscoped().Find(ctx, bson.M{"balance": 10}); len(docs) != 2 {
+		t.Errorf("ordered bulk write should stop at the first error, got %d unchanged accounts", len(docs))
+	}
+}
+
+func TestMemoryDuplicateKeyMessage(t *testing.T) {
+	ctx := context.Background()
+	coll := newMemoryBackend(MemoryDatabaseName).Collection("users")
+	if _, err := coll.InsertOne(ctx, bson.M{"_id": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := coll.InsertOne(ctx, bson.M{"_id": "a"})
+	message, ok := duplicateKeyMessage(err)
+	if !ok || !regexp.MustCompile(`^E11000 duplicate key error collection: memory.users index: _id_ dup key: \{ _id: "a" \}$`).MatchString(message) {
+		t.Errorf("unexpected duplicate key message %q", message)
+	}
+}
@@ -0,0 +1,286 @@
+package goose
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// applyUpdate apply update operators to doc, an update without operators replaces the document but keeps its _id.
+// $setOnInsert is only applied if insert is true
+func applyUpdate(doc bson.D, update bson.D, insert bool) (bson.D, error) {
+	if !hasOperatorKeys(update) {
+		replacement := copyDocument(update)
+		for _, e := range replacement {
+			if strings.HasPrefix(e.Key, "$") {
+				return nil, fmt.Errorf("goose: replacement document must not contain update operators")
+			}
+		}
+		if id, ok := lookupValue(doc, "_id"); ok {
+			replacement = unsetPath(replacement, []string{"_id"})
+			replacement = append(bson.D{{Key: "_id", Value: id}}, replacement...)
+		}
+		return replacement, nil
+	}
+	var err error
+	for _, operator := range update {
+		fields, ok := operator.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("goose: %s needs a document", operator.Key)
+		}
+		for _, field := range fields {
+			path := strings.Split(field.Key, ".")
+			switch operator.Key {
+			case "$set":
+				doc, err = setPath(doc, path, field.Value)
+			case "$setOnInsert":
+				if insert {
+					doc, err = setPath(doc, path, field.Value)
+				}
+			case "$unset":
+				doc = unsetPath(doc, path)
+			case "$inc", "$mul":
+				current, _ := lookupValue(doc, field.Key)
+				var result interface{}
+				if result, err = arithmetic(operator.Key, current, field.Value); err == nil {
+					doc, err = setPath(doc, path, result)
+				}
+			case "$min", "$max":
+				current, ok := lookupValue(doc, field.Key)
+				c := compareValues(field.Value, current)
+				if !ok || (operator.Key == "$min" && c < 0) || (operator.Key == "$max" && c > 0) {
+					doc, err = setPath(doc, path, field.Value)
+				}
+			case "$currentDate":
+				doc, err = setPath(doc, path, primitive.NewDateTimeFromTime(time.Now()))
+			case "$rename":
+				newName, ok := field.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("goose: $rename target must be a string")
+				}
+				if value, ok := lookupValue(doc, field.Key); ok {
+					doc = unsetPath(doc, path)
+					doc, err = setPath(doc, strings.Split(newName, "."), value)
+				}
+			case "$push", "$addToSet", "$pull", "$pullAll", "$pop":
+				doc, err = updateArray(doc, operator.Key, field.Key, field.Value)
+			default:
+				return nil, fmt.Errorf("goose: memory database does not support update operator %s", operator.Key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+// updateArray apply array update operators
+func updateArray(doc bson.D, operator string, key string, value interface{}) (bson.D, error) {
+	path := strings.Split(key, ".")
+	current, exists := lookupValue(doc, key)
+	array, isArray := current.(bson.A)
+	if exists && !isArray {
+		return nil, fmt.Errorf("goose: %s needs an array field, %s is %T", operator, key, current)
+	}
+	array = append(bson.A(nil), array...)
+
+	switch operator {
+	case "$push", "$addToSet":
+		items := bson.A{value}
+		if modifiers, ok := value.(bson.D); ok && hasOperatorKeys(modifiers) {
+			each, ok := lookupValue(modifiers, "$each")
+			if items, ok = each.(bson.A); !ok || len(modifiers) != 1 {
+				return nil, fmt.Errorf("goose: memory database only supports the $each modifier of %s", operator)
+			}
+		}
+		for _, item := range items {
+			if operator == "$addToSet" && matchEqual(array, item) {
+				continue
+			}
+			array = append(array, item)
+		}
+	case "$pull", "$pullAll":
+		var remove func(element interface{}) (bool, error)
+		if operator == "$pullAll" {
+			list, ok := value.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("goose: $pullAll needs an array")
+			}
+			remove = func(element interface{}) (bool, error) {
+				return matchEqual(list, element), nil
+			}
+		} else if condition, ok := value.(bson.D); ok {
+			remove = func(element interface{}) (bool, error) {
+				if elementDocument, isDocument := element.(bson.D); isDocument && !hasOperatorKeys(condition) {
+					return matchDocument(elementDocument, condition)
+				}
+				return matchCondition([]interface{}{element}, condition)
+			}
+		} else {
+			remove = func(element interface{}) (bool, error) {
+				return matchCondition([]interface{}{element}, value)
+			}
+		}
+		kept := bson.A{}
+		for _, element := range array {
+			removed, err := remove(element)
+			if err != nil {
+				return nil, err
+			}
+			if !removed {
+				kept = append(kept, element)
+			}
+		}
+		if !exists {
+			return doc, nil
+		}
+		array = kept
+	case "$pop":
+		if !exists || len(array) == 0 {
+			return doc, nil
+		}
+		if n, _ := numberToFloat(value); n < 0 {
+			array = array[1:]
+		} else {
+			array = array[:len(array)-1]
+		}
+	}
+	if array == nil {
+		array = bson.A{}
+	}
+	return setPath(doc, path, array)
+}
+
+// arithmetic apply $inc or $mul, a missing field is treated as 0,
+// the result is double if any operand is double, otherwise long if any operand is long or int overflows
+func arithmetic(operator string, current interface{}, operand interface{}) (interface{}, error) {
+	if current == nil {
+		current = int32(0)
+	}
+	a, aIsNumber := numberToFloat(current)
+	b, bIsNumber := numberToFloat(operand)
+	if !aIsNumber || !bIsNumber {
+		return nil, fmt.Errorf("goose: %s needs numbers, got %T and %T", operator, current, operand)
+	}
+	ia, aIsInt := integerValue(current)
+	ib, bIsInt := integerValue(operand)
+	if !aIsInt || !bIsInt {
+		if operator == "$inc" {
+			return a + b, nil
+		}
+		return a * b, nil
+	}
+	var result int64
+	if operator == "$inc" {
+		result = ia + ib
+	} else {
+		result = ia * ib
+	}
+	_, aIsLong := current.(int64)
+	_, bIsLong := operand.(int64)
+	if !aIsLong && !bIsLong && result >= math.MinInt32 && result <= math.MaxInt32 {
+		return int32(result), nil
+	}
+	return result, nil
+}
+
+// setPath set value at dotted path, missing documents on the path are created
+func setPath(doc bson.D, path []string, value interface{}) (bson.D, error) {
+	for i, e := range doc {
+		if e.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			doc[i].Value = value
+			return doc, nil
+		}
+		child, err := setIn(e.Value, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		doc[i].Value = child
+		return doc, nil
+	}
+	if len(path) == 1 {
+		return append(doc, bson.E{Key: path[0], Value: value}), nil
+	}
+	child, err := setPath(bson.D{}, path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	return append(doc, bson.E{Key: path[0], Value: child}), nil
+}
+
+func setIn(container interface{}, path []string, value interface{}) (interface{}, error) {
+	switch c := container.(type) {
+	case bson.D:
+		return setPath(c, path, value)
+	case bson.A:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("goose: cannot create field %s in an array", path[0])
+		}
+		for len(c) <= i {
+			c = append(c, nil)
+		}
+		if len(path) == 1 {
+			c[i] = value
+			return c, nil
+		}
+		if c[i] == nil {
+			c[i] = bson.D{}
+		}
+		if c[i], err = setIn(c[i], path[1:], value); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case nil:
+		return setPath(bson.D{}, path, value)
+	default:
+		return nil, fmt.Errorf("goose: cannot create field %s in a %T value", path[0], container)
+	}
+}
+
+// unsetPath remove the field at dotted path, array elements are set to null as in mongo
+func unsetPath(doc bson.D, path []string) bson.D {
+	for i, e := range doc {
+		if e.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return append(doc[:i:i], doc[i+1:]...)
+		}
+		switch child := e.Value.(type) {
+		case bson.D:
+			doc[i].Value = unsetPath(child, path[1:])
+		case bson.A:
+			doc[i].Value = unsetInArray(child, path[1:])
+		}
+		return doc
+	}
+	return doc
+}
+
+func unsetInArray(array bson.A, path []string) bson.A {
+	index, err := strconv.Atoi(path[0])
+	if err != nil || index < 0 || index >= len(array) {
+		return array
+	}
+	if len(path) == 1 {
+		array[index] = nil
+		return array
+	}
+	switch child := array[index].(type) {
+	case bson.D:
+		array[index] = unsetPath(child, path[1:])
+	case bson.A:
+		array[index] = unsetInArray(child, path[1:])
+	}
+	return array
+}
@@ -0,0 +1,269 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// Operation a model operation in progress, it is carried by the context passed to the mongo driver
+type Operation struct {
+	Name       string // model method, such as Save, Find, FindAndCount, UpdateMany
+	Model      string // model struct type name
+	Collection string
+}
+
+type operationKey struct{}
+
+// OperationFromContext return the model operation running with ctx
+func OperationFromContext(ctx context.Context) (Operation, bool) {
+	op, ok := ctx.Value(operationKey{}).(Operation)
+	return op, ok
+}
+
+// Tracer start spans for model operations, set it by DatabaseOptions.Tracer.
+// StartSpan returns the context used by the operation and a function called with the operation error when it finished
+type Tracer interface {
+	StartSpan(ctx context.Context, op Operation) (context.Context, func(err error))
+}
+
+// TracerFunc adapt a function to Tracer
+type TracerFunc func(ctx context.Context, op Operation) (context.Context, func(err error))
+
+// StartSpan call f
+func (f TracerFunc) StartSpan(ctx context.Context, op Operation) (context.Context, func(err error)) {
+	return f(ctx, op)
+}
+
+// operation status labels
+const (
+	statusOK       = "ok"
+	statusNotFound = "not_found"
+	statusError    = "error"
+)
+
+// DefaultBuckets default latency histogram buckets in seconds
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics in-process counters and latency histograms of model operations and connection pool gauges,
+// set it by DatabaseOptions.Metrics and expose it by WritePrometheus or as a http.Handler
+type Metrics struct {
+	buckets []float64
+
+	mu         sync.Mutex
+	operations map[operationLabels]*operationStats
+	pool       poolStats
+}
+
+type operationLabels struct {
+	collection string
+	operation  string
+	status     string
+}
+
+type operationStats struct {
+	count   uint64
+	sum     float64
+	buckets []uint64 // cumulative count is computed on output
+}
+
+type poolStats struct {
+	open             int64
+	inUse            int64
+	checkoutFailures uint64
+}
+
+// NewMetrics new a metrics registry, DefaultBuckets are used if buckets is empty
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &Metrics{
+		buckets:    sorted,
+		operations: map[operationLabels]*operationStats{},
+	}
+}
+
+func operationStatus(err error) string {
+	switch {
+	case err == nil:
+		return statusOK
+	case errors.Is(err, ErrNotFound):
+		return statusNotFound
+	default:
+		return statusError
+	}
+}
+
+// observe record a finished operation
+func (m *Metrics) observe(op Operation, duration time.Duration, err error) {
+	labels := operationLabels{collection: op.Collection, operation: op.Name, status: operationStatus(err)}
+	seconds := duration.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.operations[labels]
+	if !ok {
+		stats = &operationStats{buckets: make([]uint64, len(m.buckets))}
+		m.operations[labels] = stats
+	}
+	stats.count++
+	stats.sum += seconds
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			stats.buckets[i]++
+			break
+		}
+	}
+}
+
+// OperationCount return the number of finished operations, status is ok, not_found or error
+func (m *Metrics) OperationCount(collection string, operation string, status string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stats, ok := m.operations[operationLabels{collection: collection, operation: operation, status: status}]; ok {
+		return stats.count
+	}
+	return 0
+}
+
+// PoolMonitor return the driver pool monitor updating pool gauges, it is set automatically by DatabaseOptions.Metrics
+func (m *Metrics) PoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			switch e.Type {
+			case event.ConnectionCreated:
+				m.pool.open++
+			case event.ConnectionClosed:
+				m.pool.open--
+			case event.GetSucceeded:
+				m.pool.inUse++
+			case event.ConnectionReturned:
+				m.pool.inUse--
+			case event.GetFailed:
+				m.pool.checkoutFailures++
+			}
+		},
+	}
+}
+
+// WritePrometheus write metrics in Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	labels := make([]operationLabels, 0, len(m.operations))
+	stats := make(map[operationLabels]operationStats, len(m.operations))
+	for l, s := range m.operations {
+		labels = append(labels, l)
+		stats[l] = operationStats{count: s.count, sum: s.sum, buckets: append([]uint64(nil), s.buckets...)}
+	}
+	pool := m.pool
+	m.mu.Unlock()
+
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.collection != b.collection {
+			return a.collection < b.collection
+		}
+		if a.operation != b.operation {
+			return a.operation < b.operation
+		}
+		return a.status < b.status
+	})
+
+	var b strings.Builder
+	b.WriteString("# HELP goose_operations_total Model operations by collection, operation and status.\n")
+	b.WriteString("# TYPE goose_operations_total counter\n")
+	for _, l := range labels {
+		fmt.Fprintf(&b, "goose_operations_total{%s} %d\n", l.String(), stats[l].count)
+	}
+	b.WriteString("# HELP goose_operation_duration_seconds Model operation latency by collection, operation and status.\n")
+	b.WriteString("# TYPE goose_operation_duration_seconds histogram\n")
+	for _, l := range labels {
+		s := stats[l]
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(&b, "goose_operation_duration_seconds_bucket{%s,le=%q} %d\n", l.String(), formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(&b, "goose_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l.String(), s.count)
+		fmt.Fprintf(&b, "goose_operation_duration_seconds_sum{%s} %s\n", l.String(), formatFloat(s.sum))
+		fmt.Fprintf(&b, "goose_operation_duration_seconds_count{%s} %d\n", l.String(), s.count)
+	}
+	b.WriteString("# HELP goose_pool_connections Connections of the driver pool by state.\n")
+	b.WriteString("# TYPE goose_pool_connections gauge\n")
+	fmt.Fprintf(&b, "goose_pool_connections{state=\"open\"} %d\n", pool.open)
+	fmt.Fprintf(&b, "goose_pool_connections{state=\"in_use\"} %d\n", pool.inUse)
+	b.WriteString("# HELP goose_pool_checkout_failures_total Failed connection checkouts of the driver pool.\n")
+	b.WriteString("# TYPE goose_pool_checkout_failures_total counter\n")
+	fmt.Fprintf(&b, "goose_pool_checkout_failures_total %d\n", pool.checkoutFailures)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP serve metrics for Prometheus scraping
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (l operationLabels) String() string {
+	return fmt.Sprintf(`collection="%s",operation="%s",status="%s"`,
+		escapeLabel(l.collection), escapeLabel(l.operation), escapeLabel(l.status))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// startOperation attach the operation to ctx and start its span, the returned function records the result.
+// Operations called by another operation of the same model, such as FindOne in Save, are recorded as part of the outer one,
+// operations of other models, such as a populate or a related model updated in a hook, are recorded on their own
+func (model *Model) startOperation(ctx context.Context, name string) (context.Context, func(err error)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	op := Operation{Name: name, Collection: model.collectionName}
+	if model.schema != nil && model.typ != nil {
+		op.Model = model.typ.Name()
+	}
+	if outer, ok := OperationFromContext(ctx); ok && outer.Collection == op.Collection && outer.Model == op.Model {
+		return ctx, func(error) {}
+	}
+	ctx = context.WithValue(ctx, operationKey{}, op)
+	if model.database == nil || (model.database.metrics == nil && model.database.tracer == nil) {
+		return ctx, func(error) {}
+	}
+	metrics := model.database.metrics
+	endSpan := func(error) {}
+	if model.database.tracer != nil {
+		ctx, endSpan = model.database.tracer.StartSpan(ctx, op)
+	}
+	start := time.Now()
+	return ctx, func(err error) {
+		if metrics != nil {
+			metrics.observe(op, time.Since(start), err)
+		}
+		endSpan(err)
+	}
+}
@@ -0,0 +1,99 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func TestMetricsAndTracer(t *testing.T) {
+	type Order struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	type span struct {
+		op  Operation
+		err error
+	}
+	var spans []span
+	metrics := NewMetrics(0.1, 1)
+	model := newTestModel("orders", &Order{})
+	model.database = &Database{
+		metrics: metrics,
+		tracer: TracerFunc(func(ctx context.Context, op Operation) (context.Context, func(err error)) {
+			if current, ok := OperationFromContext(ctx); !ok || current != op {
+				t.Errorf("operation not in span context, got %+v", current)
+			}
+			return ctx, func(err error) {
+				spans = append(spans, span{op, err})
+			}
+		}),
+	}
+
+	if _, err := model.FindOneByID(context.Background(), "not-hex"); !errors.Is(err, ErrInvalidID) {
+		t.Fatalf("expected ErrInvalidID, got %v", err)
+	}
+	// nested FindOneAndUpdate is recorded as part of FindOneByIDAndUpdate
+	if _, err := model.FindOneByIDAndUpdate(context.Background(), "not-hex", nil); !errors.Is(err, ErrInvalidID) {
+		t.Fatalf("expected ErrInvalidID, got %v", err)
+	}
+
+	if len(spans) != 2 || spans[0].op.Name != "FindOneByID" || spans[1].op.Name != "FindOneByIDAndUpdate" ||
+		spans[0].op.Collection != "orders" || !errors.Is(spans[0].err, ErrInvalidID) {
+		t.Errorf("unexpected spans %+v", spans)
+	}
+	if n := metrics.OperationCount("orders", "FindOneByID", statusError); n != 1 {
+		t.Errorf("expected 1 failed FindOneByID, got %d", n)
+	}
+	if n := metrics.OperationCount("orders", "FindOneAndUpdate", statusError); n != 0 {
+		t.Errorf("nested operation should not be recorded, got %d", n)
+	}
+
+	// operations of another model called within an operation are recorded on their own
+	customers := newTestModel("customers", &Order{})
+	customers.database = model.database
+	ctx, finish := model.startOperation(context.Background(), "Save")
+	if _, err := customers.FindOneByID(ctx, "not-hex"); !errors.Is(err, ErrInvalidID) {
+		t.Fatalf("expected ErrInvalidID, got %v", err)
+	}
+	finish(nil)
+	if n := metrics.OperationCount("customers", "FindOneByID", statusError); n != 1 {
+		t.Errorf("expected nested operation of another model to be recorded, got %d", n)
+	}
+	if len(spans) != 4 || spans[2].op.Collection != "customers" || spans[3].op.Name != "Save" {
+		t.Errorf("unexpected spans %+v", spans)
+	}
+
+	monitor := metrics.PoolMonitor()
+	for _, typ := range []string{event.ConnectionCreated, event.ConnectionCreated, event.GetSucceeded, event.GetFailed} {
+		monitor.Event(&event.PoolEvent{Type: typ})
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE goose_operations_total counter",
+		`goose_operations_total{collection="orders",operation="FindOneByID",status="error"} 1`,
+		`goose_operation_duration_seconds_bucket{collection="orders",operation="FindOneByID",status="error",le="0.1"} 1`,
+		`goose_operation_duration_seconds_bucket{collection="orders",operation="FindOneByID",status="error",le="+Inf"} 1`,
+		`goose_operation_duration_seconds_count{collection="orders",operation="FindOneByIDAndUpdate",status="error"} 1`,
+		`goose_pool_connections{state="open"} 2`,
+		`goose_pool_connections{state="in_use"} 1`,
+		"goose_pool_checkout_failures_total 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("unexpected escaped label %s", got)
+	}
+}
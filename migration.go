@@ -0,0 +1,346 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MigrationsCollection collection recording applied migrations, the lock is stored in MigrationsCollection + "_lock"
+var MigrationsCollection = "migrations"
+
+// ErrMigrationLocked another migrator is holding the migration lock
+var ErrMigrationLocked = errors.New("goose: migrations are locked by another migrator")
+
+const (
+	defaultMigrationLockTTL = 10 * time.Minute
+	migrationLockID         = "migrate"
+	migrationLockPoll       = 500 * time.Millisecond
+)
+
+// MigrationFunc migrate the database, models created on the database can be used for data backfills
+type MigrationFunc func(ctx context.Context, db *Database) error
+
+// Migration a versioned migration, versions are applied in ascending order, such as 20240102150405
+type Migration struct {
+	Version     int64
+	Description string
+	Up          MigrationFunc
+	// Down roll back Up, the migration can not be rolled back if it is nil
+	Down MigrationFunc
+}
+
+// MigrationStatus status of a migration
+type MigrationStatus struct {
+	Version     int64
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+	// Unknown the migration is applied but not registered
+	Unknown bool
+}
+
+// appliedMigration record of MigrationsCollection
+type appliedMigration struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+var (
+	migrationsMu sync.Mutex
+	migrations   []Migration
+)
+
+// RegisterMigration register a migration used by NewMigrator, usually called in init of migration files
+func RegisterMigration(migration Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	migrations = append(migrations, migration)
+}
+
+// RegisteredMigrations return registered migrations sorted by version
+func RegisteredMigrations() []Migration {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	registered := append([]Migration(nil), migrations...)
+	sort.Slice(registered, func(i, j int) bool { return registered[i].Version < registered[j].Version })
+	return registered
+}
+
+// Migrator apply and roll back migrations on a database.
+// Every run holds a lock so concurrent app instances do not run the same migration twice
+type Migrator struct {
+	// LockTTL the lock is taken over by others if it is not refreshed in LockTTL, default is 10 minutes.
+	// It is refreshed before each migration, so a single migration must finish in LockTTL
+	LockTTL time.Duration
+	// LockWait time to wait for the lock held by others, zero returns ErrMigrationLocked immediately
+	LockWait time.Duration
+
+	db         *Database
+	migrations []Migration
+	owner      string
+}
+
+// NewMigrator new a migrator of migrations, registered migrations are used if no migration is given
+func NewMigrator(db *Database, migrations ...Migration) (*Migrator, error) {
+	if len(migrations) == 0 {
+		migrations = RegisteredMigrations()
+	} else {
+		migrations = append([]Migration(nil), migrations...)
+		sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	}
+	for i, migration := range migrations {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("goose: migration version must be positive, got %d", migration.Version)
+		}
+		if migration.Up == nil {
+			return nil, fmt.Errorf("goose: migration %d has no Up", migration.Version)
+		}
+		if i > 0 && migrations[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("goose: duplicated migration version %d", migration.Version)
+		}
+	}
+	return &Migrator{
+		LockTTL:    defaultMigrationLockTTL,
+		db:         db,
+		migrations: migrations,
+		owner:      primitive.NewObjectID().Hex(),
+	}, nil
+}
+
+// Up apply all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// To apply pending migrations with version <= target and roll back applied migrations with version > target,
+// target 0 rolls back all migrations
+func (m *Migrator) To(ctx context.Context, target int64) error {
+	return m.run(ctx, func(applied map[int64]appliedMigration) error {
+		if err := m.rollback(ctx, applied, func(version int64) bool { return version > target }, -1); err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if migration.Version > target {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, migration); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down roll back the last steps applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.run(ctx, func(applied map[int64]appliedMigration) error {
+		return m.rollback(ctx, applied, func(int64) bool { return true }, steps)
+	})
+}
+
+// Status return the status of registered migrations and applied migrations which are not registered, sorted by version
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Applied:     ok,
+			AppliedAt:   record.AppliedAt,
+		})
+		delete(applied, migration.Version)
+	}
+	for _, record := range applied {
+		statuses = append(statuses, MigrationStatus{
+			Version:     record.Version,
+			Description: record.Description,
+			Applied:     true,
+			AppliedAt:   record.AppliedAt,
+			Unknown:     true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// run run f holding the lock, applied migrations are read after the lock is acquired
+func (m *Migrator) run(ctx context.Context, f func(applied map[int64]appliedMigration) error) (err error) {
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		// release even if ctx is canceled, otherwise others wait for LockTTL
+		if unlockErr := m.unlock(context.Background()); err == nil {
+			err = unlockErr
+		}
+	}()
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	return f(applied)
+}
+
+func (m *Migrator) rollback(ctx context.Context, applied map[int64]appliedMigration, match func(version int64) bool, steps int) error {
+	var versions []int64
+	for version := range applied {
+		if match(version) {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if steps >= 0 && len(versions) > steps {
+		versions = versions[:steps]
+	}
+	for _, version := range versions {
+		migration, ok := m.migration(version)
+		if !ok {
+			return fmt.Errorf("goose: applied migration %d is not registered", version)
+		}
+		if migration.Down == nil {
+			return fmt.Errorf("goose: migration %d can not be rolled back", version)
+		}
+		if err := m.refreshLock(ctx); err != nil {
+			return err
+		}
+		m.db.Logger().Info("rolling back migration.", "version", version, "description", migration.Description)
+		if err := migration.Down(ctx, m.db); err != nil {
+			return fmt.Errorf("goose: roll back migration %d: %w", version, err)
+		}
+		if _, err := m.db.collection(MigrationsCollection).DeleteOne(ctx, bson.M{"_id": version}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, migration Migration) error {
+	if err := m.refreshLock(ctx); err != nil {
+		return err
+	}
+	m.db.Logger().Info("applying migration.", "version", migration.Version, "description", migration.Description)
+	if err := migration.Up(ctx, m.db); err != nil {
+		return fmt.Errorf("goose: apply migration %d: %w", migration.Version, err)
+	}
+	_, err := m.db.collection(MigrationsCollection).InsertOne(ctx, appliedMigration{
+		Version:     migration.Version,
+		Description: migration.Description,
+		AppliedAt:   time.Now(),
+	})
+	return err
+}
+
+func (m *Migrator) migration(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]appliedMigration, error) {
+	cur, err := m.db.collection(MigrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []appliedMigration
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// lock acquire the lock, wait LockWait if it is held by others
+func (m *Migrator) lock(ctx context.Context) error {
+	deadline := time.Now().Add(m.LockWait)
+	for {
+		locked, err := m.tryLock(ctx)
+		if err != nil || locked {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return ErrMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockPoll):
+		}
+	}
+}
+
+// tryLock insert the lock document, or take it over if it expired
+func (m *Migrator) tryLock(ctx context.Context) (bool, error) {
+	locks := m.db.collection(MigrationsCollection + "_lock")
+	now := time.Now()
+	_, err := locks.InsertOne(ctx, bson.D{
+		{Key: "_id", Value: migrationLockID},
+		{Key: "owner", Value: m.owner},
+		{Key: "expiresAt", Value: now.Add(m.lockTTL())},
+	})
+	if err == nil {
+		return true, nil
+	}
+	if _, duplicated := duplicateKeyMessage(err); !duplicated {
+		return false, err
+	}
+	result, err := locks.UpdateOne(ctx,
+		bson.M{"_id": migrationLockID, "expiresAt": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": m.owner, "expiresAt": now.Add(m.lockTTL())}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// refreshLock extend the lock, fail if it was taken over by others
+func (m *Migrator) refreshLock(ctx context.Context) error {
+	result, err := m.db.collection(MigrationsCollection+"_lock").UpdateOne(ctx,
+		bson.M{"_id": migrationLockID, "owner": m.owner},
+		bson.M{"$set": bson.M{"expiresAt": time.Now().Add(m.lockTTL())}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: the lock expired", ErrMigrationLocked)
+	}
+	return nil
+}
+
+func (m *Migrator) unlock(ctx context.Context) error {
+	_, err := m.db.collection(MigrationsCollection+"_lock").DeleteOne(ctx, bson.M{"_id": migrationLockID, "owner": m.owner})
+	return err
+}
+
+func (m *Migrator) lockTTL() time.Duration {
+	if m.LockTTL <= 0 {
+		return defaultMigrationLockTTL
+	}
+	return m.LockTTL
+}
@@ -0,0 +1,151 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMigrator(t *testing.T) {
+	type Item struct {
+		ID     int64  `goose:"primary,autoinc" bson:"_id"`
+		Name   string `bson:"name"`
+		Status string `bson:"status,omitempty"`
+	}
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+	model, err := NewModel("items", &Item{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.InsertOne(ctx, &Item{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var log []string
+	migrations := []Migration{
+		{
+			Version:     2,
+			Description: "backfill status",
+			Up: func(ctx context.Context, db *Database) error {
+				log = append(log, "up 2")
+				_, err := model.UpdateMany(ctx, bson.M{"status": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"status": "active"}})
+				return err
+			},
+			Down: func(ctx context.Context, db *Database) error {
+				log = append(log, "down 2")
+				_, err := model.UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"status": ""}})
+				return err
+			},
+		},
+		{
+			Version: 1,
+			Up:      func(ctx context.Context, db *Database) error { log = append(log, "up 1"); return nil },
+			Down:    func(ctx context.Context, db *Database) error { log = append(log, "down 1"); return nil },
+		},
+		{
+			Version: 3,
+			Up:      func(ctx context.Context, db *Database) error { log = append(log, "up 3"); return nil },
+		},
+	}
+	migrator, err := NewMigrator(db, migrations...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrator.To(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := model.FindOneByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Value().(*Item).Status != "active" {
+		t.Errorf("expected backfilled status, got %+v", doc.Value())
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 || !statuses[0].Applied || !statuses[2].Applied || statuses[1].Description != "backfill status" || statuses[2].AppliedAt.IsZero() {
+		t.Fatalf("unexpected statuses %+v", statuses)
+	}
+
+	if err := migrator.Down(ctx, 1); err == nil {
+		t.Error("expected error rolling back migration without Down")
+	}
+	if err := migrator.To(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"up 1", "up 2", "up 3"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("got %v, want %v", log, want)
+	}
+
+	// an older binary without migration 3 reports it as unknown and can still roll back the others
+	older, err := NewMigrator(db, migrations[:2]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err = older.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 || !statuses[2].Unknown {
+		t.Fatalf("unexpected statuses %+v", statuses)
+	}
+	if err := older.To(ctx, 1); err == nil {
+		t.Error("expected error rolling back unknown migration")
+	}
+	if _, err := getCollection(MigrationsCollection).DeleteOne(ctx, bson.M{"_id": int64(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := older.To(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "down 2", "down 1")
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("got %v, want %v", log, want)
+	}
+
+	if _, err := NewMigrator(db, migrations[0], migrations[0]); err == nil {
+		t.Error("expected duplicated version error")
+	}
+}
+
+func TestMigratorLock(t *testing.T) {
+	db := NewMemoryDatabase()
+	ctx := context.Background()
+	migration := Migration{Version: 1, Up: func(ctx context.Context, db *Database) error { return nil }}
+	migrator, err := NewMigrator(db, migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locks := getCollection(MigrationsCollection + "_lock")
+	if _, err := locks.InsertOne(ctx, bson.M{"_id": migrationLockID, "owner": "other", "expiresAt": time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(ctx); !errors.Is(err, ErrMigrationLocked) {
+		t.Fatalf("expected locked error, got %v", err)
+	}
+
+	if _, err := locks.UpdateOne(ctx, bson.M{"_id": migrationLockID}, bson.M{"$set": bson.M{"expiresAt": time.Now().Add(-time.Second)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("expected expired lock taken over, got %v", err)
+	}
+	if n, err := locks.CountDocuments(ctx, bson.M{}); err != nil || n != 0 {
+		t.Errorf("expected lock released, got %d %v", n, err)
+	}
+}
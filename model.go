@@ -1,9 +1,10 @@
 package goose
 
 import (
-	"go.mongodb.org/mongo-driver/bson/primitive"
-
-	"go.mongodb.org/mongo-driver/mongo"
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
 )
 
 // Relation model relation for populate
@@ -14,57 +15,102 @@ type Relation struct {
 	as           string
 }
 
-// Field model field, just using in model time for now
+// Field model field, mapping struct field to bson name
 type Field struct {
 	StructFieldName string
 	BsonName        string
 	DefaultValue    interface{}
 }
 
-// ModelTime model time
-type ModelTime struct {
-	createdAtField *Field
-	updatedAtField *Field
-	deletedAtField *Field
-}
-
 // Model Model class
 type Model struct {
-	collection      *mongo.Collection
-	collectionName  string
-	findOpt         FindOption
-	curValue        interface{}
-	primaryKey      string
-	primaryKeyValue interface{}
-	relationship    []Relation
-	modelTime       ModelTime
-}
-
-func (model *Model) getCollection() *mongo.Collection {
-	// if DB == nil {
-	// 	return nil, errors.New("Mongo not connected yet. ")
-	// }
-	return DB.Collection(model.collectionName)
+	*schema
+	collection     collection
+	collectionName string
+	curValue       interface{}
+	database       *Database
+	history        *history
+	// added by plugins
+	hooks         map[HookEvent][]Hook
+	scopes        []QueryScope
+	pluginIndexes []Index
+	// named scopes
+	namedScopes   map[string]QueryScope
+	defaultScopes []string
 }
 
-// NewModel new a Model class
-func NewModel(collectionName string, curValue interface{}) *Model {
+// NewModel new a Model class, curValue is a pointer to the model struct which can be saved by model.Save.
+// Builtin plugins, plugins registered by RegisterPlugin and plugins are applied in order.
+// Return error if the struct tags are invalid, a plugin failed or indexes can not be created
+func NewModel(collectionName string, curValue interface{}, plugins ...Plugin) (*Model, error) {
+	if reflect.TypeOf(curValue) == nil || reflect.TypeOf(curValue).Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("goose: model value must be a pointer to struct, got %T", curValue)
+	}
+	s, err := getSchema(reflect.TypeOf(curValue).Elem())
+	if err != nil {
+		return nil, err
+	}
 	collection := getCollection(collectionName)
 	model := &Model{
+		schema:         s,
 		collection:     collection,
 		collectionName: collectionName,
 		curValue:       curValue,
+		database:       defaultDatabase,
+	}
+	model.applyDefaults(curValue)
+	for _, plugin := range modelPlugins(plugins) {
+		if err := plugin.Apply(model); err != nil {
+			return nil, fmt.Errorf("goose: apply plugin %s to %s: %w", plugin.Name(), collectionName, err)
+		}
+	}
+	if err := model.createIndexes(); err != nil {
+		return nil, err
+	}
+	registerModel(model)
+	return model, nil
+}
+
+// models created by NewModel, by struct type and by collection name, the last created model wins.
+// Factories and fixtures find models here
+var (
+	modelsByType       sync.Map
+	modelsByCollection sync.Map
+)
+
+func registerModel(model *Model) {
+	modelsByType.Store(model.typ, model)
+	modelsByCollection.Store(model.collectionName, model)
+}
+
+// registeredModel find the model of a struct type
+func registeredModel(t reflect.Type) (*Model, bool) {
+	model, ok := modelsByType.Load(t)
+	if !ok {
+		return nil, false
 	}
-	model.structTagParse()
-	model.setDefault()
-	return model
+	return model.(*Model), true
 }
 
-func (model *Model) setDefault() {
-	if model.primaryKey == "" {
-		model.primaryKey = "_id"
+// registeredCollectionModel find the model of a collection
+func registeredCollectionModel(collectionName string) (*Model, bool) {
+	model, ok := modelsByCollection.Load(collectionName)
+	if !ok {
+		return nil, false
 	}
-	if model.primaryKeyValue == primitive.NilObjectID {
-		model.primaryKeyValue = primitive.NewObjectID()
+	return model.(*Model), true
+}
+
+// indexes indexes declared by tags and added by plugins
+func (model *Model) indexes() []Index {
+	return append(model.declaredIndexes(), model.pluginIndexes...)
+}
+
+func (model *Model) createIndexes() error {
+	for _, index := range model.indexes() {
+		if _, err := model.collection.CreateIndex(context.Background(), index.model()); err != nil {
+			return model.translateError(err)
+		}
 	}
+	return nil
 }
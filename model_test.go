@@ -1,16 +1,16 @@
 package goose
 
 import (
+	"context"
 	"testing"
 	"time"
 
-	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type User struct {
-	ID          primitive.ObjectID `goose:"primary" bson:"_id,omitempty""`
+	ID          primitive.ObjectID `goose:"primary" bson:"_id,omitempty"`
 	Name        string             `goose:"-" bson:"name,omitempty"`
 	Email       string             `goose:"-" bson:"email,omitempty"`
 	CreatedTime time.Time          `goose:"createdAt" bson:"createdTime,omitempty"`
@@ -70,17 +70,8 @@ type Post struct {
 // }
 
 func TestPopulate(t *testing.T) {
-	err := godotenv.Load()
-	if err != nil {
-		t.Error(err)
-	}
-	db, err := NewMongoDatabase(&DatabaseOptions{
-		UsingEnv: true,
-	})
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer db.Close()
+	NewMemoryDatabase()
+	ctx := context.Background()
 
 	userID := primitive.NewObjectID()
 	user := User{
@@ -88,25 +79,43 @@ func TestPopulate(t *testing.T) {
 		Name:  "John Doe",
 		Email: "john@example",
 	}
-	userModel := NewModel("TestUsers", &user)
-	postModel := NewModel("TestPosts", &Post{
+	userModel, err := NewModel("TestUsers", &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postModel, err := NewModel("TestPosts", &Post{
 		UserID: userID,
 		Title:  "test post",
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	user.Name = "Pascal Lin"
-	err = userModel.Save()
+	err = userModel.Save(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = postModel.Save()
+	err = postModel.Save(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	postModel = NewModel("TestPosts", &Post{})
-	result, err := postModel.Populate("User").Find(bson.M{})
+	postModel, err = NewModel("TestPosts", &Post{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := postModel.Populate("User").Find(ctx, bson.M{"userId": userID})
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log(result)
+	if len(result) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(result))
+	}
+	var users []User
+	if err := result[0].Populated("User", &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "Pascal Lin" {
+		t.Errorf("unexpected populated users %v", users)
+	}
 }
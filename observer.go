@@ -0,0 +1,269 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// QueryEvent a finished command issued to mongo
+type QueryEvent struct {
+	RequestID  int64
+	Command    string // command name, such as find, insert, update, aggregate
+	Database   string
+	Collection string
+	// Model and Operation the model struct type name and method issuing the command, empty if it is not issued by a model
+	Model     string
+	Operation string
+	// Filter the command filter in extended JSON, all values are replaced by "?"
+	Filter   string
+	Duration time.Duration
+	// Count number of documents returned or affected
+	Count int64
+	Err   error
+	Slow  bool
+}
+
+// QueryObserver observe commands issued to mongo through the driver command monitor, set it by DatabaseOptions.QueryObserver
+type QueryObserver struct {
+	// SlowThreshold commands slower than it are logged as warning and marked as slow, 0 means no slow query detection
+	SlowThreshold time.Duration
+	// LogQueries log every command at debug level
+	LogQueries bool
+	// OnEvent called after each command finished, it is called in the goroutine running the command
+	OnEvent func(ctx context.Context, e QueryEvent)
+	// Logger default is the database logger, or the global logger if the database has none
+	Logger Logger
+
+	mu             sync.RWMutex
+	databaseLogger Logger
+	pending        sync.Map
+}
+
+// startedCommand a started command waiting for its result
+type startedCommand struct {
+	database   string
+	collection string
+	filter     string
+	model      string
+	operation  string
+}
+
+// commands which are not issued by model operations
+var ignoredCommands = map[string]bool{
+	"hello":        true,
+	"isMaster":     true,
+	"ismaster":     true,
+	"ping":         true,
+	"buildInfo":    true,
+	"saslStart":    true,
+	"saslContinue": true,
+	"endSessions":  true,
+	"killCursors":  true,
+}
+
+// commandMonitor build the driver command monitor, logger is used if observer has no logger
+func (o *QueryObserver) commandMonitor(logger Logger) *event.CommandMonitor {
+	o.mu.Lock()
+	o.databaseLogger = logger
+	o.mu.Unlock()
+	return &event.CommandMonitor{
+		Started:   o.started,
+		Succeeded: o.succeeded,
+		Failed:    o.failed,
+	}
+}
+
+func (o *QueryObserver) started(ctx context.Context, e *event.CommandStartedEvent) {
+	if ignoredCommands[e.CommandName] {
+		return
+	}
+	started := startedCommand{
+		database:   e.DatabaseName,
+		collection: commandCollection(e.CommandName, e.Command),
+		filter:     commandFilter(e.CommandName, e.Command),
+	}
+	// the driver passes the context of the model operation
+	if op, ok := OperationFromContext(ctx); ok {
+		started.model, started.operation = op.Model, op.Name
+	}
+	o.pending.Store(e.RequestID, started)
+}
+
+func (o *QueryObserver) succeeded(ctx context.Context, e *event.CommandSucceededEvent) {
+	o.finish(ctx, e.CommandFinishedEvent, replyCount(e.CommandName, e.Reply), nil)
+}
+
+func (o *QueryObserver) failed(ctx context.Context, e *event.CommandFailedEvent) {
+	o.finish(ctx, e.CommandFinishedEvent, 0, errors.New(e.Failure))
+}
+
+func (o *QueryObserver) finish(ctx context.Context, e event.CommandFinishedEvent, count int64, err error) {
+	value, ok := o.pending.LoadAndDelete(e.RequestID)
+	if !ok {
+		return
+	}
+	started := value.(startedCommand)
+	queryEvent := QueryEvent{
+		RequestID:  e.RequestID,
+		Command:    e.CommandName,
+		Database:   started.database,
+		Collection: started.collection,
+		Model:      started.model,
+		Operation:  started.operation,
+		Filter:     started.filter,
+		Duration:   time.Duration(e.DurationNanos),
+		Count:      count,
+		Err:        err,
+	}
+	queryEvent.Slow = o.SlowThreshold > 0 && queryEvent.Duration >= o.SlowThreshold
+	o.log(queryEvent)
+	if o.OnEvent != nil {
+		o.OnEvent(ctx, queryEvent)
+	}
+}
+
+func (o *QueryObserver) log(e QueryEvent) {
+	if !e.Slow && !o.LogQueries && e.Err == nil {
+		return
+	}
+	logger := o.Logger
+	if logger == nil {
+		o.mu.RLock()
+		logger = o.databaseLogger
+		o.mu.RUnlock()
+	}
+	if logger == nil {
+		logger = GetLogger()
+	}
+	keysAndValues := []interface{}{
+		"command", e.Command,
+		"model", e.Model,
+		"operation", e.Operation,
+		"collection", e.Collection,
+		"filter", e.Filter,
+		"duration", e.Duration,
+		"count", e.Count,
+	}
+	switch {
+	case e.Err != nil:
+		logger.Error("mongo command failed", append(keysAndValues, "error", e.Err.Error())...)
+	case e.Slow:
+		logger.Warn("slow mongo command", keysAndValues...)
+	default:
+		logger.Debug("mongo command", keysAndValues...)
+	}
+}
+
+// commandCollection the collection name is the value of the command name, getMore keeps it in `collection`
+func commandCollection(commandName string, command bson.Raw) string {
+	key := commandName
+	if commandName == "getMore" {
+		key = "collection"
+	}
+	if collection, ok := command.Lookup(key).StringValueOK(); ok {
+		return collection
+	}
+	return ""
+}
+
+// commandFilter extract the redacted filter of a command
+func commandFilter(commandName string, command bson.Raw) string {
+	var filter bson.RawValue
+	switch commandName {
+	case "find":
+		filter = command.Lookup("filter")
+	case "count", "distinct", "findAndModify":
+		filter = command.Lookup("query")
+	case "aggregate":
+		filter = command.Lookup("pipeline")
+	case "update":
+		filter = command.Lookup("updates", "0", "q")
+	case "delete":
+		filter = command.Lookup("deletes", "0", "q")
+	default:
+		return ""
+	}
+	if filter.Type == 0 {
+		return ""
+	}
+	data, err := bson.MarshalExtJSON(bson.M{"filter": redactValue(filter)}, false, false)
+	if err != nil {
+		return ""
+	}
+	// strip the {"filter": ...} wrapper
+	return string(data[len(`{"filter":`) : len(data)-1])
+}
+
+// redactValue keep document keys and operators, replace all values by "?"
+func redactValue(value bson.RawValue) interface{} {
+	switch value.Type {
+	case bson.TypeEmbeddedDocument:
+		elements, err := value.Document().Elements()
+		if err != nil {
+			return "?"
+		}
+		redactedDocument := make(bson.D, 0, len(elements))
+		for _, element := range elements {
+			redactedDocument = append(redactedDocument, bson.E{Key: element.Key(), Value: redactValue(element.Value())})
+		}
+		return redactedDocument
+	case bson.TypeArray:
+		values, err := value.Array().Values()
+		if err != nil {
+			return "?"
+		}
+		redactedArray := make(bson.A, 0, len(values))
+		for _, v := range values {
+			redactedArray = append(redactedArray, redactValue(v))
+		}
+		return redactedArray
+	default:
+		return "?"
+	}
+}
+
+// replyCount count documents returned or affected from a command reply
+func replyCount(commandName string, reply bson.Raw) int64 {
+	switch commandName {
+	case "find", "aggregate":
+		return arrayLength(reply.Lookup("cursor", "firstBatch"))
+	case "getMore":
+		return arrayLength(reply.Lookup("cursor", "nextBatch"))
+	case "distinct":
+		return arrayLength(reply.Lookup("values"))
+	case "findAndModify":
+		return numberValue(reply.Lookup("lastErrorObject", "n"))
+	default:
+		return numberValue(reply.Lookup("n"))
+	}
+}
+
+func arrayLength(value bson.RawValue) int64 {
+	array, ok := value.ArrayOK()
+	if !ok {
+		return 0
+	}
+	values, err := array.Values()
+	if err != nil {
+		return 0
+	}
+	return int64(len(values))
+}
+
+func numberValue(value bson.RawValue) int64 {
+	if n, ok := value.Int32OK(); ok {
+		return int64(n)
+	}
+	if n, ok := value.Int64OK(); ok {
+		return n
+	}
+	if n, ok := value.DoubleOK(); ok {
+		return int64(n)
+	}
+	return 0
+}
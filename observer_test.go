@@ -0,0 +1,127 @@
+package goose
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func mustMarshal(t *testing.T, v interface{}) bson.Raw {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestQueryObserver(t *testing.T) {
+	var events []QueryEvent
+	logger := &fakeSugaredLogger{}
+	observer := &QueryObserver{
+		SlowThreshold: 100 * time.Millisecond,
+		OnEvent: func(ctx context.Context, e QueryEvent) {
+			events = append(events, e)
+		},
+	}
+	monitor := observer.commandMonitor(NewZapLogger(logger))
+	ctx := context.Background()
+	findCtx := context.WithValue(ctx, operationKey{}, Operation{Name: "Find", Model: "User", Collection: "TestUsers"})
+
+	monitor.Started(findCtx, &event.CommandStartedEvent{
+		CommandName:  "find",
+		DatabaseName: "test",
+		RequestID:    1,
+		Command: mustMarshal(t, bson.D{
+			{Key: "find", Value: "TestUsers"},
+			{Key: "filter", Value: bson.D{
+				{Key: "email", Value: "john@example"},
+				{Key: "age", Value: bson.D{{Key: "$in", Value: bson.A{18, 19}}}},
+			}},
+		}),
+	})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 1, DurationNanos: int64(200 * time.Millisecond)},
+		Reply: mustMarshal(t, bson.D{
+			{Key: "cursor", Value: bson.D{{Key: "firstBatch", Value: bson.A{bson.D{}, bson.D{}}}}},
+			{Key: "ok", Value: 1},
+		}),
+	})
+
+	monitor.Started(ctx, &event.CommandStartedEvent{
+		CommandName: "delete",
+		RequestID:   2,
+		Command: mustMarshal(t, bson.D{
+			{Key: "delete", Value: "TestPosts"},
+			{Key: "deletes", Value: bson.A{bson.D{{Key: "q", Value: bson.D{{Key: "_id", Value: 1}}}}}},
+		}),
+	})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "delete", RequestID: 2, DurationNanos: int64(time.Millisecond)},
+		Reply:                mustMarshal(t, bson.D{{Key: "n", Value: int32(1)}, {Key: "ok", Value: 1}}),
+	})
+
+	monitor.Started(ctx, &event.CommandStartedEvent{CommandName: "ping", RequestID: 3, Command: mustMarshal(t, bson.D{{Key: "ping", Value: 1}})})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "ping", RequestID: 3}})
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	find := events[0]
+	if find.Collection != "TestUsers" || find.Model != "User" || find.Operation != "Find" || find.Count != 2 || !find.Slow {
+		t.Errorf("unexpected find event %+v", find)
+	}
+	if find.Filter != `{"email":"?","age":{"$in":["?","?"]}}` {
+		t.Errorf("filter values should be redacted, got %s", find.Filter)
+	}
+	remove := events[1]
+	if remove.Collection != "TestPosts" || remove.Count != 1 || remove.Slow || remove.Filter != `{"_id":"?"}` {
+		t.Errorf("unexpected delete event %+v", remove)
+	}
+	if len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], "warn slow mongo command") || !strings.Contains(logger.lines[0], "model User") {
+		t.Errorf("only the slow query should be logged, got %v", logger.lines)
+	}
+	if strings.Contains(strings.Join(logger.lines, ""), "john@example") {
+		t.Error("filter values leaked into logs")
+	}
+}
+
+func TestQueryObserverSetLogger(t *testing.T) {
+	defer SetLogger(GetLogger())
+	observer := &QueryObserver{LogQueries: true}
+	monitor := observer.commandMonitor(nil)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			SetLogger(NewNopLogger())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			monitor.Started(context.Background(), &event.CommandStartedEvent{
+				CommandName: "find",
+				RequestID:   int64(i),
+				Command:     mustMarshal(t, bson.D{{Key: "find", Value: "TestUsers"}}),
+			})
+			monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{
+				CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: int64(i)},
+			})
+		}
+	}()
+	wg.Wait()
+
+	logger := &fakeSugaredLogger{}
+	SetLogger(NewZapLogger(logger))
+	monitor.Started(context.Background(), &event.CommandStartedEvent{CommandName: "find", RequestID: 100, Command: mustMarshal(t, bson.D{{Key: "find", Value: "TestUsers"}})})
+	monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 100}})
+	if len(logger.lines) != 1 {
+		t.Errorf("expected the global logger set later to be used, got %v", logger.lines)
+	}
+}
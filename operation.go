@@ -2,80 +2,167 @@ package goose
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"reflect"
-	"time"
 
+	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Save insert or update model
-func (model *Model) Save() error {
-	key := model.primaryKey
-	value := model.primaryKeyValue
-	record, err := model.FindOne(bson.M{key: value})
+var validate = validator.New()
+
+var errNoDeletedAtField = errors.New("goose: model has no deletedAt field or soft delete plugin")
+
+// Save insert or update model.curValue
+func (model *Model) Save(ctx context.Context) (err error) {
+	ctx, finish := model.startOperation(ctx, "Save")
+	defer func() { finish(err) }()
+	return model.save(ctx, model.curValue)
+}
+
+// save insert v if its primary key is empty or not exists, otherwise update it
+func (model *Model) save(ctx context.Context, v interface{}) error {
+	if err := validateStruct(v); err != nil {
+		return err
+	}
+	id := model.primaryFieldValue(v)
+	if !id.IsValid() || id.IsZero() {
+		_, err := model.InsertOne(ctx, v)
+		return err
+	}
+	filter := bson.M{model.primaryKey: id.Interface()}
+	_, err := model.Unscoped().FindOne(ctx, filter)
+	if errors.Is(err, ErrNotFound) {
+		_, err = model.InsertOne(ctx, v)
+		return err
+	}
 	if err != nil {
 		return err
 	}
-	if record != nil {
-		model.FindOneAndUpdate(bson.M{key: value}, model.curValue)
-	} else {
-		model.InsertOne(model.curValue)
+	if model.versionField != nil {
+		return model.updateVersioned(ctx, filter, v)
 	}
-	return nil
+	_, err = model.FindOneAndUpdate(ctx, filter, v)
+	return err
 }
 
-// InsertOne insert data into collection
-func (model *Model) InsertOne(v interface{}) (string, error) {
-	model.wrapCreatedAt(v)
-	model.wrapUpdatedAt(v)
+// updateVersioned update v only if version field not changed since read, and increase it
+func (model *Model) updateVersioned(ctx context.Context, filter bson.M, v interface{}) error {
+	version := reflect.ValueOf(v).Elem().FieldByName(model.versionField.StructFieldName)
+	current := version.Int()
+	filter[model.versionField.BsonName] = current
+	version.SetInt(current + 1)
+	_, err := model.FindOneAndUpdate(ctx, filter, v)
+	if err != nil {
+		version.SetInt(current)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return ErrVersionConflict
+	}
+	return err
+}
 
-	data, err := bson.Marshal(v)
+// InsertOne insert data into collection, primary key will be generated if empty, return the primary key in its native type
+func (model *Model) InsertOne(ctx context.Context, v interface{}) (id interface{}, err error) {
+	ctx, finish := model.startOperation(ctx, "InsertOne")
+	defer func() { finish(err) }()
+	data, err := model.prepareInsert(ctx, v)
 	if err != nil {
-		return "", nil
+		return nil, err
 	}
 
-	insertResult, err := model.collection.InsertOne(context.Background(), data)
+	insertResult, err := model.collection.InsertOne(ctx, data)
 	if err != nil {
-		return primitive.NilObjectID.String(), err
+		return nil, model.translateError(err)
 	}
 
-	return insertResult.InsertedID.(primitive.ObjectID).Hex(), nil
+	id = insertResult.InsertedID
+	if primaryValue := model.primaryFieldValue(v); primaryValue.IsValid() {
+		id = primaryValue.Interface()
+	}
+	if model.history != nil {
+		// the primary key is not the _id returned by the driver if the primary field is not _id
+		after, err := model.historyDocuments(ctx, bson.A{id})
+		if err != nil {
+			return id, err
+		}
+		if err := model.recordHistory(ctx, HistoryInsert, nil, after); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
 }
 
-// FindOneByIDAndUpdate find one and update by id
-func (model *Model) FindOneByIDAndUpdate(id string, updates interface{}) (*mongo.SingleResult, error) {
-	model.wrapUpdatedAt(updates)
-
-	after := options.After
-	mongoID, err := primitive.ObjectIDFromHex(id)
+// prepareInsert apply defaults, BeforeInsert hooks, sequences and the primary key to v, validate it and return the document to insert
+func (model *Model) prepareInsert(ctx context.Context, v interface{}) (interface{}, error) {
+	model.applyDefaults(v)
+	w, err := model.runHooks(ctx, BeforeInsert, v, nil)
 	if err != nil {
 		return nil, err
 	}
-	singleResult := model.collection.FindOneAndUpdate(
-		context.Background(),
-		bson.M{model.primaryKey: mongoID},
-		bson.M{
-			"$set": updates,
-		},
-		&options.FindOneAndUpdateOptions{
-			ReturnDocument: &after,
-		})
-	if singleResult.Err() != nil {
-		return nil, singleResult.Err()
+	if err := model.ensureSequences(ctx, v); err != nil {
+		return nil, err
 	}
-	return singleResult, nil
+	if err := model.ensureID(v); err != nil {
+		return nil, err
+	}
+	if err := validateStruct(v); err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if data, err = bson.Marshal(v); err != nil {
+		return nil, err
+	}
+	if fields := w.fields(); len(fields) > 0 {
+		doc, err := toDocument(data)
+		if err != nil {
+			return nil, err
+		}
+		data = setFields(doc, fields)
+	}
+	return data, nil
 }
 
-// FindOneAndUpdate find one and update by filter
-func (model *Model) FindOneAndUpdate(filter interface{}, updates interface{}) (*mongo.SingleResult, error) {
-	model.wrapUpdatedAt(updates)
+// FindOneByIDAndUpdate find one and update by id, id is the primary key in its native type or a string form of it
+func (model *Model) FindOneByIDAndUpdate(ctx context.Context, id interface{}, updates interface{}) (doc *Document, err error) {
+	ctx, finish := model.startOperation(ctx, "FindOneByIDAndUpdate")
+	defer func() { finish(err) }()
+	filter, err := model.idFilter(id)
+	if err != nil {
+		return nil, err
+	}
+	return model.FindOneAndUpdate(ctx, filter, updates)
+}
+
+// FindOneAndUpdate find one and update by filter, return the updated document
+func (model *Model) FindOneAndUpdate(ctx context.Context, filter interface{}, updates interface{}) (doc *Document, err error) {
+	ctx, finish := model.startOperation(ctx, "FindOneAndUpdate")
+	defer func() { finish(err) }()
+	w, err := model.runHooks(ctx, BeforeUpdate, updates, filter)
+	if err != nil {
+		return nil, err
+	}
+	if fields := w.fields(); len(fields) > 0 {
+		doc, err := toDocument(updates)
+		if err != nil {
+			return nil, err
+		}
+		updates = setFields(doc, fields)
+	}
+	if filter, err = model.scopeFilter(ctx, filter); err != nil {
+		return nil, err
+	}
+	before, filter, err := model.historyTargets(ctx, filter, false)
+	if err != nil {
+		return nil, err
+	}
 
 	after := options.After
-	singleResult := model.collection.FindOneAndUpdate(
-		context.Background(),
+	raw, err := model.collection.FindOneAndUpdate(
+		ctx,
 		filter,
 		bson.M{
 			"$set": updates,
@@ -83,82 +170,214 @@ func (model *Model) FindOneAndUpdate(filter interface{}, updates interface{}) (*
 		&options.FindOneAndUpdateOptions{
 			ReturnDocument: &after,
 		})
-	if singleResult.Err() != nil {
-		return nil, singleResult.Err()
+	if err != nil {
+		return nil, model.translateError(err)
+	}
+	if err := model.recordHistory(ctx, HistoryUpdate, before, []bson.Raw{raw}); err != nil {
+		return nil, err
 	}
-	return singleResult, nil
+	return model.decodeDocument(ctx, raw)
 }
 
 // DeleteOne delete record by filter
-func (model *Model) DeleteOne(filter interface{}) (*mongo.DeleteResult, error) {
-	return model.collection.DeleteOne(context.Background(), filter)
+func (model *Model) DeleteOne(ctx context.Context, filter interface{}) (result *mongo.DeleteResult, err error) {
+	ctx, finish := model.startOperation(ctx, "DeleteOne")
+	defer func() { finish(err) }()
+	return model.delete(ctx, filter, false)
 }
 
-// DeleteOneByID delete record by id
-func (model *Model) DeleteOneByID(id string) (*mongo.DeleteResult, error) {
-	mongoID, err := primitive.ObjectIDFromHex(id)
+// DeleteOneByID delete record by id, return ErrNotFound if nothing deleted
+func (model *Model) DeleteOneByID(ctx context.Context, id interface{}) (result *mongo.DeleteResult, err error) {
+	ctx, finish := model.startOperation(ctx, "DeleteOneByID")
+	defer func() { finish(err) }()
+	filter, err := model.idFilter(id)
+	if err != nil {
+		return nil, err
+	}
+	result, err = model.delete(ctx, filter, false)
 	if err != nil {
 		return nil, err
 	}
-	return model.collection.DeleteOne(context.Background(), bson.M{model.primaryKey: mongoID})
+	if result.DeletedCount == 0 {
+		return result, ErrNotFound
+	}
+	return result, nil
 }
 
-// BulkWrite insert batch records
-func (model *Model) BulkWrite(models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
-	for i := range models {
-		model.wrapUpdatedAt(models[i])
+// BulkWrite write batch records, insert models are prepared like InsertOne with defaults, insert hooks,
+// sequences, ID and validation. Update, replace and delete models only get query scopes added to their filters,
+// update hooks and updatedAt are not applied to them, use model.Bulk to run hooks and validation for all writes.
+// Written documents are recorded by model history
+func (model *Model) BulkWrite(ctx context.Context, models []mongo.WriteModel) (result *mongo.BulkWriteResult, err error) {
+	ctx, finish := model.startOperation(ctx, "BulkWrite")
+	defer func() { finish(err) }()
+	if models, err = model.prepareInsertModels(ctx, models); err != nil {
+		return nil, err
+	}
+	if models, err = model.scopeWriteModels(ctx, models); err != nil {
+		return nil, err
 	}
-	return model.collection.BulkWrite(context.Background(), models)
+	result, err = model.bulkWrite(ctx, models)
+	return result, model.translateError(err)
+}
+
+// prepareInsertModels prepare the documents of insert models like InsertOne, so defaults, insert hooks such as
+// the tenant fill, sequences and validation apply to them. Documents which are not model struct pointers are
+// decoded into one, fields unknown to the struct are kept
+func (model *Model) prepareInsertModels(ctx context.Context, models []mongo.WriteModel) ([]mongo.WriteModel, error) {
+	prepared := append([]mongo.WriteModel(nil), models...)
+	for i, writeModel := range models {
+		m, ok := writeModel.(*mongo.InsertOneModel)
+		if !ok {
+			continue
+		}
+		document, err := model.prepareInsertDocument(ctx, m.Document)
+		if err != nil {
+			return nil, fmt.Errorf("goose: insert model %d: %w", i, err)
+		}
+		copied := *m
+		copied.Document = document
+		prepared[i] = &copied
+	}
+	return prepared, nil
+}
+
+func (model *Model) prepareInsertDocument(ctx context.Context, document interface{}) (interface{}, error) {
+	if model.structValue(document).IsValid() {
+		return model.prepareInsert(ctx, document)
+	}
+	original, err := toDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	data, err := bson.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.New(model.typ).Interface()
+	if err := bson.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	prepared, err := model.prepareInsert(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := toDocument(prepared)
+	if err != nil {
+		return nil, err
+	}
+	return setFields(original, fields), nil
 }
 
 // UpdateMany update batch records
-func (model *Model) UpdateMany(filter interface{}, updates interface{}) (*mongo.UpdateResult, error) {
-	model.wrapUpdatedAt(updates)
-	return model.collection.UpdateMany(context.Background(), filter, updates)
+func (model *Model) UpdateMany(ctx context.Context, filter interface{}, updates interface{}) (result *mongo.UpdateResult, err error) {
+	ctx, finish := model.startOperation(ctx, "UpdateMany")
+	defer func() { finish(err) }()
+	return model.update(ctx, BeforeUpdate, filter, updates, true)
 }
 
 // DeleteMany delete batch records
-func (model *Model) DeleteMany(filter interface{}) (*mongo.DeleteResult, error) {
-	return model.collection.DeleteMany(context.Background(), filter)
+func (model *Model) DeleteMany(ctx context.Context, filter interface{}) (result *mongo.DeleteResult, err error) {
+	ctx, finish := model.startOperation(ctx, "DeleteMany")
+	defer func() { finish(err) }()
+	return model.delete(ctx, filter, true)
 }
 
 // SoftDeleteOne soft delete single record
-func (model *Model) SoftDeleteOne(filter interface{}) (*mongo.UpdateResult, error) {
-	return model.collection.UpdateOne(context.Background(), filter, bson.M{
-		model.modelTime.deletedAtField.BsonName: time.Now(),
-	})
+func (model *Model) SoftDeleteOne(ctx context.Context, filter interface{}) (result *mongo.UpdateResult, err error) {
+	ctx, finish := model.startOperation(ctx, "SoftDeleteOne")
+	defer func() { finish(err) }()
+	return model.update(ctx, BeforeSoftDelete, filter, nil, false)
 }
 
 // SoftDeleteMany soft delete batch record
-func (model *Model) SoftDeleteMany(filter interface{}) (*mongo.UpdateResult, error) {
-	return model.collection.UpdateMany(context.Background(), filter, bson.M{
-		model.modelTime.deletedAtField.BsonName: time.Now(),
-	})
+func (model *Model) SoftDeleteMany(ctx context.Context, filter interface{}) (result *mongo.UpdateResult, err error) {
+	ctx, finish := model.startOperation(ctx, "SoftDeleteMany")
+	defer func() { finish(err) }()
+	return model.update(ctx, BeforeSoftDelete, filter, nil, true)
 }
 
-func (model *Model) wrapCreatedAt(v interface{}) {
-	if model.modelTime.createdAtField != nil {
-		if reflect.ValueOf(v).Elem().FieldByName(model.modelTime.createdAtField.StructFieldName).CanSet() {
-			now := time.Now()
-			reflect.ValueOf(v).Elem().FieldByName(model.modelTime.createdAtField.StructFieldName).Set(reflect.ValueOf(now))
+// update run the hooks of event, update one or many documents and record the write in model history.
+// A soft delete update is made by the BeforeSoftDelete hooks
+func (model *Model) update(ctx context.Context, event HookEvent, filter interface{}, updates interface{}, multi bool) (*mongo.UpdateResult, error) {
+	operation := HistoryUpdate
+	if event == BeforeSoftDelete {
+		if len(model.hooks[BeforeSoftDelete]) == 0 {
+			return nil, errNoDeletedAtField
 		}
+		operation = HistorySoftDelete
+	}
+	filter, updates, err := model.prepareUpdate(ctx, event, filter, updates)
+	if err != nil {
+		return nil, err
 	}
+	before, filter, err := model.historyTargets(ctx, filter, multi)
+	if err != nil {
+		return nil, err
+	}
+	var result *mongo.UpdateResult
+	if multi {
+		result, err = model.collection.UpdateMany(ctx, filter, updates)
+	} else {
+		result, err = model.collection.UpdateOne(ctx, filter, updates)
+	}
+	if err != nil {
+		return nil, model.translateError(err)
+	}
+	after, err := model.historyDocuments(ctx, model.documentIDs(before))
+	if err != nil {
+		return result, err
+	}
+	return result, model.recordHistory(ctx, operation, before, after)
 }
 
-func (model *Model) wrapUpdatedAt(v interface{}) {
-	if model.modelTime.updatedAtField != nil {
-		if reflect.ValueOf(v).Elem().FieldByName(model.modelTime.updatedAtField.StructFieldName).CanSet() {
-			now := time.Now()
-			reflect.ValueOf(v).Elem().FieldByName(model.modelTime.updatedAtField.StructFieldName).Set(reflect.ValueOf(now))
-		}
+// prepareUpdate run the hooks of event, add the fields they set to the $set of updates and query scopes to filter
+func (model *Model) prepareUpdate(ctx context.Context, event HookEvent, filter interface{}, updates interface{}) (interface{}, interface{}, error) {
+	w, err := model.runHooks(ctx, event, updates, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if updates, err = mergeSet(updates, w.fields()); err != nil {
+		return nil, nil, err
 	}
+	if filter, err = model.scopeFilter(ctx, filter); err != nil {
+		return nil, nil, err
+	}
+	return filter, updates, nil
 }
 
-func (model *Model) wrapDeletedAt(v interface{}) {
-	if model.modelTime.deletedAtField != nil {
-		if reflect.ValueOf(v).Elem().FieldByName(model.modelTime.deletedAtField.StructFieldName).CanSet() {
-			now := time.Now()
-			reflect.ValueOf(v).Elem().FieldByName(model.modelTime.deletedAtField.StructFieldName).Set(reflect.ValueOf(now))
-		}
+// delete run the BeforeDelete hooks, delete one or many documents and record the write in model history
+func (model *Model) delete(ctx context.Context, filter interface{}, multi bool) (*mongo.DeleteResult, error) {
+	filter, err := model.prepareDelete(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	before, filter, err := model.historyTargets(ctx, filter, multi)
+	if err != nil {
+		return nil, err
+	}
+	var result *mongo.DeleteResult
+	if multi {
+		result, err = model.collection.DeleteMany(ctx, filter)
+	} else {
+		result, err = model.collection.DeleteOne(ctx, filter)
+	}
+	if err != nil {
+		return nil, model.translateError(err)
+	}
+	return result, model.recordHistory(ctx, HistoryDelete, before, nil)
+}
+
+func validateStruct(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
 	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+	if err := validate.Struct(v); err != nil {
+		return newValidationError(err)
+	}
+	return nil
 }
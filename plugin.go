@@ -0,0 +1,356 @@
+package goose
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Plugin reusable model behaviour, like mongoose schema plugins. Apply is called by NewModel after struct tags
+// are parsed and before indexes are created, it can read tag options by model.TaggedFields and add hooks,
+// query scopes and indexes by model.AddHook, model.AddQueryScope and model.AddIndex
+type Plugin interface {
+	Name() string
+	Apply(model *Model) error
+}
+
+// builtinPlugins applied to every model before other plugins, they do nothing if their tags are not used
+var builtinPlugins = []Plugin{timestampsPlugin{}, softDeletePlugin{}, tenantPlugin{}}
+
+var (
+	pluginsMu     sync.RWMutex
+	globalPlugins []Plugin
+)
+
+// RegisterPlugin apply plugin to every model created by NewModel afterwards, before the plugins passed to NewModel
+func RegisterPlugin(plugin Plugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	globalPlugins = append(globalPlugins, plugin)
+}
+
+// modelPlugins builtin, global and model plugins in the order they are applied
+func modelPlugins(plugins []Plugin) []Plugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	all := make([]Plugin, 0, len(builtinPlugins)+len(globalPlugins)+len(plugins))
+	all = append(all, builtinPlugins...)
+	all = append(all, globalPlugins...)
+	return append(all, plugins...)
+}
+
+// HookEvent the write a hook runs before
+type HookEvent string
+
+// hook events
+const (
+	// BeforeInsert Write.Value is the inserted struct pointer, of an upsert it holds the equality conditions of the filter
+	BeforeInsert HookEvent = "beforeInsert"
+	// BeforeUpdate Write.Value is the fields to set of FindOneAndUpdate and Save, or the update document of UpdateMany
+	BeforeUpdate HookEvent = "beforeUpdate"
+	// BeforeSoftDelete Write.Value is nil, hooks set the fields marking the document deleted
+	BeforeSoftDelete HookEvent = "beforeSoftDelete"
+	// BeforeDelete Write.Value is nil
+	BeforeDelete HookEvent = "beforeDelete"
+)
+
+// Hook run before a write of the model, the write is aborted if it returns an error
+type Hook func(ctx context.Context, w *Write) error
+
+// Write a write passed to hooks
+type Write struct {
+	Model  *Model
+	Event  HookEvent
+	Value  interface{}
+	Filter interface{}
+	// set fields added by hooks which are not struct fields of Value
+	set bson.M
+}
+
+// Set set a field by bson name. The struct field of Value is set if it exists and accepts the value,
+// otherwise the field is added to the inserted document or to the $set of the update
+func (w *Write) Set(field string, value interface{}) {
+	if w.setStructField(field, value) {
+		return
+	}
+	if w.set == nil {
+		w.set = bson.M{}
+	}
+	w.set[field] = value
+}
+
+func (w *Write) setStructField(field string, value interface{}) bool {
+	structValue := w.Model.structValue(w.Value)
+	if !structValue.IsValid() || value == nil {
+		return false
+	}
+	target := structValue.FieldByName(w.Model.structFieldName(field))
+	if !target.IsValid() || !target.CanSet() {
+		return false
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type().AssignableTo(target.Type()):
+		target.Set(v)
+	case target.Kind() == reflect.Ptr && v.Type().AssignableTo(target.Type().Elem()):
+		ptr := reflect.New(target.Type().Elem())
+		ptr.Elem().Set(v)
+		target.Set(ptr)
+	case v.Type().ConvertibleTo(target.Type()) && v.Kind() != reflect.String:
+		target.Set(v.Convert(target.Type()))
+	default:
+		return false
+	}
+	return true
+}
+
+// fields added by hooks, sorted by name
+func (w *Write) fields() bson.D {
+	names := make([]string, 0, len(w.set))
+	for name := range w.set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make(bson.D, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, bson.E{Key: name, Value: w.set[name]})
+	}
+	return fields
+}
+
+// QueryScope return conditions added to every filter of finds, updates and deletes of the model,
+// an error aborts the operation
+type QueryScope func(ctx context.Context) (bson.M, error)
+
+// AddHook run hook before the writes of event, hooks run in the order they are added
+func (model *Model) AddHook(event HookEvent, hook Hook) {
+	if model.hooks == nil {
+		model.hooks = map[HookEvent][]Hook{}
+	}
+	model.hooks[event] = append(model.hooks[event], hook)
+}
+
+// AddQueryScope add conditions to every filter of the model
+func (model *Model) AddQueryScope(scope QueryScope) {
+	model.scopes = append(model.scopes, scope)
+}
+
+// AddIndex create index with the indexes declared by tags, it is also kept by SyncIndexes
+func (model *Model) AddIndex(index Index) {
+	model.pluginIndexes = append(model.pluginIndexes, index)
+}
+
+// TaggedField a struct field with a goose tag option
+type TaggedField struct {
+	Field
+	// Value the option value, such as `title` of `goose:"slug=title"`
+	Value string
+}
+
+// TaggedFields fields with the goose tag option, plugins read their own options by it
+func (model *Model) TaggedFields(option string) []TaggedField {
+	return append([]TaggedField(nil), model.tagged[option]...)
+}
+
+// runHooks run the hooks of event, the returned write holds the fields added by hooks
+func (model *Model) runHooks(ctx context.Context, event HookEvent, value interface{}, filter interface{}) (*Write, error) {
+	w := &Write{Model: model, Event: event, Value: value, Filter: filter}
+	for _, hook := range model.hooks[event] {
+		if err := hook(ctx, w); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// scopeFilter add the conditions of query scopes to filter
+func (model *Model) scopeFilter(ctx context.Context, filter interface{}) (interface{}, error) {
+	if len(model.scopes) == 0 {
+		return filter, nil
+	}
+	conditions := bson.A{}
+	if !isNilFilter(filter) {
+		conditions = append(conditions, filter)
+	}
+	for _, scope := range model.scopes {
+		condition, err := scope(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(condition) > 0 {
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(conditions) == 0 {
+		return bson.M{}, nil
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+// scopeWriteModels add the conditions of query scopes to the filters of bulk write models
+func (model *Model) scopeWriteModels(ctx context.Context, models []mongo.WriteModel) ([]mongo.WriteModel, error) {
+	if len(model.scopes) == 0 {
+		return models, nil
+	}
+	scoped := make([]mongo.WriteModel, len(models))
+	for i, writeModel := range models {
+		var err error
+		switch m := writeModel.(type) {
+		case *mongo.UpdateOneModel:
+			copied := *m
+			copied.Filter, err = model.scopeFilter(ctx, m.Filter)
+			scoped[i] = &copied
+		case *mongo.UpdateManyModel:
+			copied := *m
+			copied.Filter, err = model.scopeFilter(ctx, m.Filter)
+			scoped[i] = &copied
+		case *mongo.ReplaceOneModel:
+			copied := *m
+			copied.Filter, err = model.scopeFilter(ctx, m.Filter)
+			scoped[i] = &copied
+		case *mongo.DeleteOneModel:
+			copied := *m
+			copied.Filter, err = model.scopeFilter(ctx, m.Filter)
+			scoped[i] = &copied
+		case *mongo.DeleteManyModel:
+			copied := *m
+			copied.Filter, err = model.scopeFilter(ctx, m.Filter)
+			scoped[i] = &copied
+		default:
+			scoped[i] = writeModel
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scoped, nil
+}
+
+// isNilFilter nil or a nil map
+func isNilFilter(filter interface{}) bool {
+	value := reflect.ValueOf(filter)
+	return filter == nil || (value.Kind() == reflect.Map && value.IsNil())
+}
+
+// setFields set fields of doc, existing fields are replaced
+func setFields(doc bson.D, fields bson.D) bson.D {
+	for _, field := range fields {
+		replaced := false
+		for i := range doc {
+			if doc[i].Key == field.Key {
+				doc[i].Value = field.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			doc = append(doc, field)
+		}
+	}
+	return doc
+}
+
+// mergeSet add fields to the $set of an update document
+func mergeSet(update interface{}, fields bson.D) (interface{}, error) {
+	if len(fields) == 0 {
+		return update, nil
+	}
+	doc, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+	for i := range doc {
+		if doc[i].Key == "$set" {
+			set, err := toDocument(doc[i].Value)
+			if err != nil {
+				return nil, err
+			}
+			doc[i].Value = setFields(set, fields)
+			return doc, nil
+		}
+	}
+	return append(doc, bson.E{Key: "$set", Value: fields}), nil
+}
+
+// timestampsPlugin set `createdAt` fields on insert and `updatedAt` fields on insert and update
+type timestampsPlugin struct{}
+
+// keepTimestampsKey context key of writes keeping the non-zero timestamps of the written struct, such as Import
+type keepTimestampsKey struct{}
+
+// setTimestamp set a timestamp field of w, it is kept if it is not zero and ctx keeps timestamps
+func setTimestamp(ctx context.Context, w *Write, field TaggedField, now time.Time) {
+	if keep, _ := ctx.Value(keepTimestampsKey{}).(bool); keep {
+		if value := w.Model.structValue(w.Value); value.IsValid() {
+			if current := value.FieldByName(field.StructFieldName); current.IsValid() && !current.IsZero() {
+				return
+			}
+		}
+	}
+	w.Set(field.BsonName, now)
+}
+
+func (timestampsPlugin) Name() string {
+	return "timestamps"
+}
+
+func (timestampsPlugin) Apply(model *Model) error {
+	createdAt := model.TaggedFields(createdAtTag)
+	updatedAt := model.TaggedFields(updatedAtTag)
+	if len(updatedAt) == 0 && len(createdAt) == 0 {
+		return nil
+	}
+	model.AddHook(BeforeInsert, func(ctx context.Context, w *Write) error {
+		now := time.Now()
+		for _, field := range createdAt {
+			setTimestamp(ctx, w, field, now)
+		}
+		for _, field := range updatedAt {
+			setTimestamp(ctx, w, field, now)
+		}
+		return nil
+	})
+	if len(updatedAt) > 0 {
+		model.AddHook(BeforeUpdate, func(ctx context.Context, w *Write) error {
+			now := time.Now()
+			for _, field := range updatedAt {
+				setTimestamp(ctx, w, field, now)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// softDeletePlugin set `deletedAt` fields by SoftDeleteOne and SoftDeleteMany,
+// and registers the softDelete default scope hiding soft deleted documents from finds, Unscoped lifts it
+type softDeletePlugin struct{}
+
+func (softDeletePlugin) Name() string {
+	return "softDelete"
+}
+
+func (softDeletePlugin) Apply(model *Model) error {
+	deletedAt := model.TaggedFields(deletedAtTag)
+	if len(deletedAt) == 0 {
+		return nil
+	}
+	model.AddHook(BeforeSoftDelete, func(ctx context.Context, w *Write) error {
+		now := time.Now()
+		for _, field := range deletedAt {
+			w.Set(field.BsonName, now)
+		}
+		return nil
+	})
+	// missing, null and the zero time of a time.Time field are not deleted
+	visible := bson.M{}
+	for _, field := range deletedAt {
+		visible[field.BsonName] = bson.M{"$in": bson.A{nil, time.Time{}}}
+	}
+	model.DefaultScope("softDelete", visible)
+	return nil
+}
@@ -0,0 +1,147 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type ownerKey struct{}
+
+// ownerPlugin fill `goose:"owner"` fields from the context and scope queries to the owner
+type ownerPlugin struct{}
+
+func (ownerPlugin) Name() string {
+	return "owner"
+}
+
+func (ownerPlugin) Apply(model *Model) error {
+	fields := model.TaggedFields("owner")
+	if len(fields) != 1 {
+		return errors.New("expected one owner field")
+	}
+	field := fields[0].BsonName
+	owner := func(ctx context.Context) (string, error) {
+		owner, ok := ctx.Value(ownerKey{}).(string)
+		if !ok {
+			return "", errors.New("no owner")
+		}
+		return owner, nil
+	}
+	model.AddHook(BeforeInsert, func(ctx context.Context, w *Write) error {
+		value, err := owner(ctx)
+		w.Set(field, value)
+		return err
+	})
+	model.AddHook(BeforeInsert, func(ctx context.Context, w *Write) error {
+		w.Set("source", "plugin")
+		return nil
+	})
+	model.AddQueryScope(func(ctx context.Context) (bson.M, error) {
+		value, err := owner(ctx)
+		return bson.M{field: value}, err
+	})
+	model.AddIndex(Index{Name: field + "_1", Keys: bson.D{{Key: field, Value: int32(1)}}})
+	return nil
+}
+
+func TestPlugin(t *testing.T) {
+	type Note struct {
+		ID        int64      `goose:"primary" bson:"_id"`
+		Owner     string     `goose:"owner" bson:"owner"`
+		Text      string     `bson:"text"`
+		CreatedAt time.Time  `goose:"createdAt" bson:"createdAt"`
+		UpdatedAt time.Time  `goose:"updatedAt" bson:"updatedAt"`
+		DeletedAt *time.Time `goose:"deletedAt" bson:"deletedAt,omitempty"`
+	}
+	db := NewMemoryDatabase()
+	model, err := NewModel("notes", &Note{}, ownerPlugin{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewModel("notes", &struct{ ID int64 }{}, ownerPlugin{}); err == nil || !strings.Contains(err.Error(), "apply plugin owner") {
+		t.Errorf("expected plugin error, got %v", err)
+	}
+	alice := context.WithValue(context.Background(), ownerKey{}, "alice")
+	bob := context.WithValue(context.Background(), ownerKey{}, "bob")
+
+	note := &Note{ID: 1, Text: "hello"}
+	if _, err := model.InsertOne(alice, note); err != nil {
+		t.Fatal(err)
+	}
+	if note.Owner != "alice" || note.CreatedAt.IsZero() || note.UpdatedAt.IsZero() {
+		t.Errorf("hooks did not set struct fields: %+v", note)
+	}
+	if _, err := model.InsertOne(bob, &Note{ID: 2, Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.InsertOne(context.Background(), &Note{ID: 3}); err == nil {
+		t.Error("expected hook error")
+	}
+	doc, err := model.FindOneByID(alice, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source, _ := doc.Raw().Lookup("source").StringValueOK(); source != "plugin" {
+		t.Errorf("expected field added by hook, got %s", doc.Raw())
+	}
+
+	if _, err := model.FindOneByID(bob, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected scoped find to miss, got %v", err)
+	}
+	if docs, err := model.Find(alice, bson.M{}); err != nil || len(docs) != 1 {
+		t.Errorf("expected 1 scoped document, got %d %v", len(docs), err)
+	}
+	if result, err := model.FindAndCount(bob, nil); err != nil || result.Total != 1 {
+		t.Errorf("expected scoped count 1, got %+v %v", result, err)
+	}
+	if _, err := model.Find(context.Background(), bson.M{}); err == nil {
+		t.Error("expected scope error")
+	}
+	if result, err := model.UpdateMany(bob, bson.M{}, bson.M{"$set": bson.M{"text": "changed"}}); err != nil || result.MatchedCount != 1 {
+		t.Errorf("expected scoped update of 1 document, got %+v %v", result, err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	updated, err := model.FindOneByIDAndUpdate(alice, 1, bson.M{"text": "updated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := updated.Value().(*Note); !value.UpdatedAt.After(note.UpdatedAt) {
+		t.Errorf("expected updatedAt to be set by update, got %v", value.UpdatedAt)
+	}
+	if _, err := model.SoftDeleteOne(alice, bson.M{"_id": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.FindOneByID(alice, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected soft deleted note to be hidden, got %v", err)
+	}
+	if doc, err := model.Unscoped().FindOne(alice, bson.M{"_id": int64(1)}); err != nil || doc.Value().(*Note).DeletedAt == nil {
+		t.Errorf("expected deletedAt to be set, got %v", err)
+	}
+	if result, err := model.DeleteMany(bob, bson.M{}); err != nil || result.DeletedCount != 1 {
+		t.Errorf("expected scoped delete of 1 document, got %+v %v", result, err)
+	}
+
+	changes, err := db.SyncIndexes(context.Background(), "notes", &Note{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected plugin index to be kept, got %v", changes)
+	}
+	type Plain struct {
+		ID int64 `bson:"_id"`
+	}
+	plain, err := NewModel("plain", &Plain{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.SoftDeleteOne(context.Background(), bson.M{}); err != errNoDeletedAtField {
+		t.Errorf("expected errNoDeletedAtField, got %v", err)
+	}
+}
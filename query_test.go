@@ -0,0 +1,111 @@
+package goose
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func mustSchema(t reflect.Type) *schema {
+	s, err := getSchema(t)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func newTestModel(collectionName string, v interface{}) *Model {
+	return &Model{
+		schema:         mustSchema(reflect.TypeOf(v).Elem()),
+		collectionName: collectionName,
+		curValue:       v,
+	}
+}
+
+func stageValue(pipeline mongo.Pipeline, name string) (interface{}, bool) {
+	for _, stage := range pipeline {
+		if stage[0].Key == name {
+			return stage[0].Value, true
+		}
+	}
+	return nil, false
+}
+
+func TestQueryImmutable(t *testing.T) {
+	model := newTestModel("TestPosts", &Post{})
+	base := model.Limit(10)
+	first := base.Skip(5).Populate("User")
+	second := base.Skip(7)
+
+	if skip, _ := stageValue(first.pipeline(nil), "$skip"); skip != int64(5) {
+		t.Errorf("unexpected skip %v", skip)
+	}
+	if skip, _ := stageValue(second.pipeline(nil), "$skip"); skip != int64(7) {
+		t.Errorf("unexpected skip %v", skip)
+	}
+	if _, ok := stageValue(base.pipeline(nil), "$skip"); ok {
+		t.Error("base query should not be changed by derived queries")
+	}
+	if _, ok := stageValue(second.pipeline(nil), "$lookup"); ok {
+		t.Error("populate should not leak into sibling queries")
+	}
+	if _, ok := stageValue(model.query().pipeline(nil), "$limit"); ok {
+		t.Error("model should not keep query state")
+	}
+	if len(model.Populate().Populate().populate) != 1 {
+		t.Error("populate stages should not accumulate")
+	}
+}
+
+func TestQueryConcurrent(t *testing.T) {
+	model := newTestModel("TestPosts", &Post{})
+	shared := model.Sort(bson.D{{Key: "createdTime", Value: -1}})
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+		go func(n int64) {
+			defer wg.Done()
+			query := shared.Limit(n).Skip(n * 2)
+			if n%2 == 0 {
+				query = query.Populate("User")
+			}
+			pipeline := query.pipeline(bson.M{"viewCount": n})
+			if limit, _ := stageValue(pipeline, "$limit"); limit != n {
+				t.Errorf("expected limit %d, got %v", n, limit)
+			}
+			if skip, _ := stageValue(pipeline, "$skip"); skip != n*2 {
+				t.Errorf("expected skip %d, got %v", n*2, skip)
+			}
+			if _, ok := stageValue(pipeline, "$lookup"); ok != (n%2 == 0) {
+				t.Errorf("unexpected lookup stage for query %d", n)
+			}
+			if match, _ := stageValue(pipeline, "$match"); !reflect.DeepEqual(match, bson.M{"viewCount": n}) {
+				t.Errorf("unexpected match %v", match)
+			}
+
+			raw, err := bson.Marshal(&Post{Title: "concurrent", ViewCount: n})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			doc, err := model.decodeDocument(context.Background(), raw)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if doc.Value().(*Post).ViewCount != n {
+				t.Errorf("unexpected document %v", doc.Value())
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	if sort, _ := stageValue(shared.pipeline(nil), "$sort"); len(sort.(bson.D)) != 1 {
+		t.Errorf("shared query sort changed: %v", sort)
+	}
+}
@@ -0,0 +1,181 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Count count documents matched by filter with default scopes and query scopes
+func (model *Model) Count(ctx context.Context, filter interface{}) (int64, error) {
+	return model.query().Count(ctx, filter)
+}
+
+// Exists check if a document matched by filter exists, with default scopes and query scopes
+func (model *Model) Exists(ctx context.Context, filter interface{}) (bool, error) {
+	return model.query().Exists(ctx, filter)
+}
+
+// Distinct the distinct values of a field in documents matched by filter, with default scopes and query scopes
+func (model *Model) Distinct(ctx context.Context, field string, filter interface{}) ([]interface{}, error) {
+	return model.query().Distinct(ctx, field, filter)
+}
+
+// EstimatedCount estimate the number of documents from the collection metadata, see Query.EstimatedCount
+func (model *Model) EstimatedCount(ctx context.Context) (int64, error) {
+	return model.query().EstimatedCount(ctx)
+}
+
+// Count count documents matched by filter with the scopes of the query, limit and skip are ignored
+func (q *Query) Count(ctx context.Context, filter interface{}) (total int64, err error) {
+	ctx, finish := q.model.startOperation(ctx, "Count")
+	defer func() { finish(err) }()
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if filter, err = q.scopeFilter(ctx, filter); err != nil {
+		return 0, err
+	}
+	total, err = q.model.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, q.model.translateError(err)
+	}
+	return total, nil
+}
+
+// Exists check if a document matched by filter exists, with the scopes of the query
+func (q *Query) Exists(ctx context.Context, filter interface{}) (exists bool, err error) {
+	ctx, finish := q.model.startOperation(ctx, "Exists")
+	defer func() { finish(err) }()
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if filter, err = q.scopeFilter(ctx, filter); err != nil {
+		return false, err
+	}
+	if _, err = q.model.collection.FindOne(ctx, filter); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, q.model.translateError(err)
+	}
+	return true, nil
+}
+
+// Distinct the distinct values of field, a dotted bson path, in documents matched by filter with the scopes of the query.
+// Values are decoded to the Go type of the struct field, or its element type for slices, null values are nil
+func (q *Query) Distinct(ctx context.Context, field string, filter interface{}) (values []interface{}, err error) {
+	ctx, finish := q.model.startOperation(ctx, "Distinct")
+	defer func() { finish(err) }()
+	t, ok := fieldType(q.model.typ, field)
+	if !ok {
+		return nil, fmt.Errorf("goose: unknown field %q of %s", field, q.model.collectionName)
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if filter, err = q.scopeFilter(ctx, filter); err != nil {
+		return nil, err
+	}
+	raw, err := q.model.collection.Distinct(ctx, field, filter)
+	if err != nil {
+		return nil, q.model.translateError(err)
+	}
+	values = make([]interface{}, 0, len(raw))
+	for _, v := range raw {
+		if v == nil {
+			values = append(values, nil)
+			continue
+		}
+		bsonType, data, err := bson.MarshalValue(v)
+		if err != nil {
+			return nil, err
+		}
+		value := reflect.New(t)
+		if err := (bson.RawValue{Type: bsonType, Value: data}).Unmarshal(value.Interface()); err != nil {
+			return nil, fmt.Errorf("goose: decode distinct value of %s.%s: %w", q.model.collectionName, field, err)
+		}
+		values = append(values, value.Elem().Interface())
+	}
+	return values, nil
+}
+
+// EstimatedCount estimate the number of documents from the collection metadata, the metadata can not honor
+// default and named scopes so they return an error, use Count or Unscoped. Query scopes added by plugins,
+// such as the tenant filter, can not be lifted and make it count documents like Count
+func (q *Query) EstimatedCount(ctx context.Context) (total int64, err error) {
+	ctx, finish := q.model.startOperation(ctx, "EstimatedCount")
+	defer func() { finish(err) }()
+	if scopes := q.appliedScopes(); len(scopes) > 0 {
+		return 0, fmt.Errorf("goose: EstimatedCount of %s ignores scopes %v, use Count or Unscoped", q.model.collectionName, scopes)
+	}
+	filter, err := q.scopeFilter(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if isNilFilter(filter) {
+		total, err = q.model.collection.EstimatedDocumentCount(ctx)
+	} else {
+		total, err = q.model.collection.CountDocuments(ctx, filter)
+	}
+	if err != nil {
+		return 0, q.model.translateError(err)
+	}
+	return total, nil
+}
+
+// fieldType the Go type of a dotted bson path in struct type t, slices are walked like mongo walks arrays
+func fieldType(t reflect.Type, path string) (reflect.Type, bool) {
+	for _, name := range strings.Split(path, ".") {
+		t = valueType(t)
+		if t.Kind() != reflect.Struct {
+			return nil, false
+		}
+		field, ok := structFieldType(t, name)
+		if !ok {
+			return nil, false
+		}
+		t = field
+	}
+	return valueType(t), true
+}
+
+// valueType dereference pointers and slices, except []byte
+func valueType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+		t = t.Elem()
+	}
+	return t
+}
+
+// structFieldType the type of the field with bson name in struct type t, inline structs are searched
+func structFieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tags, err := bsoncodec.DefaultStructTagParser(field)
+		if err != nil || tags.Skip {
+			continue
+		}
+		if tags.Inline {
+			if inline := valueType(field.Type); inline.Kind() == reflect.Struct {
+				if found, ok := structFieldType(inline, name); ok {
+					return found, true
+				}
+			}
+			continue
+		}
+		if tags.Name == name {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,114 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReaders(t *testing.T) {
+	type Address struct {
+		City string `bson:"city"`
+	}
+	type Ticket struct {
+		ID        int64      `goose:"primary" bson:"_id"`
+		Tenant    string     `goose:"tenant" bson:"tenant"`
+		Priority  int        `bson:"priority"`
+		Labels    []string   `bson:"labels"`
+		Address   *Address   `bson:"address"`
+		DeletedAt *time.Time `goose:"deletedAt" bson:"deletedAt,omitempty"`
+	}
+	NewMemoryDatabase()
+	tickets, err := NewModel("readerTickets", &Ticket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tickets.Scope("urgent", bson.M{"priority": bson.M{"$gte": 3}})
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+	for _, ticket := range []*Ticket{
+		{ID: 1, Priority: 1, Labels: []string{"bug"}, Address: &Address{City: "Paris"}},
+		{ID: 2, Priority: 3, Labels: []string{"bug", "ui"}, Address: &Address{City: "Oslo"}},
+		{ID: 3, Priority: 5, Labels: []string{"ops"}, Address: &Address{City: "Paris"}},
+	} {
+		if _, err := tickets.InsertOne(acme, ticket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tickets.InsertOne(globex, &Ticket{ID: 4, Priority: 4, Labels: []string{"billing"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tickets.SoftDeleteOne(acme, bson.M{"_id": int64(3)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := tickets.Count(acme, nil); err != nil || n != 2 {
+		t.Errorf("expected 2 visible tickets of acme, got %d %v", n, err)
+	}
+	if n, err := tickets.Unscoped().Count(acme, bson.M{"priority": bson.M{"$gt": 1}}); err != nil || n != 2 {
+		t.Errorf("expected 2 tickets with deleted ticket, got %d %v", n, err)
+	}
+	if n, err := tickets.Scoped("urgent").Count(acme, nil); err != nil || n != 1 {
+		t.Errorf("expected 1 urgent ticket, got %d %v", n, err)
+	}
+	if _, err := tickets.Count(context.Background(), nil); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+
+	if exists, err := tickets.Exists(acme, bson.M{"_id": int64(3)}); err != nil || exists {
+		t.Errorf("expected deleted ticket to be hidden, got %v %v", exists, err)
+	}
+	if exists, err := tickets.Unscoped().Exists(acme, bson.M{"_id": int64(3)}); err != nil || !exists {
+		t.Errorf("expected Unscoped to lift the softDelete scope, got %v %v", exists, err)
+	}
+	if exists, err := tickets.Exists(globex, bson.M{"_id": int64(4)}); err != nil || !exists {
+		t.Errorf("expected ticket 4 of globex, got %v %v", exists, err)
+	}
+	if exists, err := tickets.Exists(acme, bson.M{"_id": int64(4)}); err != nil || exists {
+		t.Errorf("expected ticket of globex to be hidden, got %v %v", exists, err)
+	}
+
+	labels, err := tickets.Distinct(acme, "labels", nil)
+	if err != nil || !reflect.DeepEqual(labels, []interface{}{"bug", "ui"}) {
+		t.Errorf("unexpected labels %v %v", labels, err)
+	}
+	priorities, err := tickets.Unscoped().Distinct(acme, "priority", nil)
+	if err != nil || !reflect.DeepEqual(priorities, []interface{}{1, 3, 5}) {
+		t.Errorf("expected int priorities, got %#v %v", priorities, err)
+	}
+	cities, err := tickets.Distinct(acme, "address.city", bson.M{"priority": 1})
+	if err != nil || !reflect.DeepEqual(cities, []interface{}{"Paris"}) {
+		t.Errorf("unexpected cities %v %v", cities, err)
+	}
+	if _, err := tickets.Distinct(acme, "address.zip", nil); err == nil {
+		t.Error("expected unknown field error")
+	}
+
+	// the softDelete default scope can not be honored by the collection metadata
+	if _, err := tickets.EstimatedCount(globex); err == nil {
+		t.Error("expected default scope error")
+	}
+	// the tenant scope makes EstimatedCount count documents
+	if n, err := tickets.Unscoped().EstimatedCount(globex); err != nil || n != 1 {
+		t.Errorf("expected 1 ticket of globex, got %d %v", n, err)
+	}
+	type Event struct {
+		ID int64 `goose:"primary" bson:"_id"`
+	}
+	events, err := NewModel("readerEvents", &Event{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= 3; id++ {
+		if _, err := events.InsertOne(context.Background(), &Event{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := events.EstimatedCount(context.Background()); err != nil || n != 3 {
+		t.Errorf("expected 3 events, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,145 @@
+package goose
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// schema parsed struct tags of a model type, it is shared by all models and documents of the same type
+type schema struct {
+	typ             reflect.Type
+	fields          []Field
+	primaryKey      string
+	primaryField    *Field
+	primaryType     reflect.Type
+	idGeneratorName string
+	indexes         []Field
+	uniqueIndexes   []Field
+	defaults        []Field
+	relationship    []Relation
+	versionField    *Field
+	sequences       []*sequenceField
+	// lazy schema upgrade
+	schemaVersionField *Field
+	schemaVersion      int64
+	writeBackUpgrades  bool
+	// tagged every goose tag option by name, read by plugins
+	tagged map[string][]TaggedField
+}
+
+// schemas parsed schema cache by struct type
+var schemas sync.Map
+
+// getSchema get the parsed schema of a struct type, parse it at the first time
+func getSchema(t reflect.Type) (*schema, error) {
+	if cached, ok := schemas.Load(t); ok {
+		return cached.(*schema), nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("goose: model value must be a pointer to struct, got %s", t)
+	}
+	s, err := parseSchema(t)
+	if err != nil {
+		return nil, err
+	}
+	s.setDefault()
+	cached, _ := schemas.LoadOrStore(t, s)
+	return cached.(*schema), nil
+}
+
+func (s *schema) setDefault() {
+	if s.primaryKey == "" {
+		s.primaryKey = "_id"
+		for i := range s.fields {
+			if s.fields[i].BsonName == s.primaryKey {
+				s.primaryField = &s.fields[i]
+			}
+		}
+	}
+	if s.primaryField != nil {
+		structField, _ := s.typ.FieldByName(s.primaryField.StructFieldName)
+		s.primaryType = structField.Type
+	} else {
+		s.primaryType = objectIDType
+	}
+	if s.idGeneratorName == "" && s.primaryType == objectIDType {
+		s.idGeneratorName = ObjectIDGenerator
+	}
+}
+
+// structFieldName find struct field name by bson name, return bson name if not found
+func (s *schema) structFieldName(bsonName string) string {
+	for _, field := range s.fields {
+		if field.BsonName == bsonName {
+			return field.StructFieldName
+		}
+	}
+	return bsonName
+}
+
+// structValue get the struct value of a model struct pointer, return invalid value if v is not
+func (s *schema) structValue(v interface{}) reflect.Value {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return value.Elem()
+}
+
+// primaryFieldValue get primary key field from a model struct pointer, return invalid value if not exists
+func (s *schema) primaryFieldValue(v interface{}) reflect.Value {
+	value := s.structValue(v)
+	if s.primaryField == nil || !value.IsValid() {
+		return reflect.Value{}
+	}
+	return value.FieldByName(s.primaryField.StructFieldName)
+}
+
+// applyDefaults set `goose:"default=..."` values to zero fields of v
+func (s *schema) applyDefaults(v interface{}) {
+	value := s.structValue(v)
+	if !value.IsValid() {
+		return
+	}
+	for _, field := range s.defaults {
+		fieldValue := value.FieldByName(field.StructFieldName)
+		if fieldValue.IsValid() && fieldValue.IsZero() && fieldValue.CanSet() {
+			fieldValue.Set(reflect.ValueOf(field.DefaultValue))
+		}
+	}
+}
+
+// ensureID generate primary key for v if it is zero value and the model has an id generator
+func (s *schema) ensureID(v interface{}) error {
+	field := s.primaryFieldValue(v)
+	if !field.IsValid() || !field.IsZero() || s.idGeneratorName == "" {
+		return nil
+	}
+	generator, ok := getIDGenerator(s.idGeneratorName)
+	if !ok {
+		return fmt.Errorf("goose: unknown id generator %q", s.idGeneratorName)
+	}
+	id, err := convertID(generator(), field.Type())
+	if err != nil {
+		return err
+	}
+	field.Set(reflect.ValueOf(id))
+	return nil
+}
+
+// idFilter build primary key filter with id in native go type
+func (s *schema) idFilter(id interface{}) (bson.M, error) {
+	primaryKeyValue, err := convertID(id, s.primaryType)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{s.primaryKey: primaryKeyValue}, nil
+}
+
+// newValue new a pointer to zero struct of the schema type
+func (s *schema) newValue() interface{} {
+	return reflect.New(s.typ).Interface()
+}
@@ -0,0 +1,101 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Scope register a named filter of the model, apply it by model.Scoped
+func (model *Model) Scope(name string, filter bson.M) {
+	model.ScopeFunc(name, func(ctx context.Context) (bson.M, error) {
+		return filter, nil
+	})
+}
+
+// ScopeFunc register a named filter built for each query, such as a filter of recent documents
+func (model *Model) ScopeFunc(name string, scope QueryScope) {
+	if model.namedScopes == nil {
+		model.namedScopes = map[string]QueryScope{}
+	}
+	model.namedScopes[name] = scope
+}
+
+// DefaultScope register a named filter applied to every find of the model unless Unscoped is used.
+// Updates and deletes are not filtered by default scopes
+func (model *Model) DefaultScope(name string, filter bson.M) {
+	model.Scope(name, filter)
+	model.defaultScopes = append(model.defaultScopes, name)
+}
+
+// Scoped apply named scopes to finds
+func (model *Model) Scoped(names ...string) *Query {
+	return model.query().Scoped(names...)
+}
+
+// Unscoped find without default scopes, query scopes added by plugins, such as the tenant filter, are still applied
+func (model *Model) Unscoped() *Query {
+	return model.query().Unscoped()
+}
+
+// Scoped apply named scopes to finds, a name not registered by model.Scope makes finds return an error
+func (q *Query) Scoped(names ...string) *Query {
+	cloned := q.clone()
+	for _, name := range names {
+		if !containsString(cloned.scopes, name) {
+			cloned.scopes = append(cloned.scopes, name)
+		}
+	}
+	return cloned
+}
+
+// Unscoped find without default scopes, named scopes applied by Scoped are kept
+func (q *Query) Unscoped() *Query {
+	cloned := q.clone()
+	cloned.unscoped = true
+	return cloned
+}
+
+// appliedScopes names of the default scopes and named scopes applied by the query
+func (q *Query) appliedScopes() []string {
+	var names []string
+	if !q.unscoped {
+		names = append(names, q.model.defaultScopes...)
+	}
+	return append(names, q.scopes...)
+}
+
+// scopeFilter add the conditions of default scopes, named scopes and query scopes to filter
+func (q *Query) scopeFilter(ctx context.Context, filter interface{}) (interface{}, error) {
+	names := q.appliedScopes()
+	if len(names) == 0 {
+		return q.model.scopeFilter(ctx, filter)
+	}
+	conditions := bson.A{}
+	if !isNilFilter(filter) {
+		conditions = append(conditions, filter)
+	}
+	applied := map[string]bool{}
+	for _, name := range names {
+		if applied[name] {
+			continue
+		}
+		applied[name] = true
+		scope, ok := q.model.namedScopes[name]
+		if !ok {
+			return nil, fmt.Errorf("goose: unknown scope %q of %s", name, q.model.collectionName)
+		}
+		condition, err := scope(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(condition) > 0 {
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(conditions) == 0 {
+		return q.model.scopeFilter(ctx, bson.M{})
+	}
+	return q.model.scopeFilter(ctx, bson.M{"$and": conditions})
+}
@@ -0,0 +1,82 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestScopes(t *testing.T) {
+	type Article struct {
+		ID          int64     `goose:"primary" bson:"_id"`
+		Title       string    `bson:"title"`
+		IsPublished bool      `bson:"isPublished"`
+		Archived    bool      `bson:"archived"`
+		CreatedAt   time.Time `bson:"createdAt"`
+	}
+	NewMemoryDatabase()
+	model, err := NewModel("scopedArticles", &Article{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.Scope("published", bson.M{"isPublished": true})
+	model.ScopeFunc("recent", func(ctx context.Context) (bson.M, error) {
+		return bson.M{"createdAt": bson.M{"$gte": time.Now().Add(-24 * time.Hour)}}, nil
+	})
+	model.DefaultScope("active", bson.M{"archived": false})
+	ctx := context.Background()
+	now := time.Now()
+	for _, article := range []*Article{
+		{ID: 1, Title: "new published", IsPublished: true, CreatedAt: now},
+		{ID: 2, Title: "old published", IsPublished: true, CreatedAt: now.Add(-48 * time.Hour)},
+		{ID: 3, Title: "draft", CreatedAt: now},
+		{ID: 4, Title: "archived", IsPublished: true, Archived: true, CreatedAt: now},
+	} {
+		if _, err := model.InsertOne(ctx, article); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(docs []*Document) []int64 {
+		var ids []int64
+		for _, doc := range docs {
+			ids = append(ids, doc.Value().(*Article).ID)
+		}
+		return ids
+	}
+
+	docs, err := model.Find(ctx, bson.M{})
+	if err != nil || len(docs) != 3 {
+		t.Errorf("expected default scope to hide the archived article, got %v %v", ids(docs), err)
+	}
+	docs, err = model.Scoped("published", "recent").Find(ctx, nil)
+	if err != nil || len(docs) != 1 || ids(docs)[0] != 1 {
+		t.Errorf("expected article 1, got %v %v", ids(docs), err)
+	}
+	docs, err = model.Scoped("published").Unscoped().Sort(bson.D{{Key: "_id", Value: 1}}).Find(ctx, bson.M{"_id": bson.M{"$gt": 1}})
+	if err != nil || len(docs) != 2 || ids(docs)[1] != 4 {
+		t.Errorf("expected articles 2 and 4, got %v %v", ids(docs), err)
+	}
+	if result, err := model.Scoped("published").FindAndCount(ctx, nil); err != nil || result.Total != 2 {
+		t.Errorf("expected 2 published active articles, got %+v %v", result, err)
+	}
+	if _, err := model.FindOneByID(ctx, 4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected archived article to be hidden, got %v", err)
+	}
+	if _, err := model.Unscoped().FindOne(ctx, bson.M{"_id": int64(4)}); err != nil {
+		t.Errorf("expected unscoped find of the archived article, got %v", err)
+	}
+	if _, err := model.Scoped("missing").Find(ctx, nil); err == nil {
+		t.Error("expected unknown scope error")
+	}
+
+	// save updates the archived article instead of inserting it again
+	if err := model.NewDocument(&Article{ID: 4, Title: "archived again", Archived: true}).Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := model.Unscoped().FindOne(ctx, bson.M{"_id": int64(4)}); err != nil || doc.Value().(*Article).Title != "archived again" {
+		t.Errorf("unexpected saved article %v", err)
+	}
+}
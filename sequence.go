@@ -0,0 +1,131 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CountersCollection collection storing the counters of sequence fields
+var CountersCollection = "counters"
+
+// sequenceField auto increment field, allocate value from counters collection on insert
+type sequenceField struct {
+	Field
+	// counter name, empty means COLLECTION.FIELD
+	name   string
+	start  int64
+	step   int64
+	prefix string
+	// zero padding width of the number for string fields
+	pad int
+}
+
+type counter struct {
+	Seq int64 `bson:"seq"`
+}
+
+func newSequenceField(field Field, name string) *sequenceField {
+	return &sequenceField{
+		Field: field,
+		name:  name,
+		start: 1,
+		step:  1,
+	}
+}
+
+// parseOption parse `start=N`, `step=N`, `prefix='INV-'` and `pad=N` tag options
+func (seq *sequenceField) parseOption(key string, value string) error {
+	var err error
+	switch key {
+	case seqStartTag:
+		seq.start, err = strconv.ParseInt(value, 10, 64)
+	case seqStepTag:
+		seq.step, err = strconv.ParseInt(value, 10, 64)
+	case seqPrefixTag:
+		seq.prefix = strings.Trim(value, "'")
+	case seqPadTag:
+		seq.pad, err = strconv.Atoi(value)
+	}
+	return err
+}
+
+func (seq *sequenceField) counterName(collectionName string) string {
+	if seq.name == "" {
+		return collectionName + "." + seq.BsonName
+	}
+	return seq.name
+}
+
+func (seq *sequenceField) format(n int64) string {
+	return fmt.Sprintf("%s%0*d", seq.prefix, seq.pad, n)
+}
+
+// nextSequence atomically allocate the next value of a counter
+func (model *Model) nextSequence(ctx context.Context, seq *sequenceField) (int64, error) {
+	counters := model.collection.Database().Collection(CountersCollection)
+	after := options.After
+	upsert := true
+	var raw bson.Raw
+	var err error
+	// concurrent upserts of a new counter may fail with duplicate key, retry once then the counter exists
+	for attempt := 0; attempt < 2; attempt++ {
+		raw, err = counters.FindOneAndUpdate(
+			ctx,
+			bson.M{"_id": seq.counterName(model.collectionName)},
+			bson.M{"$inc": bson.M{"seq": int64(1)}},
+			&options.FindOneAndUpdateOptions{
+				ReturnDocument: &after,
+				Upsert:         &upsert,
+			})
+		if _, duplicated := duplicateKeyMessage(err); !duplicated {
+			break
+		}
+	}
+	if err != nil {
+		return 0, model.translateError(err)
+	}
+	var result counter
+	if err := bson.Unmarshal(raw, &result); err != nil {
+		return 0, err
+	}
+	return seq.start + (result.Seq-1)*seq.step, nil
+}
+
+// ensureSequences allocate values for empty sequence fields of v
+func (model *Model) ensureSequences(ctx context.Context, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if len(model.sequences) == 0 || value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	for _, seq := range model.sequences {
+		field := value.Elem().FieldByName(seq.StructFieldName)
+		if !field.IsValid() || !field.IsZero() {
+			continue
+		}
+		n, err := model.nextSequence(ctx, seq)
+		if err != nil {
+			return err
+		}
+		switch {
+		case isIntKind(field.Kind()):
+			field.SetInt(n)
+		case isUintKind(field.Kind()):
+			if n < 0 {
+				return fmt.Errorf("goose: sequence %s allocated negative value %d for %s", seq.counterName(model.collectionName), n, seq.StructFieldName)
+			}
+			field.SetUint(uint64(n))
+		case field.Kind() == reflect.String:
+			field.SetString(seq.format(n))
+		default:
+			return errors.New("goose: sequence field " + seq.StructFieldName + " must be int, uint or string")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package goose
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSequenceTagParse(t *testing.T) {
+	type Invoice struct {
+		Number string `goose:"seq=invoices,start=1000,step=10,prefix='INV-',pad=6" bson:"number"`
+		Ticket int64  `goose:"autoinc" bson:"ticket"`
+	}
+	model := newTestModel("Invoices", &Invoice{})
+	if len(model.sequences) != 2 {
+		t.Fatalf("expected 2 sequences, got %d", len(model.sequences))
+	}
+	number := model.sequences[0]
+	if number.name != "invoices" || number.start != 1000 || number.step != 10 {
+		t.Errorf("unexpected sequence %+v", number)
+	}
+	if v := number.format(1010); v != "INV-001010" {
+		t.Errorf("unexpected format %s", v)
+	}
+	ticket := model.sequences[1]
+	if ticket.counterName(model.collectionName) != "Invoices.ticket" || ticket.start != 1 || ticket.step != 1 {
+		t.Errorf("unexpected sequence %+v", ticket)
+	}
+}
+
+func TestSequenceInsert(t *testing.T) {
+	type Invoice struct {
+		ID     int64  `goose:"primary,autoinc" bson:"_id"`
+		Number string `goose:"seq=invoiceNumbers,start=1000,step=10,prefix='INV-',pad=6" bson:"number"`
+	}
+	NewMemoryDatabase()
+	invoices, err := NewModel("seqInvoices", &Invoice{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	first := &Invoice{}
+	if _, err := invoices.InsertOne(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second := &Invoice{}
+	if _, err := invoices.InsertOne(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != 1 || first.Number != "INV-001000" || second.ID != 2 || second.Number != "INV-001010" {
+		t.Errorf("unexpected sequence values %+v %+v", first, second)
+	}
+	// a set value is kept and does not use the counter
+	manual := &Invoice{ID: 100, Number: "MANUAL"}
+	if _, err := invoices.InsertOne(ctx, manual); err != nil {
+		t.Fatal(err)
+	}
+	third := &Invoice{}
+	if _, err := invoices.InsertOne(ctx, third); err != nil {
+		t.Fatal(err)
+	}
+	if manual.Number != "MANUAL" || third.ID != 3 || third.Number != "INV-001020" {
+		t.Errorf("unexpected sequence values %+v %+v", manual, third)
+	}
+}
+
+func TestSequenceConcurrentInsert(t *testing.T) {
+	type Ticket struct {
+		ID     primitive.ObjectID `goose:"primary" bson:"_id"`
+		Number int64              `goose:"autoinc" bson:"number"`
+	}
+	NewMemoryDatabase()
+	tickets, err := NewModel("seqTickets", &Ticket{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 50
+	numbers := make(chan int64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticket := &Ticket{}
+			if _, err := tickets.InsertOne(context.Background(), ticket); err != nil {
+				t.Error(err)
+				return
+			}
+			numbers <- ticket.Number
+		}()
+	}
+	wg.Wait()
+	close(numbers)
+	seen := map[int64]bool{}
+	for number := range numbers {
+		if seen[number] || number < 1 || number > n {
+			t.Errorf("duplicated or out of range number %d", number)
+		}
+		seen[number] = true
+	}
+	if len(seen) != n {
+		t.Errorf("expected %d unique numbers, got %d", n, len(seen))
+	}
+}
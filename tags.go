@@ -1,15 +1,12 @@
 package goose
 
 import (
-	"context"
-	"log"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsoncodec"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const tagName = "goose"
@@ -17,98 +14,117 @@ const tagName = "goose"
 const (
 	// field level tags
 	indexTag      = "index"
+	uniqueTag     = "unique"
 	primaryKeyTag = "primary"
+	idGenTag      = "gen"
 	defaultTag    = "default"
-	// time
+	// time, handled by the timestamps and soft delete plugins
 	createdAtTag = "createdAt"
 	updatedAtTag = "updatedAt"
 	deletedAtTag = "deletedAt"
+	// optimistic concurrency
+	versionTag = "version"
+	// multi-tenancy, handled by the tenant plugin
+	tenantTag = "tenant"
+	// lazy schema upgrade
+	schemaVersionTag = "schemaVersion"
+	writeBackTag     = "writeBack"
+	// sequence
+	autoIncTag   = "autoinc"
+	seqTag       = "seq"
+	seqStartTag  = "start"
+	seqStepTag   = "step"
+	seqPrefixTag = "prefix"
+	seqPadTag    = "pad"
 	// row level tags
 	refTag       = "ref"
 	forignKeyTag = "forignKey"
 	populateTag  = "populate"
 )
 
-func (model *Model) structTagParse() {
-	val := reflect.ValueOf(model.curValue).Elem()
-	for i := 0; i < val.NumField(); i++ {
-		valueField := val.Field(i)
-		typeField := val.Type().Field(i)
+// parseSchema parse goose tags of struct type t
+func parseSchema(t reflect.Type) (*schema, error) {
+	s := &schema{typ: t}
+	for i := 0; i < t.NumField(); i++ {
+		typeField := t.Field(i)
 		tag := typeField.Tag.Get(tagName)
 
 		bsonTags, err := bsoncodec.DefaultStructTagParser(typeField)
 		if err != nil {
 			continue
 		}
+		field := Field{
+			BsonName:        bsonTags.Name,
+			StructFieldName: typeField.Name,
+		}
+		if !bsonTags.Skip {
+			s.fields = append(s.fields, field)
+		}
 
 		//Skip if tag is not defined or ignored
 		if tag == "" || tag == "-" {
 			continue
 		}
 
+		var seq *sequenceField
+		var seqOptions [][2]string
 		for _, arg := range strings.Split(tag, ",") {
 			tagKey := arg
 			var tagVal string
 			if strings.Contains(string(arg), "=") {
-				tagKey = strings.Split(arg, "=")[0]
-				tagVal = strings.Split(arg, "=")[1]
+				kv := strings.SplitN(arg, "=", 2)
+				tagKey, tagVal = kv[0], kv[1]
+			}
+			if s.tagged == nil {
+				s.tagged = map[string][]TaggedField{}
 			}
+			s.tagged[tagKey] = append(s.tagged[tagKey], TaggedField{Field: field, Value: tagVal})
 			switch tagKey {
+			case autoIncTag, seqTag:
+				seq = newSequenceField(field, tagVal)
+			case seqStartTag, seqStepTag, seqPrefixTag, seqPadTag:
+				seqOptions = append(seqOptions, [2]string{tagKey, tagVal})
 			case primaryKeyTag:
-				// model.primaryKey = typeField.Name
-				model.primaryKey = bsonTags.Name
-				model.primaryKeyValue = valueField.Interface()
+				s.primaryKey = bsonTags.Name
+				primaryField := field
+				s.primaryField = &primaryField
+			case idGenTag:
+				s.idGeneratorName = tagVal
 			case indexTag:
-				_, err := model.collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
-					Keys: bson.D{{bsonTags.Name, 1}},
-				})
+				s.indexes = append(s.indexes, field)
+			case uniqueTag:
+				s.uniqueIndexes = append(s.uniqueIndexes, field)
+			case defaultTag:
+				defaultField := field
+				defaultField.DefaultValue, err = parseDefaultValue(typeField.Type, tagVal)
 				if err != nil {
-					log.Fatal(err)
-					continue
+					return nil, fmt.Errorf("goose: invalid default value of %s.%s: %w", t.Name(), typeField.Name, err)
 				}
-			case defaultTag:
-				if reflect.ValueOf(model.curValue).Elem().FieldByName(typeField.Name).IsZero() {
-					switch valueField.Kind() {
-					case reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
-						iVal, err := strconv.ParseInt(tagVal, 10, 64)
-						if err != nil {
-							log.Fatal(err)
-							continue
-						}
-						valueField.SetInt(iVal)
-					case reflect.Float32, reflect.Float64:
-						fVal, err := strconv.ParseFloat(tagVal, 64)
-						if err != nil {
-							log.Fatal(err)
-							continue
-						}
-						valueField.SetFloat(fVal)
-					case reflect.String:
-						valueField.SetString(strings.Trim(tagVal, "'"))
-					case reflect.Bool:
-						bVal, err := strconv.ParseBool(tagVal)
-						if err != nil {
-							log.Fatal(err)
-							continue
-						}
-						valueField.SetBool(bVal)
-					}
+				if defaultField.DefaultValue != nil {
+					s.defaults = append(s.defaults, defaultField)
 				}
-			case createdAtTag:
-				model.modelTime.createdAtField = &Field{
-					BsonName:        bsonTags.Name,
-					StructFieldName: typeField.Name,
+			case versionTag:
+				if !isIntKind(typeField.Type.Kind()) {
+					return nil, fmt.Errorf("goose: version field %s.%s must be int", t.Name(), typeField.Name)
 				}
-			case updatedAtTag:
-				model.modelTime.updatedAtField = &Field{
-					BsonName:        bsonTags.Name,
-					StructFieldName: typeField.Name,
+				versionField := field
+				s.versionField = &versionField
+			case schemaVersionTag:
+				if !isIntKind(typeField.Type.Kind()) {
+					return nil, fmt.Errorf("goose: schemaVersion field %s.%s must be int", t.Name(), typeField.Name)
 				}
-			case deletedAtTag:
-				model.modelTime.deletedAtField = &Field{
-					BsonName:        bsonTags.Name,
-					StructFieldName: typeField.Name,
+				s.schemaVersion, err = strconv.ParseInt(tagVal, 10, 64)
+				if err != nil || s.schemaVersion < 1 {
+					return nil, fmt.Errorf("goose: invalid schemaVersion of %s.%s, expected schemaVersion=N and N >= 1", t.Name(), typeField.Name)
 				}
+				schemaVersionField := field
+				s.schemaVersionField = &schemaVersionField
+				// new documents are written in the current version
+				defaultField := field
+				defaultField.DefaultValue = reflect.ValueOf(s.schemaVersion).Convert(typeField.Type).Interface()
+				s.defaults = append(s.defaults, defaultField)
+			case writeBackTag:
+				s.writeBackUpgrades = true
 			case populateTag:
 				ref, ok := typeField.Tag.Lookup(refTag)
 				if !ok {
@@ -118,14 +134,52 @@ func (model *Model) structTagParse() {
 				if !ok {
 					forignKey = "_id"
 				}
-				model.relationship = append(model.relationship, Relation{
-					from: ref,
-					as:   tagVal,
-					// localField: typeField.Name,
+				s.relationship = append(s.relationship, Relation{
+					from:         ref,
+					as:           tagVal,
 					localField:   bsonTags.Name,
 					foreignField: forignKey,
 				})
 			}
 		}
+		if seq != nil {
+			for _, option := range seqOptions {
+				if err := seq.parseOption(option[0], option[1]); err != nil {
+					return nil, fmt.Errorf("goose: invalid sequence option %s of %s.%s: %w", option[0], t.Name(), typeField.Name, err)
+				}
+			}
+			s.sequences = append(s.sequences, seq)
+		}
+	}
+	return s, nil
+}
+
+// parseDefaultValue convert `default=` tag value to field type t, return nil for unsupported types
+func parseDefaultValue(t reflect.Type, tagVal string) (interface{}, error) {
+	var value reflect.Value
+	switch {
+	case isIntKind(t.Kind()):
+		iVal, err := strconv.ParseInt(tagVal, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		value = reflect.ValueOf(iVal)
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		fVal, err := strconv.ParseFloat(tagVal, 64)
+		if err != nil {
+			return nil, err
+		}
+		value = reflect.ValueOf(fVal)
+	case t.Kind() == reflect.String:
+		value = reflect.ValueOf(strings.Trim(tagVal, "'"))
+	case t.Kind() == reflect.Bool:
+		bVal, err := strconv.ParseBool(tagVal)
+		if err != nil {
+			return nil, err
+		}
+		value = reflect.ValueOf(bVal)
+	default:
+		return nil, nil
 	}
+	return value.Convert(t).Interface(), nil
 }
@@ -0,0 +1,499 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type tenantKey struct{}
+
+// WithTenant attach the tenant of a request to ctx, models with a `goose:"tenant"` field and tenant databases read it
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext return the tenant attached by WithTenant, ok is false if there is no tenant or it is empty
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+func requireTenant(ctx context.Context) (string, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	return tenant, nil
+}
+
+// tenantPlugin fill the `goose:"tenant"` field from the context on insert and update,
+// and add it to every filter, so a tenant can not read or write documents of others
+type tenantPlugin struct{}
+
+func (tenantPlugin) Name() string {
+	return "tenant"
+}
+
+func (tenantPlugin) Apply(model *Model) error {
+	fields := model.TaggedFields(tenantTag)
+	if len(fields) == 0 {
+		return nil
+	}
+	if len(fields) > 1 {
+		return errors.New("only one tenant field is allowed")
+	}
+	field := fields[0]
+	if structField, _ := model.typ.FieldByName(field.StructFieldName); structField.Type.Kind() != reflect.String {
+		return fmt.Errorf("tenant field %s must be string", field.StructFieldName)
+	}
+	model.AddHook(BeforeInsert, func(ctx context.Context, w *Write) error {
+		tenant, err := requireTenant(ctx)
+		if err != nil {
+			return err
+		}
+		if value := model.structValue(w.Value); value.IsValid() {
+			if current := value.FieldByName(field.StructFieldName).String(); current != "" && current != tenant {
+				return fmt.Errorf("goose: document tenant %q does not match the context tenant %q", current, tenant)
+			}
+		}
+		w.Set(field.BsonName, tenant)
+		return nil
+	})
+	// documents can not be moved to another tenant
+	model.AddHook(BeforeUpdate, func(ctx context.Context, w *Write) error {
+		tenant, err := requireTenant(ctx)
+		if err != nil {
+			return err
+		}
+		w.Set(field.BsonName, tenant)
+		return nil
+	})
+	model.AddQueryScope(func(ctx context.Context) (bson.M, error) {
+		tenant, err := requireTenant(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{field.BsonName: tenant}, nil
+	})
+	model.AddIndex(Index{Name: field.BsonName + "_1", Keys: bson.D{{Key: field.BsonName, Value: int32(1)}}})
+	return nil
+}
+
+// scopeLookups add the query scopes of related models to $lookup, $graphLookup and $unionWith stages, so joined
+// documents are scoped too. Sub-pipelines of $lookup, $unionWith and $facet are scoped as well
+func (model *Model) scopeLookups(ctx context.Context, pipeline mongo.Pipeline) (mongo.Pipeline, error) {
+	stages, err := toPipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return scopeStages(ctx, stages)
+}
+
+func scopeStages(ctx context.Context, stages []bson.D) ([]bson.D, error) {
+	for i, stage := range stages {
+		name, value := stage[0].Key, stage[0].Value
+		var err error
+		switch name {
+		case "$lookup":
+			value, err = scopeLookup(ctx, value)
+		case "$graphLookup":
+			value, err = scopeGraphLookup(ctx, value)
+		case "$unionWith":
+			value, err = scopeUnionWith(ctx, value)
+		case "$facet":
+			value, err = scopeFacet(ctx, value)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stages[i] = bson.D{{Key: name, Value: value}}
+	}
+	return stages, nil
+}
+
+// scopeLookup scope the sub-pipeline of a $lookup and add the query scopes of the model of its from collection
+// as the first $match of the sub-pipeline
+func scopeLookup(ctx context.Context, value interface{}) (interface{}, error) {
+	spec, ok := value.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("goose: $lookup needs a document, got %T", value)
+	}
+	from, _ := lookupValue(spec, "from")
+	return scopeSubPipeline(ctx, "$lookup", spec, stringValue(from))
+}
+
+// scopeUnionWith scope the pipeline of a $unionWith like scopeLookup, a collection name is turned into a document
+func scopeUnionWith(ctx context.Context, value interface{}) (interface{}, error) {
+	if coll, ok := value.(string); ok {
+		value = bson.D{{Key: "coll", Value: coll}}
+	}
+	spec, ok := value.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("goose: $unionWith needs a collection name or a document, got %T", value)
+	}
+	coll, _ := lookupValue(spec, "coll")
+	return scopeSubPipeline(ctx, "$unionWith", spec, stringValue(coll))
+}
+
+func scopeSubPipeline(ctx context.Context, name string, spec bson.D, from string) (bson.D, error) {
+	var stages []bson.D
+	if pipeline, ok := lookupValue(spec, "pipeline"); ok {
+		var err error
+		if stages, err = subPipeline(name, pipeline); err != nil {
+			return nil, err
+		}
+		if stages, err = scopeStages(ctx, stages); err != nil {
+			return nil, err
+		}
+	}
+	if related, ok := registeredCollectionModel(from); ok && len(related.scopes) > 0 {
+		conditions, err := related.scopeFilter(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		stages = append([]bson.D{{{Key: "$match", Value: conditions}}}, stages...)
+	}
+	if stages == nil {
+		return spec, nil
+	}
+	return setFields(append(bson.D(nil), spec...), bson.D{{Key: "pipeline", Value: stagesArray(stages)}}), nil
+}
+
+// scopeGraphLookup add the query scopes of the model of the from collection to restrictSearchWithMatch
+func scopeGraphLookup(ctx context.Context, value interface{}) (interface{}, error) {
+	spec, ok := value.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("goose: $graphLookup needs a document, got %T", value)
+	}
+	from, _ := lookupValue(spec, "from")
+	related, ok := registeredCollectionModel(stringValue(from))
+	if !ok || len(related.scopes) == 0 {
+		return spec, nil
+	}
+	restrict, _ := lookupValue(spec, "restrictSearchWithMatch")
+	restrict, err := related.scopeFilter(ctx, restrict)
+	if err != nil {
+		return nil, err
+	}
+	return setFields(append(bson.D(nil), spec...), bson.D{{Key: "restrictSearchWithMatch", Value: restrict}}), nil
+}
+
+// scopeFacet scope the sub-pipelines of a $facet
+func scopeFacet(ctx context.Context, value interface{}) (interface{}, error) {
+	spec, ok := value.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("goose: $facet needs a document, got %T", value)
+	}
+	scoped := make(bson.D, len(spec))
+	for i, facet := range spec {
+		stages, err := subPipeline("$facet", facet.Value)
+		if err != nil {
+			return nil, err
+		}
+		if stages, err = scopeStages(ctx, stages); err != nil {
+			return nil, err
+		}
+		scoped[i] = bson.E{Key: facet.Key, Value: stagesArray(stages)}
+	}
+	return scoped, nil
+}
+
+// subPipeline the stages of a pipeline nested in a normalized stage
+func subPipeline(name string, pipeline interface{}) ([]bson.D, error) {
+	array, ok := pipeline.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("goose: pipeline of %s must be an array of stages, got %T", name, pipeline)
+	}
+	stages := make([]bson.D, len(array))
+	for i, stage := range array {
+		doc, ok := stage.(bson.D)
+		if !ok || len(doc) != 1 {
+			return nil, fmt.Errorf("goose: pipeline stage of %s must be a document with one key, got %v", name, stage)
+		}
+		stages[i] = doc
+	}
+	return stages, nil
+}
+
+func stagesArray(stages []bson.D) bson.A {
+	array := make(bson.A, len(stages))
+	for i, stage := range stages {
+		array[i] = stage
+	}
+	return array
+}
+
+// relatedScopeFilter add the query scopes of the model of a related collection to filter
+func relatedScopeFilter(ctx context.Context, from string, filter interface{}) (interface{}, error) {
+	related, ok := registeredCollectionModel(from)
+	if !ok {
+		return filter, nil
+	}
+	return related.scopeFilter(ctx, filter)
+}
+
+// TenantResolver resolve the mongo database of a tenant
+type TenantResolver func(ctx context.Context, tenant string) (*mongo.Database, error)
+
+// NewTenantDatabase new a database-per-tenant database, models created after it use the database resolved
+// for the tenant of the context of each operation and return ErrNoTenant without it.
+// Indexes created without a tenant, such as by NewModel, are created in each tenant database when it is first used
+func NewTenantDatabase(resolve TenantResolver) *Database {
+	db := &Database{
+		Context: context.Background(),
+		logger:  GetLogger(),
+		backend: newTenantBackend(func(ctx context.Context, tenant string) (backend, error) {
+			tenantDB, err := resolve(ctx, tenant)
+			if err != nil {
+				return nil, err
+			}
+			return mongoBackend{tenantDB}, nil
+		}),
+	}
+	DB = nil
+	defaultDatabase = db
+	return db
+}
+
+// tenantBackend backend resolving the backend of the tenant in the context of every collection operation
+type tenantBackend struct {
+	resolve func(ctx context.Context, tenant string) (backend, error)
+	mu      sync.Mutex
+	// indexes created without a tenant by collection name
+	indexes map[string][]mongo.IndexModel
+	// ensured tenant backends with the indexes created, by collection name and backend name
+	ensured map[string]map[string]bool
+}
+
+func newTenantBackend(resolve func(ctx context.Context, tenant string) (backend, error)) *tenantBackend {
+	return &tenantBackend{resolve: resolve, indexes: map[string][]mongo.IndexModel{}, ensured: map[string]map[string]bool{}}
+}
+
+func (b *tenantBackend) Name() string {
+	return "tenant"
+}
+
+func (b *tenantBackend) Collection(name string) collection {
+	return &tenantCollection{backend: b, name: name}
+}
+
+// collection resolve the collection of the tenant of ctx and create the indexes waiting for it
+func (b *tenantBackend) collection(ctx context.Context, name string) (collection, error) {
+	tenant, err := requireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := b.resolve(ctx, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("goose: resolve database of tenant %s: %w", tenant, err)
+	}
+	coll := resolved.Collection(name)
+	b.mu.Lock()
+	indexes := b.indexes[name]
+	ensured := b.ensured[name][resolved.Name()]
+	b.mu.Unlock()
+	if !ensured {
+		for _, index := range indexes {
+			if _, err := coll.CreateIndex(ctx, index); err != nil {
+				return nil, err
+			}
+		}
+		b.mu.Lock()
+		if b.ensured[name] == nil {
+			b.ensured[name] = map[string]bool{}
+		}
+		b.ensured[name][resolved.Name()] = true
+		b.mu.Unlock()
+	}
+	return coll, nil
+}
+
+// tenantCollection collection resolved by tenantBackend for every operation
+type tenantCollection struct {
+	backend *tenantBackend
+	name    string
+}
+
+func (c *tenantCollection) Name() string {
+	return c.name
+}
+
+func (c *tenantCollection) Database() backend {
+	return c.backend
+}
+
+func (c *tenantCollection) InsertOne(ctx context.Context, document interface{}) (*mongo.InsertOneResult, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.InsertOne(ctx, document)
+}
+
+func (c *tenantCollection) FindOne(ctx context.Context, filter interface{}) (bson.Raw, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.FindOne(ctx, filter)
+}
+
+func (c *tenantCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (cursor, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.Find(ctx, filter, opts...)
+}
+
+func (c *tenantCollection) Aggregate(ctx context.Context, pipeline interface{}) (cursor, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.Aggregate(ctx, pipeline)
+}
+
+func (c *tenantCollection) CountDocuments(ctx context.Context, filter interface{}) (int64, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return 0, err
+	}
+	return coll.CountDocuments(ctx, filter)
+}
+
+func (c *tenantCollection) EstimatedDocumentCount(ctx context.Context) (int64, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return 0, err
+	}
+	return coll.EstimatedDocumentCount(ctx)
+}
+
+func (c *tenantCollection) Distinct(ctx context.Context, field string, filter interface{}) ([]interface{}, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.Distinct(ctx, field, filter)
+}
+
+func (c *tenantCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) (bson.Raw, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.FindOneAndUpdate(ctx, filter, update, opts...)
+}
+
+func (c *tenantCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.UpdateOne(ctx, filter, update, opts...)
+}
+
+func (c *tenantCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.UpdateMany(ctx, filter, update, opts...)
+}
+
+func (c *tenantCollection) DeleteOne(ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.DeleteOne(ctx, filter)
+}
+
+func (c *tenantCollection) DeleteMany(ctx context.Context, filter interface{}) (*mongo.DeleteResult, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.DeleteMany(ctx, filter)
+}
+
+func (c *tenantCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.BulkWrite(ctx, models, opts...)
+}
+
+// CreateIndex create the index in the tenant database of ctx, without a tenant the index is created
+// in every tenant database when it is first used
+func (c *tenantCollection) CreateIndex(ctx context.Context, index mongo.IndexModel) (string, error) {
+	if _, ok := TenantFromContext(ctx); ok {
+		coll, err := c.backend.collection(ctx, c.name)
+		if err != nil {
+			return "", err
+		}
+		return coll.CreateIndex(ctx, index)
+	}
+	keys, err := toDocument(index.Keys)
+	if err != nil {
+		return "", err
+	}
+	c.backend.mu.Lock()
+	defer c.backend.mu.Unlock()
+	// an index created again with the same name or keys replaces the waiting one
+	var indexes []mongo.IndexModel
+	for _, existing := range c.backend.indexes[c.name] {
+		existingKeys, _ := toDocument(existing.Keys)
+		if (indexModelName(index) != "" && indexModelName(existing) == indexModelName(index)) ||
+			sameIndex(Index{Keys: existingKeys}, Index{Keys: keys}) {
+			continue
+		}
+		indexes = append(indexes, existing)
+	}
+	c.backend.indexes[c.name] = append(indexes, index)
+	delete(c.backend.ensured, c.name)
+	return indexModelName(index), nil
+}
+
+// indexModelName the name set in index options, empty for the default name
+func indexModelName(index mongo.IndexModel) string {
+	if index.Options != nil && index.Options.Name != nil {
+		return *index.Options.Name
+	}
+	return ""
+}
+
+func (c *tenantCollection) ListIndexes(ctx context.Context) ([]Index, error) {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	return coll.ListIndexes(ctx)
+}
+
+func (c *tenantCollection) DropIndex(ctx context.Context, name string) error {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return err
+	}
+	return coll.DropIndex(ctx, name)
+}
+
+func (c *tenantCollection) SetValidator(ctx context.Context, validator bson.D, level string, action string) error {
+	coll, err := c.backend.collection(ctx, c.name)
+	if err != nil {
+		return err
+	}
+	return coll.SetValidator(ctx, validator, level, action)
+}
@@ -0,0 +1,271 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTenantScope(t *testing.T) {
+	type Account struct {
+		ID     int64  `goose:"primary" bson:"_id"`
+		Tenant string `goose:"tenant" bson:"tenant"`
+		Name   string `bson:"name"`
+	}
+	type Invoice struct {
+		ID        int64  `goose:"primary" bson:"_id"`
+		Tenant    string `goose:"tenant" bson:"tenant"`
+		AccountID int64  `goose:"populate=Account" bson:"accountId" ref:"tenantAccounts"`
+		Amount    int    `bson:"amount"`
+	}
+	NewMemoryDatabase()
+	accounts, err := NewModel("tenantAccounts", &Account{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoices, err := NewModel("tenantInvoices", &Invoice{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+
+	if _, err := accounts.InsertOne(context.Background(), &Account{ID: 1}); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+	if _, err := accounts.InsertOne(acme, &Account{ID: 1, Tenant: "globex"}); err == nil {
+		t.Error("expected tenant mismatch error")
+	}
+	account := &Account{ID: 1, Name: "acme account"}
+	if _, err := accounts.InsertOne(acme, account); err != nil {
+		t.Fatal(err)
+	}
+	if account.Tenant != "acme" {
+		t.Errorf("expected tenant to be filled, got %q", account.Tenant)
+	}
+	if _, err := invoices.InsertOne(acme, &Invoice{ID: 1, AccountID: 1, Amount: 10}); err != nil {
+		t.Fatal(err)
+	}
+	// globex references the account of acme
+	if _, err := invoices.InsertOne(globex, &Invoice{ID: 2, AccountID: 1, Amount: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := invoices.Find(context.Background(), bson.M{}); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+	if _, err := invoices.FindOneByID(globex, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected invoice of acme to be hidden, got %v", err)
+	}
+	docs, err := invoices.Populate().Find(globex, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var related []Account
+	if len(docs) != 1 || docs[0].Populated("Account", &related) != nil || len(related) != 0 {
+		t.Errorf("expected 1 invoice without populated account of acme, got %d %v", len(docs), related)
+	}
+	if err := docs[0].Populate(globex); err != nil {
+		t.Fatal(err)
+	}
+	if err := docs[0].Populated("Account", &related); err != nil || len(related) != 0 {
+		t.Errorf("expected no populated account of acme, got %v %v", related, err)
+	}
+	docs, err = invoices.Populate().Find(acme, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Populated("Account", &related) != nil || len(related) != 1 {
+		t.Errorf("expected 1 invoice with populated account, got %d %v", len(docs), related)
+	}
+
+	if result, err := invoices.UpdateMany(globex, bson.M{}, bson.M{"$set": bson.M{"tenant": "acme"}}); err != nil || result.MatchedCount != 1 {
+		t.Fatalf("update: %+v %v", result, err)
+	}
+	if doc, err := invoices.FindOneByID(globex, 2); err != nil || doc.Value().(*Invoice).Tenant != "globex" {
+		t.Errorf("expected the invoice to stay in globex, got %v", err)
+	}
+	if result, err := invoices.DeleteMany(acme, bson.M{}); err != nil || result.DeletedCount != 1 {
+		t.Errorf("expected to delete 1 invoice of acme, got %+v %v", result, err)
+	}
+
+	// bulk write inserts run the insert hooks of struct and raw documents
+	if _, err := invoices.BulkWrite(acme, []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": int64(3), "tenant": "globex"}),
+	}); err == nil {
+		t.Error("expected tenant mismatch error of bulk insert")
+	}
+	if _, err := invoices.BulkWrite(context.Background(), []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(&Invoice{ID: 3}),
+	}); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant of bulk insert, got %v", err)
+	}
+	if _, err := invoices.BulkWrite(acme, []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": int64(3), "amount": 30, "note": "raw"}),
+		mongo.NewInsertOneModel().SetDocument(&Invoice{ID: 4, Amount: 40}),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := invoices.FindOneByID(acme, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note, _ := doc.Raw().Lookup("note").StringValueOK(); doc.Value().(*Invoice).Tenant != "acme" || note != "raw" {
+		t.Errorf("expected tenant filled and unknown field kept, got %s", doc.Raw())
+	}
+	if n, err := invoices.Count(acme, nil); err != nil || n != 2 {
+		t.Errorf("expected 2 bulk inserted invoices of acme, got %d %v", n, err)
+	}
+}
+
+func TestTenantDatabase(t *testing.T) {
+	type Setting struct {
+		Key   string `goose:"primary,unique" bson:"_id"`
+		Value string `bson:"value"`
+	}
+	backends := map[string]*memoryBackend{}
+	db := &Database{
+		Context: context.Background(),
+		logger:  GetLogger(),
+		backend: newTenantBackend(func(ctx context.Context, tenant string) (backend, error) {
+			if tenant == "unknown" {
+				return nil, errors.New("no database")
+			}
+			if backends[tenant] == nil {
+				backends[tenant] = newMemoryBackend("tenant_" + tenant)
+			}
+			return backends[tenant], nil
+		}),
+	}
+	defaultDatabase = db
+	settings, err := NewModel("settings", &Setting{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+	if _, err := settings.InsertOne(acme, &Setting{Key: "theme", Value: "dark"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := settings.InsertOne(globex, &Setting{Key: "theme", Value: "light"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := settings.FindOneByID(context.Background(), "theme"); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+	if _, err := settings.FindOneByID(WithTenant(context.Background(), "unknown"), "theme"); err == nil {
+		t.Error("expected resolve error")
+	}
+	doc, err := settings.FindOneByID(acme, "theme")
+	if err != nil || doc.Value().(*Setting).Value != "dark" {
+		t.Errorf("expected the setting of acme, got %v", err)
+	}
+	indexes, err := db.collection("settings").ListIndexes(globex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 2 || indexes[1].Name != "_id_1" {
+		t.Errorf("expected indexes of NewModel in tenant database, got %v", indexes)
+	}
+
+	// indexes created again wait once, and only invalidate the indexes ensured for their own collection
+	tenants := db.backend.(*tenantBackend)
+	for i := 0; i < 2; i++ {
+		if _, err := db.collection("settings").CreateIndex(context.Background(), mongo.IndexModel{Keys: bson.D{{Key: "value", Value: 1}}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.collection("audit.settings").CreateIndex(context.Background(), mongo.IndexModel{Keys: bson.D{{Key: "value", Value: 1}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pending := tenants.indexes["settings"]; len(pending) != 2 {
+		t.Errorf("expected the value index once, got %v", pending)
+	}
+	if _, err := settings.FindOneByID(acme, "theme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.collection("audit.settings").CreateIndex(context.Background(), mongo.IndexModel{Keys: bson.D{{Key: "key", Value: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if !tenants.ensured["settings"]["tenant_acme"] {
+		t.Error("expected settings indexes of acme to stay ensured")
+	}
+	if indexes, err := db.collection("settings").ListIndexes(acme); err != nil || len(indexes) != 3 {
+		t.Errorf("expected the value index in tenant database, got %v, %v", indexes, err)
+	}
+}
+
+func TestScopeLookups(t *testing.T) {
+	type Member struct {
+		ID     int64  `goose:"primary" bson:"_id"`
+		Tenant string `goose:"tenant" bson:"tenant"`
+		Name   string `bson:"name"`
+	}
+	NewMemoryDatabase()
+	members, err := NewModel("scopedMembers", &Member{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := WithTenant(context.Background(), "acme")
+	scope, err := members.scopeFilter(acme, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := bson.M{"from": "scopedMembers", "localField": "memberId", "foreignField": "_id", "as": "member"}
+	stages, err := members.scopeLookups(acme, mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{"from": "scopedMembers", "localField": "memberId", "foreignField": "_id", "as": "member",
+			"pipeline": bson.A{bson.M{"$project": bson.M{"name": 1}}}}}},
+		{{Key: "$facet", Value: bson.M{"members": bson.A{bson.M{"$lookup": lookup}}}}},
+		{{Key: "$unionWith", Value: "scopedMembers"}},
+		{{Key: "$graphLookup", Value: bson.M{"from": "scopedMembers", "startWith": "$managerId", "connectFromField": "managerId",
+			"connectToField": "_id", "as": "managers", "restrictSearchWithMatch": bson.M{"name": "x"}}}},
+		{{Key: "$lookup", Value: bson.M{"from": "others", "localField": "otherId", "foreignField": "_id", "as": "other"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := toDocument(bson.M{"$match": scope})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range []string{"$lookup.pipeline.0", "$facet.members.0.$lookup.pipeline.0", "$unionWith.pipeline.0"} {
+		stage, _ := lookupValue(stages[i], path)
+		if match, ok := stage.(bson.D); !ok || !documentsEqual(match, expected) {
+			t.Errorf("expected scope $match at %s, got %v", path, stages[i])
+		}
+	}
+	if project, _ := lookupValue(stages[0], "$lookup.pipeline.1.$project.name"); project != int32(1) {
+		t.Errorf("expected the $lookup pipeline to be kept after the scope, got %v", stages[0])
+	}
+	graphLookup, err := toDocument(stages[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restrict, _ := lookupValue(graphLookup, "$graphLookup.restrictSearchWithMatch.$and.1.tenant"); restrict != "acme" {
+		t.Errorf("expected scoped restrictSearchWithMatch, got %v", stages[3])
+	}
+	if _, ok := lookupValue(stages[4], "$lookup.pipeline"); ok {
+		t.Errorf("expected lookup of a collection without scopes to be kept, got %v", stages[4])
+	}
+
+	if _, err := members.scopeLookups(context.Background(), mongo.Pipeline{{{Key: "$unionWith", Value: "scopedMembers"}}}); !errors.Is(err, ErrNoTenant) {
+		t.Errorf("expected ErrNoTenant, got %v", err)
+	}
+	for _, stage := range []bson.D{
+		{{Key: "$lookup", Value: "scopedMembers"}},
+		{{Key: "$facet", Value: bson.M{"members": "x"}}},
+		{{Key: "$unionWith", Value: bson.M{"coll": "scopedMembers", "pipeline": bson.A{"x"}}}},
+	} {
+		if _, err := members.scopeLookups(acme, mongo.Pipeline{stage}); err == nil {
+			t.Errorf("expected error of %v", stage)
+		}
+	}
+	// raw stages of an aggregation are normalized instead of panicking
+	var joined []bson.M
+	if err := members.Aggregate().Stage(bson.D{{Key: "$lookup", Value: lookup}}).All(acme, &joined); err != nil {
+		t.Errorf("aggregate with a raw $lookup: %v", err)
+	}
+}
@@ -0,0 +1,407 @@
+package goose
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Format document format of Export and Import
+type Format string
+
+// export and import formats
+const (
+	// FormatCanonicalExtJSON a JSON array of canonical Extended JSON documents, types are kept exactly
+	FormatCanonicalExtJSON Format = "canonical"
+	// FormatRelaxedExtJSON a JSON array of relaxed Extended JSON documents, numbers are plain JSON numbers
+	FormatRelaxedExtJSON Format = "relaxed"
+	// FormatNDJSON one relaxed Extended JSON document per line, like mongoexport and the goose command
+	FormatNDJSON Format = "ndjson"
+	// FormatCSV a header row of bson field names and a row per document, nested documents and arrays are Extended JSON
+	FormatCSV Format = "csv"
+)
+
+// ImportOptions options of Import
+type ImportOptions struct {
+	// Upsert replace the documents with the primary keys of imported documents instead of inserting them
+	Upsert bool
+	// BatchSize documents written by one bulk write, default is 1000
+	BatchSize int
+	// Unordered import every valid document whatever errors, an ordered import stops at the first error
+	Unordered bool
+}
+
+// Export write the documents matched by filter with default scopes and query scopes to w, return the number of documents.
+// JSON formats write the stored documents, CSV writes the columns of the model struct
+func (model *Model) Export(ctx context.Context, w io.Writer, format Format, filter interface{}) (n int64, err error) {
+	ctx, finish := model.startOperation(ctx, "Export")
+	defer func() { finish(err) }()
+	encode, closeWriter, err := model.documentWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if filter, err = model.query().scopeFilter(ctx, filter); err != nil {
+		return 0, err
+	}
+	cur, err := model.collection.Find(ctx, filter)
+	if err != nil {
+		return 0, model.translateError(err)
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		if err := encode(cur.Raw()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := cur.Err(); err != nil {
+		return n, model.translateError(err)
+	}
+	return n, closeWriter()
+}
+
+// documentWriter return a function writing a document in format to w, and a function ending the output
+func (model *Model) documentWriter(w io.Writer, format Format) (func(bson.Raw) error, func() error, error) {
+	switch format {
+	case FormatCanonicalExtJSON, FormatRelaxedExtJSON:
+		canonical := format == FormatCanonicalExtJSON
+		first := true
+		encode := func(raw bson.Raw) error {
+			data, err := bson.MarshalExtJSON(raw, canonical, false)
+			if err != nil {
+				return err
+			}
+			separator := ",\n"
+			if first {
+				separator, first = "[\n", false
+			}
+			_, err = fmt.Fprintf(w, "%s%s", separator, data)
+			return err
+		}
+		closeWriter := func() error {
+			end := "\n]\n"
+			if first {
+				end = "[]\n"
+			}
+			_, err := io.WriteString(w, end)
+			return err
+		}
+		return encode, closeWriter, nil
+	case FormatNDJSON:
+		encode := func(raw bson.Raw) error {
+			data, err := bson.MarshalExtJSON(raw, false, false)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s\n", data)
+			return err
+		}
+		return encode, func() error { return nil }, nil
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		columns := bsonFieldNames(model.typ)
+		if err := writer.Write(columns); err != nil {
+			return nil, nil, err
+		}
+		row := make([]string, len(columns))
+		encode := func(raw bson.Raw) error {
+			for i, column := range columns {
+				row[i] = csvCell(raw.Lookup(column))
+			}
+			return writer.Write(row)
+		}
+		closeWriter := func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		return encode, closeWriter, nil
+	default:
+		return nil, nil, fmt.Errorf("goose: unknown format %q", format)
+	}
+}
+
+// csvCell format a value for a CSV cell, missing and null values are empty
+func csvCell(value bson.RawValue) string {
+	switch value.Type {
+	case 0, bsontype.Null, bsontype.Undefined:
+		return ""
+	case bsontype.String:
+		return value.StringValue()
+	case bsontype.Int32:
+		return strconv.FormatInt(int64(value.Int32()), 10)
+	case bsontype.Int64:
+		return strconv.FormatInt(value.Int64(), 10)
+	case bsontype.Double:
+		return strconv.FormatFloat(value.Double(), 'g', -1, 64)
+	case bsontype.Boolean:
+		return strconv.FormatBool(value.Boolean())
+	case bsontype.DateTime:
+		return value.Time().UTC().Format(time.RFC3339Nano)
+	case bsontype.ObjectID:
+		return value.ObjectID().Hex()
+	default:
+		return extJSONValue(value)
+	}
+}
+
+// bsonFieldNames bson names of the exported fields of struct type t, fields of inline structs are included
+func bsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tags, err := bsoncodec.DefaultStructTagParser(field)
+		if err != nil || tags.Skip {
+			continue
+		}
+		if tags.Inline {
+			if inline := valueType(field.Type); inline.Kind() == reflect.Struct {
+				names = append(names, bsonFieldNames(inline)...)
+			}
+			continue
+		}
+		names = append(names, tags.Name)
+	}
+	return names
+}
+
+// Import read documents in format from r and insert them, or replace them by primary key with Upsert, in bulk writes of
+// opts.BatchSize. Documents are decoded to the model struct, so fields not in the struct are dropped, and written like
+// Bulk.Insert and Bulk.Save with hooks, defaults and validation, except that imported createdAt and updatedAt values
+// are kept. Documents which fail to decode or write are returned as BulkErrors indexed by their position in r,
+// a malformed input stops the import
+func (model *Model) Import(ctx context.Context, r io.Reader, format Format, opts *ImportOptions) (result *BulkResult, err error) {
+	ctx, finish := model.startOperation(ctx, "Import")
+	defer func() { finish(err) }()
+	ctx = context.WithValue(ctx, keepTimestampsKey{}, true)
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBulkBatchSize
+	}
+	read, err := model.documentReader(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &BulkResult{InsertedIDs: map[int]interface{}{}, UpsertedIDs: map[int]interface{}{}}
+	var errs BulkErrors
+	newBulk := func() *Bulk {
+		bulk := model.Bulk().BatchSize(batchSize)
+		if opts.Unordered {
+			bulk = bulk.Unordered()
+		}
+		return bulk
+	}
+	bulk := newBulk()
+	// indexes document indexes of the operations of bulk
+	var indexes []int
+	// flush write the pending documents, it returns false if an ordered import must stop
+	flush := func() (bool, error) {
+		if bulk.Len() == 0 {
+			return true, nil
+		}
+		bulkResult, err := bulk.Exec(ctx)
+		var bulkErrs BulkErrors
+		if err != nil && !errors.As(err, &bulkErrs) {
+			return false, err
+		}
+		result.InsertedCount += bulkResult.InsertedCount
+		result.MatchedCount += bulkResult.MatchedCount
+		result.ModifiedCount += bulkResult.ModifiedCount
+		result.UpsertedCount += bulkResult.UpsertedCount
+		for i, id := range bulkResult.InsertedIDs {
+			result.InsertedIDs[indexes[i]] = id
+		}
+		for i, id := range bulkResult.UpsertedIDs {
+			result.UpsertedIDs[indexes[i]] = id
+		}
+		for _, bulkErr := range bulkErrs {
+			errs = append(errs, &BulkError{Index: indexes[bulkErr.Index], Err: bulkErr.Err})
+		}
+		bulk, indexes = newBulk(), nil
+		return len(bulkErrs) == 0 || opts.Unordered, nil
+	}
+
+	for index := 0; ; index++ {
+		raw, err := read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("goose: import %s document %d: %w", model.collectionName, index, err)
+		}
+		// imported documents are upgraded like stored ones, but not written back over the stored documents
+		doc, err := model.decodeUpgraded(ctx, raw, false)
+		if err != nil {
+			errs = append(errs, &BulkError{Index: index, Err: err})
+			if !opts.Unordered {
+				break
+			}
+			continue
+		}
+		if opts.Upsert {
+			bulk.Save(doc.Value())
+		} else {
+			bulk.Insert(doc.Value())
+		}
+		indexes = append(indexes, index)
+		if bulk.Len() == batchSize {
+			more, err := flush()
+			if err != nil {
+				return result, err
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	if _, err := flush(); err != nil {
+		return result, err
+	}
+	if len(errs) == 0 {
+		return result, nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+	if !opts.Unordered {
+		errs = errs[:1]
+	}
+	return result, errs
+}
+
+// documentReader return a function reading the next document in format from r, it returns io.EOF after the last document
+func (model *Model) documentReader(r io.Reader, format Format) (func() (bson.Raw, error), error) {
+	switch format {
+	case FormatCanonicalExtJSON, FormatRelaxedExtJSON:
+		canonical := format == FormatCanonicalExtJSON
+		decoder := json.NewDecoder(r)
+		started := false
+		return func() (bson.Raw, error) {
+			if !started {
+				started = true
+				token, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				if delim, ok := token.(json.Delim); !ok || delim != '[' {
+					return nil, errors.New("expected a JSON array of documents")
+				}
+			}
+			if !decoder.More() {
+				if _, err := decoder.Token(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			var data json.RawMessage
+			if err := decoder.Decode(&data); err != nil {
+				return nil, err
+			}
+			var raw bson.Raw
+			err := bson.UnmarshalExtJSON(data, canonical, &raw)
+			return raw, err
+		}, nil
+	case FormatNDJSON:
+		reader := bufio.NewReader(r)
+		return func() (bson.Raw, error) {
+			for {
+				line, err := reader.ReadBytes('\n')
+				if len(bytes.TrimSpace(line)) > 0 {
+					var raw bson.Raw
+					if err := bson.UnmarshalExtJSON(line, false, &raw); err != nil {
+						return nil, err
+					}
+					return raw, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}, nil
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if err == io.EOF {
+			return func() (bson.Raw, error) { return nil, io.EOF }, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		types := make([]reflect.Type, len(header))
+		for i, column := range header {
+			t, ok := structFieldType(model.typ, column)
+			if !ok {
+				return nil, fmt.Errorf("goose: unknown CSV column %q of %s", column, model.collectionName)
+			}
+			types[i] = t
+		}
+		return func() (bson.Raw, error) {
+			record, err := reader.Read()
+			if err != nil {
+				return nil, err
+			}
+			doc := bson.D{}
+			for i, cell := range record {
+				if cell == "" {
+					continue
+				}
+				value, err := parseCell(cell, types[i])
+				if err != nil {
+					return nil, fmt.Errorf("column %s: %w", header[i], err)
+				}
+				doc = append(doc, bson.E{Key: header[i], Value: value})
+			}
+			return bson.Marshal(doc)
+		}, nil
+	default:
+		return nil, fmt.Errorf("goose: unknown format %q", format)
+	}
+}
+
+// parseCell parse a CSV cell written by csvCell for a struct field of type t
+func parseCell(cell string, t reflect.Type) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}) || t == reflect.TypeOf(primitive.DateTime(0)):
+		return time.Parse(time.RFC3339Nano, cell)
+	case t == objectIDType:
+		return primitive.ObjectIDFromHex(cell)
+	case t.Kind() == reflect.String:
+		return cell, nil
+	case isIntKind(t.Kind()) || isUintKind(t.Kind()):
+		return strconv.ParseInt(cell, 10, 64)
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return strconv.ParseFloat(cell, 64)
+	case t.Kind() == reflect.Bool:
+		return strconv.ParseBool(cell)
+	default:
+		var raw bson.Raw
+		if err := bson.UnmarshalExtJSON([]byte(`{"v":`+cell+`}`), false, &raw); err != nil {
+			return nil, err
+		}
+		return raw.Lookup("v"), nil
+	}
+}
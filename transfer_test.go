@@ -0,0 +1,124 @@
+package goose
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExportImport(t *testing.T) {
+	type Location struct {
+		City string `bson:"city"`
+	}
+	type Customer struct {
+		ID        primitive.ObjectID `goose:"primary" bson:"_id"`
+		Email     string             `goose:"unique" bson:"email" validate:"required,email"`
+		Balance   int64              `bson:"balance"`
+		Rate      float64            `bson:"rate"`
+		Active    bool               `bson:"active"`
+		Tags      []string           `bson:"tags"`
+		Location  *Location          `bson:"location"`
+		CreatedAt time.Time          `goose:"createdAt" bson:"createdAt"`
+		UpdatedAt time.Time          `goose:"updatedAt" bson:"updatedAt"`
+	}
+	ctx := context.Background()
+	formats := []Format{FormatCanonicalExtJSON, FormatRelaxedExtJSON, FormatNDJSON, FormatCSV}
+	for _, format := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			NewMemoryDatabase()
+			customers, err := NewModel("transferCustomers", &Customer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, customer := range []*Customer{
+				{Email: "a@example.com", Balance: 1 << 40, Rate: 0.5, Active: true, Tags: []string{"vip"}, Location: &Location{City: "Lyon"}},
+				{Email: "b@example.com", Balance: 2},
+			} {
+				if _, err := customers.InsertOne(ctx, customer); err != nil {
+					t.Fatal(err)
+				}
+			}
+			doc, err := customers.FindOne(ctx, bson.M{"email": "a@example.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			original := doc.Value().(*Customer)
+			time.Sleep(2 * time.Millisecond)
+			var buf bytes.Buffer
+			if n, err := customers.Export(ctx, &buf, format, nil); err != nil || n != 2 {
+				t.Fatalf("export: %d %v", n, err)
+			}
+			exported := buf.String()
+
+			NewMemoryDatabase()
+			customers, err = NewModel("transferCustomers", &Customer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := customers.Import(ctx, strings.NewReader(exported), format, &ImportOptions{BatchSize: 1})
+			if err != nil || result.InsertedCount != 2 {
+				t.Fatalf("import: %+v %v\n%s", result, err, exported)
+			}
+			doc, err = customers.FindOne(ctx, bson.M{"email": "a@example.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			customer := doc.Value().(*Customer)
+			if customer.Balance != 1<<40 || customer.Rate != 0.5 || !customer.Active || len(customer.Tags) != 1 ||
+				customer.Location == nil || customer.Location.City != "Lyon" {
+				t.Errorf("unexpected imported customer %+v", customer)
+			}
+			if !customer.CreatedAt.Equal(original.CreatedAt) || !customer.UpdatedAt.Equal(original.UpdatedAt) {
+				t.Errorf("expected imported timestamps %v %v, got %v %v", original.CreatedAt, original.UpdatedAt, customer.CreatedAt, customer.UpdatedAt)
+			}
+
+			// importing again with upsert replaces documents by primary key
+			if result, err := customers.Import(ctx, strings.NewReader(exported), format, &ImportOptions{Upsert: true}); err != nil || result.MatchedCount != 2 {
+				t.Errorf("upsert import: %+v %v", result, err)
+			}
+			if n, err := customers.Count(ctx, nil); err != nil || n != 2 {
+				t.Errorf("expected 2 customers, got %d %v", n, err)
+			}
+			if doc, err := customers.FindOne(ctx, bson.M{"email": "a@example.com"}); err != nil ||
+				!doc.Value().(*Customer).UpdatedAt.Equal(original.UpdatedAt) || !doc.Value().(*Customer).CreatedAt.Equal(original.CreatedAt) {
+				t.Errorf("expected upsert import to keep timestamps, got %v", err)
+			}
+			// inserting again fails on the unique _id
+			_, err = customers.Import(ctx, strings.NewReader(exported), format, &ImportOptions{Unordered: true})
+			var bulkErrs BulkErrors
+			if !errors.As(err, &bulkErrs) || len(bulkErrs) != 2 || bulkErrs[1].Index != 1 || !errors.Is(err, ErrDuplicateKey) {
+				t.Errorf("expected duplicate key errors, got %v", err)
+			}
+		})
+	}
+
+	NewMemoryDatabase()
+	customers, err := NewModel("transferCustomers", &Customer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "email,balance\nc@example.com,3\ninvalid,4\nd@example.com,5\n"
+	result, err := customers.Import(ctx, strings.NewReader(input), FormatCSV, nil)
+	var bulkErrs BulkErrors
+	if !errors.As(err, &bulkErrs) || len(bulkErrs) != 1 || bulkErrs[0].Index != 1 || !errors.Is(err, ErrValidation) {
+		t.Errorf("expected validation error of document 1, got %v", err)
+	}
+	if result.InsertedCount != 1 || result.InsertedIDs[0] == nil {
+		t.Errorf("expected ordered import to stop after document 0, got %+v", result)
+	}
+	if _, err := customers.Import(ctx, strings.NewReader("mail\nx\n"), FormatCSV, nil); err == nil {
+		t.Error("expected unknown column error")
+	}
+	if _, err := customers.Import(ctx, strings.NewReader("{\"email\": \"e@example.com\"}\n{"), FormatNDJSON, nil); err == nil {
+		t.Error("expected malformed document error")
+	}
+	if _, err := customers.Export(ctx, &bytes.Buffer{}, Format("xml"), nil); err == nil {
+		t.Error("expected unknown format error")
+	}
+}
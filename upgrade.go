@@ -0,0 +1,136 @@
+package goose
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SchemaUpgrade upgrade a document from version N to N+1 in place. doc is the stored document without
+// populated fields, the schemaVersion field is set to N+1 after it returns
+type SchemaUpgrade func(doc bson.M) error
+
+var (
+	schemaUpgradesMu sync.RWMutex
+	schemaUpgrades   = map[reflect.Type]map[int64]SchemaUpgrade{}
+)
+
+// RegisterSchemaUpgrade register the upgrade of documents from version `from` to from+1 for the model struct of value,
+// a pointer to the struct with a `goose:"schemaVersion=N"` field. Documents older than N are upgraded when they are
+// decoded by finds, documents without the schemaVersion field are version 1
+func RegisterSchemaUpgrade(value interface{}, from int64, upgrade SchemaUpgrade) {
+	t := reflect.TypeOf(value)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	schemaUpgradesMu.Lock()
+	defer schemaUpgradesMu.Unlock()
+	if schemaUpgrades[t] == nil {
+		schemaUpgrades[t] = map[int64]SchemaUpgrade{}
+	}
+	schemaUpgrades[t][from] = upgrade
+}
+
+func getSchemaUpgrade(t reflect.Type, from int64) (SchemaUpgrade, bool) {
+	schemaUpgradesMu.RLock()
+	defer schemaUpgradesMu.RUnlock()
+	upgrade, ok := schemaUpgrades[t][from]
+	return upgrade, ok
+}
+
+// upgradeDocument run schema upgrades on a raw document older than the model schemaVersion.
+// Documents newer than the model, such as written by a newer release during a rolling deploy, are not changed.
+// Upgraded documents are saved if writeBack is true
+func (model *Model) upgradeDocument(ctx context.Context, raw bson.Raw, writeBack bool) (bson.Raw, error) {
+	field := model.schemaVersionField
+	if field == nil {
+		return raw, nil
+	}
+	version := int64(1)
+	stored, err := raw.LookupErr(field.BsonName)
+	missing := err != nil
+	if !missing {
+		if err := stored.Unmarshal(&version); err != nil {
+			return nil, fmt.Errorf("goose: invalid schemaVersion of %s document: %w", model.collectionName, err)
+		}
+	}
+	if version >= model.schemaVersion {
+		return raw, nil
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	populated := bson.M{}
+	for _, relation := range model.relationship {
+		if value, ok := doc[relation.as]; ok {
+			populated[relation.as] = value
+			delete(doc, relation.as)
+		}
+	}
+	storedKeys := make([]string, 0, len(doc))
+	for key := range doc {
+		storedKeys = append(storedKeys, key)
+	}
+	for from := version; from < model.schemaVersion; from++ {
+		upgrade, ok := getSchemaUpgrade(model.typ, from)
+		if !ok {
+			return nil, fmt.Errorf("goose: no schema upgrade of %s from version %d", model.typ, from)
+		}
+		if err := upgrade(doc); err != nil {
+			return nil, fmt.Errorf("goose: upgrade %s document from version %d: %w", model.collectionName, from, err)
+		}
+		doc[field.BsonName] = from + 1
+	}
+	if writeBack {
+		model.writeBackUpgrade(ctx, doc, storedKeys, version, missing)
+	}
+	for key, value := range populated {
+		doc[key] = value
+	}
+	return bson.Marshal(doc)
+}
+
+// writeBackUpgrade save an upgraded document if it is still in the stored version, failures are logged
+// because the document is upgraded again on the next read
+func (model *Model) writeBackUpgrade(ctx context.Context, doc bson.M, storedKeys []string, version int64, missing bool) {
+	id, ok := doc[model.primaryKey]
+	if !ok {
+		return
+	}
+	filter := bson.M{model.primaryKey: id, model.schemaVersionField.BsonName: version}
+	if missing {
+		filter[model.schemaVersionField.BsonName] = bson.M{"$exists": false}
+	}
+	set := bson.M{}
+	for key, value := range doc {
+		if key != model.primaryKey {
+			set[key] = value
+		}
+	}
+	update := bson.M{"$set": set}
+	unset := bson.M{}
+	for _, key := range storedKeys {
+		if _, ok := doc[key]; !ok {
+			unset[key] = ""
+		}
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if _, err := model.collection.UpdateOne(ctx, filter, update); err != nil {
+		model.logger().Warn("write back upgraded document failed.", "collection", model.collectionName, "id", id, "error", err)
+	}
+}
+
+// logger the logger of the model database
+func (model *Model) logger() Logger {
+	if model.database != nil {
+		return model.database.Logger()
+	}
+	return GetLogger()
+}
@@ -0,0 +1,104 @@
+package goose
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSchemaUpgrade(t *testing.T) {
+	type Customer struct {
+		ID            int64  `goose:"primary" bson:"_id"`
+		FirstName     string `bson:"firstName"`
+		LastName      string `bson:"lastName"`
+		Country       string `bson:"country"`
+		SchemaVersion int    `goose:"schemaVersion=3,writeBack" bson:"schemaVersion"`
+	}
+	RegisterSchemaUpgrade(&Customer{}, 1, func(doc bson.M) error {
+		name, _ := doc["name"].(string)
+		parts := strings.SplitN(name, " ", 2)
+		if len(parts) != 2 {
+			return errors.New("invalid name")
+		}
+		doc["firstName"], doc["lastName"] = parts[0], parts[1]
+		delete(doc, "name")
+		return nil
+	})
+	RegisterSchemaUpgrade(&Customer{}, 2, func(doc bson.M) error {
+		if _, ok := doc["country"]; !ok {
+			doc["country"] = "unknown"
+		}
+		return nil
+	})
+	NewMemoryDatabase()
+	ctx := context.Background()
+	customers := getCollection("customers")
+	for _, doc := range []bson.M{
+		{"_id": int64(1), "name": "Ada Lovelace"},
+		{"_id": int64(2), "firstName": "Alan", "lastName": "Turing", "schemaVersion": 2},
+		{"_id": int64(3), "firstName": "Grace", "lastName": "Hopper", "country": "US", "schemaVersion": 4},
+		{"_id": int64(4), "name": "Plato"},
+	} {
+		if _, err := customers.InsertOne(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	model, err := NewModel("customers", &Customer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := model.Find(ctx, bson.M{"_id": bson.M{"$lt": 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ada, alan, grace := docs[0].Value().(*Customer), docs[1].Value().(*Customer), docs[2].Value().(*Customer)
+	if ada.FirstName != "Ada" || ada.LastName != "Lovelace" || ada.Country != "unknown" || ada.SchemaVersion != 3 {
+		t.Errorf("unexpected upgraded customer %+v", ada)
+	}
+	if alan.Country != "unknown" || alan.SchemaVersion != 3 {
+		t.Errorf("unexpected upgraded customer %+v", alan)
+	}
+	if grace.SchemaVersion != 4 || grace.Country != "US" {
+		t.Errorf("newer document should not be changed %+v", grace)
+	}
+
+	// upgraded documents are written back
+	raw, err := customers.FindOne(ctx, bson.M{"_id": int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.LookupErr("name"); err == nil || raw.Lookup("lastName").StringValue() != "Lovelace" || raw.Lookup("schemaVersion").Int64() != 3 {
+		t.Errorf("unexpected written back document %v", raw)
+	}
+
+	if _, err := model.FindOneByID(ctx, 4); err == nil || !strings.Contains(err.Error(), "invalid name") {
+		t.Errorf("expected upgrade error, got %v", err)
+	}
+
+	inserted := &Customer{FirstName: "Edsger", LastName: "Dijkstra"}
+	if _, err := model.InsertOne(ctx, inserted); err != nil {
+		t.Fatal(err)
+	}
+	if inserted.SchemaVersion != 3 {
+		t.Errorf("expected new document in current version, got %d", inserted.SchemaVersion)
+	}
+
+	// imported documents are upgraded without writing them back over the stored document with the same id
+	if _, err := customers.InsertOne(ctx, bson.M{"_id": int64(5), "name": "Alan Kay"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.Import(ctx, strings.NewReader(`{"_id": 5, "name": "Barbara Liskov"}`), FormatNDJSON, nil); !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("expected duplicate key error, got %v", err)
+	}
+	raw, err = customers.FindOne(ctx, bson.M{"_id": int64(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := raw.Lookup("name").StringValueOK(); name != "Alan Kay" {
+		t.Errorf("expected stored document to be unchanged, got %v", raw)
+	}
+}